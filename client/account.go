@@ -0,0 +1,77 @@
+package client
+
+import (
+	"time"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+// The IRCv3 capabilities that tell us which services account users are
+// logged in to. account-notify sends ACCOUNT messages when users log in
+// or out, extended-join adds the account and real name to JOINs, and
+// account-tag adds an account tag to messages from logged in users.
+const (
+	accountNotifyCap = "account-notify"
+	extendedJoinCap  = "extended-join"
+	accountTagCap    = "account-tag"
+)
+
+// accountTag is the tag containing the account of a message's sender.
+const accountTag = "account"
+
+// LOGGEDIN and LOGGEDOUT events are dispatched when the state tracker
+// learns that a nick has logged in to or out of a services account.
+// Line.Nick is the nick, and Line.Args contains the nick and the account.
+// The account is available from the tracker as state.Nick.Account.
+const (
+	LOGGEDIN  = "LOGGEDIN"
+	LOGGEDOUT = "LOGGEDOUT"
+)
+
+// setAccount records the account a nick is logged in to, where "" or "*"
+// means the nick is logged out, and dispatches an event if it changed.
+func (conn *Conn) setAccount(nick, account string) {
+	if account == "*" {
+		account = ""
+	}
+	nk := conn.st.GetNick(nick)
+	if nk == nil || nk.Account == account {
+		return
+	}
+	conn.st.NickAccount(nk.Nick, account)
+	ev := &Line{Cmd: LOGGEDIN, Nick: nk.Nick, Args: []string{nk.Nick, account}, Time: time.Now()}
+	if account == "" {
+		ev.Cmd, ev.Args[1] = LOGGEDOUT, nk.Account
+	}
+	conn.dispatch(ev)
+}
+
+// Handle ACCOUNT messages from account-notify
+//
+//	:nick!user@host ACCOUNT <account>
+func (conn *Conn) h_ACCOUNT(line *Line) {
+	if len(line.Args) == 0 {
+		return
+	}
+	conn.setAccount(line.Nick, line.Args[0])
+}
+
+// Handle the account tag on messages from account-tag
+func (conn *Conn) h_ACCOUNTTAG(line *Line) {
+	if acct, ok := line.Tag(accountTag); ok && line.Nick != "" {
+		conn.setAccount(line.Nick, acct)
+	}
+}
+
+// Handle 330 whois reply (nick logged in to account)
+func (conn *Conn) h_330(line *Line) {
+	if !line.argslen(2) {
+		return
+	}
+	if nk := conn.st.GetNick(line.Args[1]); nk != nil {
+		conn.setAccount(nk.Nick, line.Args[2])
+	} else {
+		logging.Warn("irc.330(): received WHOIS account info for unknown nick %s",
+			line.Args[1])
+	}
+}
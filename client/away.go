@@ -0,0 +1,69 @@
+package client
+
+import "time"
+
+// awayNotifyCap is the IRCv3 capability that sends AWAY messages when
+// users on our channels go away or come back.
+const awayNotifyCap = "away-notify"
+
+// AWAYCHANGED events are dispatched when the state tracker learns that a
+// nick has gone away or come back. Line.Nick is the nick, and Line.Args
+// contains the nick, followed by the away message if the nick is away.
+// The away status is available from the tracker as state.Nick.Away.
+const AWAYCHANGED = "AWAYCHANGED"
+
+// setAway records whether a nick is away, and dispatches an event if
+// that changed. If the message isn't known, any previous one is kept.
+func (conn *Conn) setAway(nick string, away bool, msg string) {
+	nk := conn.st.GetNick(nick)
+	if nk == nil {
+		return
+	}
+	switch {
+	case !away:
+		msg = ""
+	case msg == "":
+		msg = nk.AwayMsg
+	}
+	if nk.Away == away && nk.AwayMsg == msg {
+		return
+	}
+	conn.st.NickAway(nk.Nick, away, msg)
+	ev := &Line{Cmd: AWAYCHANGED, Nick: nk.Nick, Args: []string{nk.Nick}, Time: time.Now()}
+	if away {
+		ev.Args = append(ev.Args, msg)
+	}
+	conn.dispatch(ev)
+}
+
+// Handle AWAY messages from away-notify
+//
+//	:nick!user@host AWAY :<message>
+//	:nick!user@host AWAY
+func (conn *Conn) h_AWAY(line *Line) {
+	conn.setAway(line.Nick, line.Text() != "", line.Text())
+}
+
+// Handle 301 away reply, sent in WHOIS replies and when messaging a nick
+// that is away
+func (conn *Conn) h_301(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
+	msg := ""
+	if line.argslen(2) {
+		msg = line.Args[2]
+	}
+	conn.setAway(line.Args[1], true, msg)
+}
+
+// Handle 305 reply, we are no longer away
+func (conn *Conn) h_305(line *Line) {
+	conn.setAway(conn.Me().Nick, false, "")
+}
+
+// Handle 306 reply, we are now away with the message we sent
+func (conn *Conn) h_306(line *Line) {
+	msg, _ := conn.awayMsg.Load().(string)
+	conn.setAway(conn.Me().Nick, true, msg)
+}
@@ -0,0 +1,225 @@
+package client
+
+import (
+	"sync"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+// batchCap is the IRCv3 capability that allows servers to group lines
+// into batches.
+const batchCap = "batch"
+
+// batchTag is the tag that marks a line as part of a batch.
+const batchTag = "batch"
+
+// A Batch is a group of lines sent by the server between "BATCH +ref" and
+// "BATCH -ref", e.g. the QUITs from a netsplit or the messages returned
+// by a CHATHISTORY request. Completed batches are dispatched as BATCH
+// events, with the Batch in Line.Batch and Line.Args containing the
+// batch's type followed by its parameters. For example:
+//
+//	conn.HandleFunc(client.BATCH, func(conn *client.Conn, line *client.Line) {
+//		if line.Batch.Type == "netsplit" {
+//			log.Printf("%d users lost in netsplit", len(line.Batch.Lines))
+//		}
+//	})
+//
+// Multiline messages sent as draft/multiline batches are not dispatched as
+// BATCH events, but reassembled into a single PRIVMSG or NOTICE, with the
+// batch in Line.Batch.
+//
+// A Batch and its lines must not be modified by handlers.
+type Batch struct {
+	// The reference tag of the batch, unique while it is open.
+	Ref string
+	// The type and parameters of the batch, e.g. "netsplit" and the
+	// names of the servers that split.
+	Type   string
+	Params []string
+	// The tags sent with the BATCH line that opened the batch.
+	Tags map[string]string
+	// The lines in the batch, in the order they were received. Lines
+	// in nested batches are not included here, except for multiline
+	// messages, which are included as a single reassembled message.
+	Lines []*Line
+	// Batches nested within this one, in the order they were completed.
+	// Nested batches are not dispatched as separate BATCH events.
+	Batches []*Batch
+
+	parent *Batch
+	// Whether the batch's lines are also dispatched individually.
+	dispatch bool
+	// The order in which the batch was opened, to find the oldest.
+	seq uint64
+}
+
+// Default limits on open batches; see Config.MaxOpenBatches.
+const (
+	defaultMaxOpenBatches = 100
+	defaultMaxBatchLines  = 10000
+)
+
+// batchTracker keeps track of the batches the server has opened.
+type batchTracker struct {
+	mu   sync.Mutex
+	open map[string]*Batch
+	seq  uint64
+}
+
+func newBatchTracker() *batchTracker {
+	return &batchTracker{open: make(map[string]*Batch)}
+}
+
+// reset discards any open batches, which will never be completed.
+func (bt *batchTracker) reset() {
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	if len(bt.open) > 0 {
+		logging.Warn("irc.batch(): discarding %d unterminated batches", len(bt.open))
+	}
+	bt.open = make(map[string]*Batch)
+}
+
+// discardOldest discards the batch that has been open the longest.
+// It must be called with mu held.
+func (bt *batchTracker) discardOldest() {
+	var oldest *Batch
+	for _, b := range bt.open {
+		if oldest == nil || b.seq < oldest.seq {
+			oldest = b
+		}
+	}
+	if oldest != nil {
+		logging.Warn("irc.batch(): too many open batches, discarding %q", oldest.Ref)
+		delete(bt.open, oldest.Ref)
+	}
+}
+
+// batchLimits returns the maximum number of open batches and lines in
+// each batch.
+func (conn *Conn) batchLimits() (maxOpen, maxLines int) {
+	maxOpen, maxLines = conn.cfg.MaxOpenBatches, conn.cfg.MaxBatchLines
+	if maxOpen <= 0 {
+		maxOpen = defaultMaxOpenBatches
+	}
+	if maxLines <= 0 {
+		maxLines = defaultMaxBatchLines
+	}
+	return maxOpen, maxLines
+}
+
+// defaultBatchDispatch holds the batch types whose lines are not
+// dispatched individually unless Config.BatchDispatch says otherwise.
+var defaultBatchDispatch = map[string]bool{
+	chatHistoryBatch: false,
+	multilineBatch:   false,
+}
+
+// dispatchMembers returns true if lines in batches of type typ should be
+// dispatched individually as well as in the BATCH event.
+func (conn *Conn) dispatchMembers(typ string) bool {
+	if d, ok := conn.cfg.BatchDispatch[typ]; ok {
+		return d
+	}
+	if d, ok := defaultBatchDispatch[typ]; ok {
+		return d
+	}
+	return true
+}
+
+// batch processes a line received from the server, keeping track of
+// batches. It returns true if the line should be dispatched, and a
+// BATCH event to dispatch if the line completed a batch.
+func (conn *Conn) batch(line *Line) (bool, *Line) {
+	if line.Batch != nil {
+		// Our own BATCH event or reassembled multiline message.
+		return true, nil
+	}
+	bt := conn.batches
+	bt.mu.Lock()
+	defer bt.mu.Unlock()
+	maxOpen, maxLines := conn.batchLimits()
+
+	var parent *Batch
+	if ref, ok := line.Tag(batchTag); ok {
+		if parent = bt.open[ref]; parent == nil {
+			logging.Warn("irc.batch(): %s line in unknown batch %q", line.Cmd, ref)
+		}
+	}
+
+	if line.Cmd != BATCH || len(line.Args) == 0 || len(line.Args[0]) < 2 {
+		if parent == nil {
+			return true, nil
+		}
+		if len(parent.Lines) >= maxLines {
+			logging.Warn("irc.batch(): batch %q has more than %d lines, discarding it",
+				parent.Ref, maxLines)
+			delete(bt.open, parent.Ref)
+			return true, nil
+		}
+		// The line must be copied because it is returned to the pool
+		// after dispatch.
+		parent.Lines = append(parent.Lines, line.Copy())
+		return parent.dispatch, nil
+	}
+
+	ref := line.Args[0][1:]
+	switch line.Args[0][0] {
+	case '+':
+		if !line.argslen(1) {
+			logging.Warn("irc.batch(): batch %q has no type", ref)
+			return false, nil
+		}
+		l := line.Copy()
+		b := &Batch{
+			Ref:      ref,
+			Type:     l.Args[1],
+			Params:   l.Args[2:],
+			Tags:     l.Tags,
+			parent:   parent,
+			dispatch: conn.dispatchMembers(l.Args[1]),
+		}
+		if parent != nil {
+			b.dispatch = b.dispatch && parent.dispatch
+		}
+		if _, ok := bt.open[ref]; ok {
+			logging.Warn("irc.batch(): batch %q opened twice", ref)
+		} else if len(bt.open) >= maxOpen {
+			bt.discardOldest()
+		}
+		bt.seq++
+		b.seq = bt.seq
+		bt.open[ref] = b
+	case '-':
+		b, ok := bt.open[ref]
+		if !ok {
+			logging.Warn("irc.batch(): end of unknown batch %q", ref)
+			return false, nil
+		}
+		delete(bt.open, ref)
+		if b.Type == multilineBatch {
+			// Multiline messages are dispatched as a single message,
+			// and appear as such in the enclosing batch.
+			msg := assembleMultiline(b)
+			if b.parent != nil {
+				if msg != nil {
+					b.parent.Lines = append(b.parent.Lines, msg)
+				}
+				if !b.parent.dispatch {
+					return false, nil
+				}
+			}
+			return false, msg
+		}
+		if b.parent != nil {
+			b.parent.Batches = append(b.parent.Batches, b)
+			return false, nil
+		}
+		ev := line.Copy()
+		ev.Args = append([]string{b.Type}, b.Params...)
+		ev.Batch = b
+		return false, ev
+	}
+	return false, nil
+}
@@ -0,0 +1,152 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatch(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	var batches []*Line
+	var msgs []string
+	c.HandleFunc(BATCH, func(_ *Conn, l *Line) { batches = append(batches, l) })
+	c.HandleFunc(PRIVMSG, func(_ *Conn, l *Line) { msgs = append(msgs, l.Text()) })
+	send := func(lines ...string) {
+		for _, l := range lines {
+			c.dispatch(ParseLine(l))
+		}
+	}
+
+	// Lines in batches are dispatched individually by default.
+	send(
+		"@label=l1 :irc.server.org BATCH +ref1 example p1 p2",
+		"@batch=ref1 :nick!user@host PRIVMSG #chan :one",
+		":nick!user@host PRIVMSG #chan :not in batch",
+		"@batch=ref1 :nick!user@host PRIVMSG #chan :two",
+	)
+	if len(batches) != 0 || !reflect.DeepEqual(msgs, []string{"one", "not in batch", "two"}) {
+		t.Errorf("Batch lines not dispatched individually: %d %q", len(batches), msgs)
+	}
+	send(":irc.server.org BATCH -ref1")
+	if len(batches) != 1 {
+		t.Fatalf("Completed batch not dispatched.")
+	}
+	b := batches[0].Batch
+	if b == nil || b.Ref != "ref1" || b.Type != "example" ||
+		!reflect.DeepEqual(b.Params, []string{"p1", "p2"}) || b.Tags["label"] != "l1" ||
+		!reflect.DeepEqual(batches[0].Args, []string{"example", "p1", "p2"}) {
+		t.Errorf("Bad BATCH event: %#v %#v", batches[0], b)
+	}
+	if len(b.Lines) != 2 || b.Lines[0].Text() != "one" || b.Lines[1].Text() != "two" {
+		t.Errorf("Bad batch lines: %#v", b.Lines)
+	}
+
+	// Chathistory lines are only dispatched in the batch, and batches
+	// can be nested.
+	batches, msgs = nil, nil
+	send(
+		":irc.server.org BATCH +outer labeled-response",
+		"@batch=outer :irc.server.org BATCH +inner chathistory #chan",
+		"@batch=inner :nick!user@host PRIVMSG #chan :old",
+		"@batch=outer :nick!user@host PRIVMSG #chan :outer",
+		":irc.server.org BATCH -inner",
+		":irc.server.org BATCH -outer",
+	)
+	if !reflect.DeepEqual(msgs, []string{"outer"}) || len(batches) != 1 {
+		t.Fatalf("Nested batches dispatched incorrectly: %d %q", len(batches), msgs)
+	}
+	b = batches[0].Batch
+	if b.Type != "labeled-response" || len(b.Lines) != 1 || len(b.Batches) != 1 {
+		t.Errorf("Bad outer batch: %#v", b)
+	}
+	if in := b.Batches[0]; in.Type != "chathistory" || len(in.Lines) != 1 ||
+		in.Lines[0].Text() != "old" || !reflect.DeepEqual(in.Params, []string{"#chan"}) {
+		t.Errorf("Bad inner batch: %#v", in)
+	}
+
+	// Nested batch lines aren't dispatched individually if the outer
+	// batch's lines aren't.
+	c.cfg.BatchDispatch["example"] = true
+	msgs = nil
+	send(
+		":irc.server.org BATCH +outer chathistory #chan",
+		"@batch=outer :irc.server.org BATCH +inner example",
+		"@batch=inner :nick!user@host PRIVMSG #chan :inner",
+		":irc.server.org BATCH -inner",
+		":irc.server.org BATCH -outer",
+	)
+	if len(msgs) != 0 {
+		t.Errorf("Nested batch lines dispatched: %q", msgs)
+	}
+
+	// Unknown and unterminated batches.
+	batches, msgs = nil, nil
+	send(
+		"@batch=unknown :nick!user@host PRIVMSG #chan :orphan",
+		":irc.server.org BATCH -unknown",
+		":irc.server.org BATCH +open chathistory #chan",
+		"@batch=open :nick!user@host PRIVMSG #chan :lost",
+	)
+	if len(batches) != 0 || !reflect.DeepEqual(msgs, []string{"orphan"}) {
+		t.Errorf("Unknown batch handled incorrectly: %d %q", len(batches), msgs)
+	}
+	c.batches.reset()
+	send(":irc.server.org BATCH -open")
+	if len(batches) != 0 || len(c.batches.open) != 0 {
+		t.Errorf("Unterminated batch not discarded.")
+	}
+}
+
+func TestBatchLimits(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.MaxOpenBatches, c.cfg.MaxBatchLines = 2, 2
+
+	var batches []*Line
+	var msgs []string
+	c.HandleFunc(BATCH, func(_ *Conn, l *Line) { batches = append(batches, l) })
+	c.HandleFunc(PRIVMSG, func(_ *Conn, l *Line) { msgs = append(msgs, l.Text()) })
+	send := func(lines ...string) {
+		for _, l := range lines {
+			c.dispatch(ParseLine(l))
+		}
+	}
+
+	// Opening a third batch discards the oldest.
+	send(
+		":irc.server.org BATCH +ref1 chathistory #chan",
+		":irc.server.org BATCH +ref2 chathistory #chan",
+		":irc.server.org BATCH +ref3 chathistory #chan",
+	)
+	if len(c.batches.open) != 2 || c.batches.open["ref1"] != nil {
+		t.Errorf("Oldest batch not discarded: %v", c.batches.open)
+	}
+	send(":irc.server.org BATCH -ref1")
+	if len(batches) != 0 {
+		t.Errorf("Discarded batch dispatched.")
+	}
+
+	// A batch with too many lines is discarded, and the rest of its
+	// lines are dispatched individually.
+	send(
+		"@batch=ref2 :nick!user@host PRIVMSG #chan :one",
+		"@batch=ref2 :nick!user@host PRIVMSG #chan :two",
+		"@batch=ref2 :nick!user@host PRIVMSG #chan :three",
+		"@batch=ref2 :nick!user@host PRIVMSG #chan :four",
+		":irc.server.org BATCH -ref2",
+	)
+	if len(batches) != 0 || !reflect.DeepEqual(msgs, []string{"three", "four"}) {
+		t.Errorf("Long batch not discarded: %d %q", len(batches), msgs)
+	}
+
+	// Other batches are unaffected.
+	send(
+		"@batch=ref3 :nick!user@host PRIVMSG #chan :five",
+		":irc.server.org BATCH -ref3",
+	)
+	if len(batches) != 1 || len(batches[0].Batch.Lines) != 1 {
+		t.Errorf("Batch not dispatched correctly: %d", len(batches))
+	}
+}
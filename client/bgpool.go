@@ -0,0 +1,196 @@
+package client
+
+import (
+	"hash/fnv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+// By default, every line that has background handlers gets its own goroutine
+// and every handler for that line gets another one. During a netjoin or in a
+// busy channel that is thousands of goroutines with no backpressure and no
+// ordering guarantees. Setting Config.BGWorkers replaces this with a fixed
+// pool of workers, each with its own bounded queue of lines.
+//
+// Lines are assigned to a worker by hashing the key returned by
+// Config.BGSerialize, so all lines with the same key are handled by the same
+// worker in the order they arrived. Lines with an empty key are spread
+// across the workers round-robin. Each worker runs a line's handlers one
+// after another, so at most Config.BGWorkers handlers run at once.
+//
+// Workers are started when the first line is queued, and stopped once the
+// connection is closed and the DISCONNECTED event has been handled.
+type bgPool struct {
+	// mu guards queues and done, which is closed to stop the workers.
+	// It isn't held while sending to a queue, so a background handler
+	// can call Close even if the event loop is blocked on its queue.
+	mu      sync.RWMutex
+	queues  []chan *Line
+	done    chan struct{}
+	workers sync.WaitGroup
+
+	qlen      int
+	serialize func(*Line) string
+	slow      time.Duration
+	next      uint32
+
+	// Counters exposed via Conn.BGStats.
+	handled, slowed uint64
+}
+
+// BGStats contains a snapshot of the background worker pool's metrics.
+type BGStats struct {
+	// The number of workers in the pool.
+	Workers int
+	// The number of lines waiting to be picked up by a worker.
+	QueueDepth int
+	// The number of lines fully handled by the pool.
+	Handled uint64
+	// The number of handler invocations that took longer than
+	// Config.BGSlowHandler to complete.
+	Slow uint64
+}
+
+const defaultBGQueueLen = 64
+
+func newBGPool(cfg *Config) *bgPool {
+	qlen := cfg.BGQueueLen
+	if qlen <= 0 {
+		qlen = defaultBGQueueLen
+	}
+	return &bgPool{
+		queues:    make([]chan *Line, cfg.BGWorkers),
+		qlen:      qlen,
+		serialize: cfg.BGSerialize,
+		slow:      cfg.BGSlowHandler,
+	}
+}
+
+// SerializeByTarget can be used as Config.BGSerialize to ensure that
+// background handlers see lines from any one channel or nick in order.
+func SerializeByTarget(line *Line) string {
+	if len(line.Args) == 0 {
+		return ""
+	}
+	return line.Target()
+}
+
+// queue picks a worker for the line and enqueues it. This blocks if the
+// worker's queue is full, which applies backpressure to the event loop,
+// until there is space or the pool is stopped, when the line is dropped.
+func (p *bgPool) queue(conn *Conn, line *Line) {
+	var idx uint32
+	key := ""
+	if p.serialize != nil {
+		key = p.serialize(line)
+	}
+	if key != "" {
+		h := fnv.New32a()
+		h.Write([]byte(key))
+		idx = h.Sum32()
+	} else {
+		idx = atomic.AddUint32(&p.next, 1)
+	}
+	p.mu.Lock()
+	if p.done == nil {
+		p.startWorkers(conn)
+	}
+	q, done := p.queues[idx%uint32(len(p.queues))], p.done
+	p.mu.Unlock()
+	select {
+	case q <- line:
+	case <-done:
+		logging.Warn("irc.bgPool(): pool stopped, dropping %s", line.Cmd)
+	}
+}
+
+// startWorkers starts a worker goroutine for each queue. It must be
+// called with mu held.
+func (p *bgPool) startWorkers(conn *Conn) {
+	p.done = make(chan struct{})
+	for i := range p.queues {
+		p.queues[i] = make(chan *Line, p.qlen)
+		p.workers.Add(1)
+		go p.work(conn, p.queues[i], p.done)
+	}
+}
+
+// stop tells the workers to exit once they have handled the lines already
+// queued, and unblocks anything waiting to queue a line. It doesn't wait
+// for the workers to exit.
+func (p *bgPool) stop() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.done != nil {
+		close(p.done)
+		p.done = nil
+	}
+}
+
+// work is started as a goroutine for each worker in the pool, and runs
+// until the pool is stopped.
+func (p *bgPool) work(conn *Conn, q chan *Line, done chan struct{}) {
+	defer p.workers.Done()
+	for {
+		select {
+		case line := <-q:
+			p.handle(conn, line)
+		case <-done:
+			// Handle what's left in the queue before exiting.
+			for {
+				select {
+				case line := <-q:
+					p.handle(conn, line)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (p *bgPool) handle(conn *Conn, line *Line) {
+	p.dispatch(conn, line)
+	atomic.AddUint64(&p.handled, 1)
+}
+
+// dispatch runs the background handlers for a line in turn, timing each.
+func (p *bgPool) dispatch(conn *Conn, line *Line) {
+	for _, hn := range conn.bgHandlers.getHandlers(strings.ToLower(line.Cmd)) {
+		start := time.Now()
+		hn.Handle(conn, hn.line(line))
+		if d := time.Since(start); p.slow > 0 && d > p.slow {
+			atomic.AddUint64(&p.slowed, 1)
+			logging.Warn("irc.bgPool(): slow %s handler took %s",
+				line.Cmd, d)
+		}
+	}
+}
+
+func (p *bgPool) stats() BGStats {
+	s := BGStats{
+		Workers: len(p.queues),
+		Handled: atomic.LoadUint64(&p.handled),
+		Slow:    atomic.LoadUint64(&p.slowed),
+	}
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	for _, q := range p.queues {
+		s.QueueDepth += len(q)
+	}
+	return s
+}
+
+// BGStats returns metrics for the background handler worker pool. If
+// Config.BGWorkers was not set when the Conn was created, background
+// handlers are not pooled and the returned BGStats will be zero.
+func (conn *Conn) BGStats() BGStats {
+	if conn.bgPool == nil {
+		return BGStats{}
+	}
+	return conn.bgPool.stats()
+}
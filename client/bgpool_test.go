@@ -0,0 +1,167 @@
+package client
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBGPoolSerializesByTarget(t *testing.T) {
+	cfg := NewConfig("test")
+	cfg.BGWorkers = 4
+	cfg.BGSerialize = SerializeByTarget
+	c := Client(cfg)
+
+	var mu sync.Mutex
+	seen := map[string][]string{}
+	done := make(chan struct{}, 100)
+	c.HandleBG(PRIVMSG, HandlerFunc(func(_ *Conn, l *Line) {
+		mu.Lock()
+		seen[l.Target()] = append(seen[l.Target()], l.Text())
+		mu.Unlock()
+		done <- struct{}{}
+	}))
+
+	want := []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9"}
+	for _, txt := range want {
+		c.dispatch(ParseLine(":n!u@h PRIVMSG #one :" + txt))
+		c.dispatch(ParseLine(":n!u@h PRIVMSG #two :" + txt))
+	}
+	for i := 0; i < 2*len(want); i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Background handler not called for all lines.")
+		}
+	}
+
+	for _, ch := range []string{"#one", "#two"} {
+		got := seen[ch]
+		if len(got) != len(want) {
+			t.Fatalf("%s: got %d lines, want %d", ch, len(got), len(want))
+		}
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%s: lines handled out of order: %v", ch, got)
+				break
+			}
+		}
+	}
+	// Usually need to wait for goroutines to settle :-/
+	<-time.After(time.Millisecond)
+	if s := c.BGStats(); s.Workers != 4 || s.Handled != 20 || s.QueueDepth != 0 {
+		t.Errorf("Unexpected stats: %#v", s)
+	}
+}
+
+func TestBGPoolSlowHandler(t *testing.T) {
+	cfg := NewConfig("test")
+	cfg.BGWorkers = 1
+	cfg.BGSlowHandler = time.Millisecond
+	c := Client(cfg)
+
+	done := callCheck(t)
+	c.HandleBG(PRIVMSG, HandlerFunc(func(_ *Conn, _ *Line) {
+		<-time.After(5 * time.Millisecond)
+		done.call()
+	}))
+	// Lines with no background handlers shouldn't be queued at all.
+	c.dispatch(ParseLine(":n!u@h NOTICE #chan :hi"))
+	c.dispatch(ParseLine(":n!u@h PRIVMSG #chan :hi"))
+	<-done.c
+	<-time.After(time.Millisecond)
+	if s := c.BGStats(); s.Handled != 1 || s.Slow != 1 {
+		t.Errorf("Unexpected stats: %#v", s)
+	}
+
+	if s := SimpleClient("test").BGStats(); s != (BGStats{}) {
+		t.Errorf("Unpooled client returned non-zero stats: %#v", s)
+	}
+}
+
+func TestBGPoolRunsHandlersInWorker(t *testing.T) {
+	cfg := NewConfig("test")
+	cfg.BGWorkers = 1
+	c := Client(cfg)
+
+	// With one worker, handlers run one at a time, in order.
+	var mu sync.Mutex
+	running, order := 0, []int{}
+	done := make(chan struct{}, 10)
+	for i := 0; i < 3; i++ {
+		c.HandleBG(PRIVMSG, HandlerFunc(func(_ *Conn, _ *Line) {
+			mu.Lock()
+			running++
+			if running > 1 {
+				t.Errorf("Background handlers run concurrently.")
+			}
+			order = append(order, i)
+			mu.Unlock()
+			<-time.After(time.Millisecond)
+			mu.Lock()
+			running--
+			mu.Unlock()
+			done <- struct{}{}
+		}))
+	}
+	c.dispatch(ParseLine(":n!u@h PRIVMSG #chan :hi"))
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Background handler not called.")
+		}
+	}
+	mu.Lock()
+	if len(order) != 3 || order[0] != 0 || order[1] != 1 || order[2] != 2 {
+		t.Errorf("Handlers run out of order: %v", order)
+	}
+	mu.Unlock()
+
+	// Stopping the pool stops the workers, and queueing another line
+	// starts them again.
+	c.bgPool.stop()
+	c.bgPool.workers.Wait()
+	c.dispatch(ParseLine(":n!u@h PRIVMSG #chan :hi"))
+	for i := 0; i < 3; i++ {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("Background handler not called after restart.")
+		}
+	}
+	c.bgPool.stop()
+	c.bgPool.workers.Wait()
+}
+
+func TestBGPoolHandlerCloses(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.BGWorkers, c.cfg.BGQueueLen = 1, 1
+	c.bgPool = newBGPool(c.cfg)
+
+	release := make(chan struct{})
+	closed := make(chan struct{})
+	var once sync.Once
+	c.HandleBG(PRIVMSG, HandlerFunc(func(c *Conn, _ *Line) {
+		once.Do(func() {
+			<-release
+			c.Close()
+			close(closed)
+		})
+	}))
+
+	// The first line blocks the worker, the second fills its queue and
+	// the third leaves runLoop waiting for space.
+	for _, txt := range []string{"one", "two", "three"} {
+		s.nc.Send(":n!u@h PRIVMSG #chan :" + txt)
+	}
+	<-time.After(5 * time.Millisecond)
+	close(release)
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatalf("Close from a background handler deadlocked.")
+	}
+	c.bgPool.workers.Wait()
+}
@@ -0,0 +1,283 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// chatHistoryCap is the IRCv3 capability that allows clients to request
+// message history with the CHATHISTORY command.
+const chatHistoryCap = "draft/chathistory"
+
+// The batch types used for replies to CHATHISTORY commands.
+const (
+	chatHistoryBatch        = "chathistory"
+	chatHistoryTargetsBatch = "draft/chathistory-targets"
+)
+
+// defaultHistoryLimit is the number of messages requested if neither the
+// caller nor the server sets a limit.
+const defaultHistoryLimit = 100
+
+var (
+	// ErrNoChatHistory is returned by the ChatHistory methods if the
+	// draft/chathistory capability has not been negotiated.
+	ErrNoChatHistory = errors.New("irc: draft/chathistory capability not negotiated")
+	// ErrBadHistoryRef is returned by the ChatHistory methods if the
+	// server does not support a HistoryRef, or it is used incorrectly.
+	ErrBadHistoryRef = errors.New("irc: unsupported history reference")
+	// ErrDisconnected is returned by methods waiting for a reply from
+	// the server if the client disconnects first.
+	ErrDisconnected = errors.New("irc: disconnected from server")
+)
+
+// A HistoryError is returned by the ChatHistory methods when the server
+// rejects a request with a FAIL CHATHISTORY reply.
+type HistoryError struct {
+	// The error code, e.g. "INVALID_TARGET".
+	Code string
+	// Further parameters, which depend on the code, and a description
+	// of the error.
+	Context     []string
+	Description string
+}
+
+func (e *HistoryError) Error() string {
+	return fmt.Sprintf("irc: CHATHISTORY failed: %s %s: %s",
+		e.Code, strings.Join(e.Context, " "), e.Description)
+}
+
+// A HistoryRef identifies a point in a target's history, either by the
+// msgid of a message or by a timestamp.
+type HistoryRef string
+
+// LatestRef may be passed to ChatHistoryLatest to request the latest
+// messages without any lower bound.
+const LatestRef HistoryRef = "*"
+
+// MsgIDRef returns a HistoryRef for the message with the given msgid tag.
+func MsgIDRef(id string) HistoryRef {
+	return HistoryRef("msgid=" + id)
+}
+
+// TimeRef returns a HistoryRef for the given time.
+func TimeRef(t time.Time) HistoryRef {
+	return HistoryRef("timestamp=" + t.UTC().Format("2006-01-02T15:04:05.000Z"))
+}
+
+// refType returns the type of the reference, e.g. "msgid".
+func (ref HistoryRef) refType() string {
+	typ, _, _ := strings.Cut(string(ref), "=")
+	return typ
+}
+
+// historyWaiter waits for the reply to a CHATHISTORY request.
+type historyWaiter struct {
+	typ, target string
+	ch          chan historyReply
+}
+
+type historyReply struct {
+	lines []*Line
+	err   error
+}
+
+// historyRequests keeps track of CHATHISTORY requests awaiting replies,
+// in the order they were sent.
+type historyRequests struct {
+	mu      sync.Mutex
+	waiters []*historyWaiter
+}
+
+func (hr *historyRequests) add(w *historyWaiter) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	hr.waiters = append(hr.waiters, w)
+}
+
+func (hr *historyRequests) remove(w *historyWaiter) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	for i, o := range hr.waiters {
+		if o == w {
+			hr.waiters = append(hr.waiters[:i], hr.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// reply passes r to the oldest waiter for which match returns true.
+func (hr *historyRequests) reply(match func(*historyWaiter) bool, r historyReply) bool {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	for i, w := range hr.waiters {
+		if match(w) {
+			hr.waiters = append(hr.waiters[:i], hr.waiters[i+1:]...)
+			w.ch <- r
+			return true
+		}
+	}
+	return false
+}
+
+// fail passes err to all the waiters.
+func (hr *historyRequests) fail(err error) {
+	hr.mu.Lock()
+	defer hr.mu.Unlock()
+	for _, w := range hr.waiters {
+		w.ch <- historyReply{err: err}
+	}
+	hr.waiters = nil
+}
+
+// ChatHistoryBefore requests up to limit messages sent to target before
+// ref. If limit is zero or more than the server allows, the server's
+// limit is used. It waits for the server's reply, or for ctx to be done.
+// Lines are returned in the order the server sent them, usually oldest
+// first, and are not dispatched to handlers individually.
+func (conn *Conn) ChatHistoryBefore(ctx context.Context, target string, ref HistoryRef, limit int) ([]*Line, error) {
+	return conn.chatHistory(ctx, "BEFORE", target, limit, ref)
+}
+
+// ChatHistoryAfter requests up to limit messages sent to target after
+// ref. See ChatHistoryBefore.
+func (conn *Conn) ChatHistoryAfter(ctx context.Context, target string, ref HistoryRef, limit int) ([]*Line, error) {
+	return conn.chatHistory(ctx, "AFTER", target, limit, ref)
+}
+
+// ChatHistoryLatest requests up to limit of the most recent messages sent
+// to target after ref, which may be LatestRef to set no lower bound. See
+// ChatHistoryBefore.
+func (conn *Conn) ChatHistoryLatest(ctx context.Context, target string, ref HistoryRef, limit int) ([]*Line, error) {
+	return conn.chatHistory(ctx, "LATEST", target, limit, ref)
+}
+
+// ChatHistoryAround requests up to limit messages sent to target around
+// ref. See ChatHistoryBefore.
+func (conn *Conn) ChatHistoryAround(ctx context.Context, target string, ref HistoryRef, limit int) ([]*Line, error) {
+	return conn.chatHistory(ctx, "AROUND", target, limit, ref)
+}
+
+// ChatHistoryBetween requests up to limit messages sent to target between
+// start and end. If start is after end, the latest messages are returned.
+// See ChatHistoryBefore.
+func (conn *Conn) ChatHistoryBetween(ctx context.Context, target string, start, end HistoryRef, limit int) ([]*Line, error) {
+	return conn.chatHistory(ctx, "BETWEEN", target, limit, start, end)
+}
+
+// ChatHistoryTargets requests up to limit targets that have had messages
+// sent to them between start and end, which must be TimeRefs. The
+// returned lines are of the form "CHATHISTORY TARGETS <target> <time>".
+// See ChatHistoryBefore.
+func (conn *Conn) ChatHistoryTargets(ctx context.Context, start, end HistoryRef, limit int) ([]*Line, error) {
+	return conn.chatHistory(ctx, "TARGETS", "", limit, start, end)
+}
+
+// chatHistory sends a CHATHISTORY request and waits for the reply.
+func (conn *Conn) chatHistory(ctx context.Context, sub, target string, limit int, refs ...HistoryRef) ([]*Line, error) {
+	if !conn.HasCapability(chatHistoryCap) {
+		return nil, ErrNoChatHistory
+	}
+	args := []string{sub}
+	if target != "" {
+		args = append(args, target)
+	}
+	for _, ref := range refs {
+		if err := conn.checkHistoryRef(sub, ref); err != nil {
+			return nil, err
+		}
+		args = append(args, string(ref))
+	}
+	args = append(args, strconv.Itoa(conn.historyLimit(limit)))
+
+	w := &historyWaiter{typ: chatHistoryBatch, target: target, ch: make(chan historyReply, 1)}
+	if sub == "TARGETS" {
+		w.typ = chatHistoryTargetsBatch
+	}
+	conn.history.add(w)
+	if err := conn.Send(&Line{Cmd: CHATHISTORY, Args: args}); err != nil {
+		conn.history.remove(w)
+		return nil, err
+	}
+	select {
+	case r := <-w.ch:
+		return r.lines, r.err
+	case <-ctx.Done():
+		conn.history.remove(w)
+		return nil, ctx.Err()
+	}
+}
+
+// checkHistoryRef returns an error if the server doesn't support ref.
+func (conn *Conn) checkHistoryRef(sub string, ref HistoryRef) error {
+	typ := ref.refType()
+	switch {
+	case ref == LatestRef:
+		if sub != "LATEST" {
+			return fmt.Errorf("%w: %q can only be used with LATEST", ErrBadHistoryRef, ref)
+		}
+		return nil
+	case sub == "TARGETS" && typ != "timestamp":
+		return fmt.Errorf("%w: TARGETS requires timestamps", ErrBadHistoryRef)
+	case typ != "msgid" && typ != "timestamp":
+		return fmt.Errorf("%w: %q", ErrBadHistoryRef, ref)
+	}
+	if types, ok := conn.isupport.Get("MSGREFTYPES"); ok {
+		for _, t := range strings.Split(types, ",") {
+			if t == typ {
+				return nil
+			}
+		}
+		return fmt.Errorf("%w: server does not support %s references", ErrBadHistoryRef, typ)
+	}
+	return nil
+}
+
+// historyLimit returns the number of messages to request, given the
+// limit requested by the caller and the CHATHISTORY ISUPPORT token.
+func (conn *Conn) historyLimit(limit int) int {
+	max := conn.isupport.getInt("CHATHISTORY", 0)
+	switch {
+	case max > 0 && (limit <= 0 || limit > max):
+		return max
+	case limit <= 0:
+		return defaultHistoryLimit
+	}
+	return limit
+}
+
+// Handler for completed batches, which may be replies to CHATHISTORY.
+func (conn *Conn) h_BATCH(line *Line) {
+	b := line.Batch
+	if b == nil || (b.Type != chatHistoryBatch && b.Type != chatHistoryTargetsBatch) {
+		return
+	}
+	var target string
+	if len(b.Params) > 0 {
+		target = b.Params[0]
+	}
+	cm := conn.CaseMapping()
+	conn.history.reply(func(w *historyWaiter) bool {
+		return w.typ == b.Type && (w.typ == chatHistoryTargetsBatch || cm.Equal(w.target, target))
+	}, historyReply{lines: b.Lines})
+}
+
+// Handler for FAIL standard replies, which may be errors from CHATHISTORY.
+// :<server> FAIL CHATHISTORY <code> [<context>...] :<description>
+func (conn *Conn) h_FAIL(line *Line) {
+	if !line.argslen(2) || line.Args[0] != CHATHISTORY {
+		return
+	}
+	err := &HistoryError{
+		Code:        line.Args[1],
+		Context:     append([]string(nil), line.Args[2:len(line.Args)-1]...),
+		Description: line.Text(),
+	}
+	// FAIL replies don't say which request failed, so assume the oldest.
+	conn.history.reply(func(*historyWaiter) bool { return true }, historyReply{err: err})
+}
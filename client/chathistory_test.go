@@ -0,0 +1,179 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// historyServer is a scripted IRC server that negotiates chathistory and
+// replies to CHATHISTORY requests from replies. A nil reply closes the
+// connection, and requests without a reply are ignored.
+func historyServer(t *testing.T, ln net.Listener, replies map[string][]string) {
+	nc, err := ln.Accept()
+	if err != nil {
+		t.Errorf("Accept: %v", err)
+		return
+	}
+	defer nc.Close()
+	r := bufio.NewReader(nc)
+	send := func(lines ...string) {
+		for _, l := range lines {
+			if _, err := nc.Write([]byte(l + "\r\n")); err != nil {
+				t.Errorf("Write: %v", err)
+			}
+		}
+	}
+	for {
+		l, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		l = strings.TrimRight(l, "\r\n")
+		switch {
+		case l == "CAP LS 302":
+			send(":irc.server.org CAP * LS :batch draft/chathistory message-tags server-time")
+		case strings.HasPrefix(l, "CAP REQ :"):
+			send(":irc.server.org CAP * ACK :" + l[9:])
+		case l == "CAP END":
+			send(":irc.server.org 001 test :Welcome to IRC test!test@host",
+				":irc.server.org 005 test CHATHISTORY=50 MSGREFTYPES=msgid,timestamp :are supported")
+		case strings.HasPrefix(l, CHATHISTORY):
+			reply, ok := replies[l]
+			if !ok {
+				continue
+			}
+			if reply == nil {
+				return
+			}
+			send(reply...)
+		}
+	}
+}
+
+func TestChatHistory(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen: %v", err)
+	}
+	defer ln.Close()
+	go historyServer(t, ln, map[string][]string{
+		"CHATHISTORY BEFORE #chan msgid=abc 50": {
+			":irc.server.org BATCH +h1 chathistory #Chan",
+			"@batch=h1;time=2020-01-01T00:00:00.000Z;msgid=m1 :a!a@a PRIVMSG #chan :first",
+			"@batch=h1;time=2020-01-01T00:01:00.000Z;msgid=m2 :b!b@b PRIVMSG #chan :second",
+			":irc.server.org BATCH -h1",
+		},
+		"CHATHISTORY LATEST #empty * 10": {
+			":irc.server.org BATCH +h2 chathistory #empty",
+			":irc.server.org BATCH -h2",
+		},
+		"CHATHISTORY AFTER #nope timestamp=2020-01-01T00:00:00.000Z 50": {
+			":irc.server.org FAIL CHATHISTORY INVALID_TARGET AFTER #nope :Messages could not be retrieved",
+		},
+		"CHATHISTORY TARGETS timestamp=2020-01-01T00:00:00.000Z timestamp=2020-01-02T00:00:00.000Z 5": {
+			":irc.server.org BATCH +h3 draft/chathistory-targets",
+			"@batch=h3 :irc.server.org CHATHISTORY TARGETS #chan 2020-01-01T12:00:00.000Z",
+			"@batch=h3 :irc.server.org CHATHISTORY TARGETS friend 2020-01-01T13:00:00.000Z",
+			":irc.server.org BATCH -h3",
+		},
+		"CHATHISTORY AROUND #close msgid=abc 50": nil,
+	})
+
+	cfg := NewConfig("test")
+	cfg.Server = ln.Addr().String()
+	cfg.EnableCapabilityNegotiation = true
+	cfg.Flood = true
+	c := Client(cfg)
+	ready := make(chan struct{})
+	c.HandleFunc(RPL_ISUPPORT, func(*Conn, *Line) { close(ready) })
+	privmsgs := 0
+	c.HandleFunc(PRIVMSG, func(*Conn, *Line) { privmsgs++ })
+	if err := c.Connect(); err != nil {
+		t.Fatalf("Connect: %v", err)
+	}
+	defer c.Close()
+	select {
+	case <-ready:
+	case <-time.After(time.Second):
+		t.Fatalf("Timed out waiting for registration.")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	jan1 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	// Limits are capped at the server's limit, and batches are matched
+	// to requests using the server's casemapping.
+	lines, err := c.ChatHistoryBefore(ctx, "#chan", MsgIDRef("abc"), 0)
+	if err != nil || len(lines) != 2 || lines[0].Text() != "first" || lines[1].Text() != "second" {
+		t.Errorf("ChatHistoryBefore returned %v, %v", lines, err)
+	} else if m, _ := lines[1].Tag("msgid"); m != "m2" || !lines[1].Time.Equal(jan1.Add(time.Minute)) {
+		t.Errorf("History lines lost their tags or time.")
+	}
+	if privmsgs != 0 {
+		t.Errorf("History lines dispatched individually.")
+	}
+
+	lines, err = c.ChatHistoryLatest(ctx, "#empty", LatestRef, 10)
+	if err != nil || len(lines) != 0 {
+		t.Errorf("ChatHistoryLatest returned %v, %v", lines, err)
+	}
+
+	var he *HistoryError
+	_, err = c.ChatHistoryAfter(ctx, "#nope", TimeRef(jan1), 100)
+	if !errors.As(err, &he) || he.Code != "INVALID_TARGET" ||
+		strings.Join(he.Context, " ") != "AFTER #nope" {
+		t.Errorf("ChatHistoryAfter returned %v", err)
+	}
+
+	lines, err = c.ChatHistoryTargets(ctx, TimeRef(jan1), TimeRef(jan1.Add(24*time.Hour)), 5)
+	if err != nil || len(lines) != 2 || lines[1].Args[1] != "friend" {
+		t.Errorf("ChatHistoryTargets returned %v, %v", lines, err)
+	}
+
+	// Bad references are rejected without asking the server.
+	for _, f := range []func() error{
+		func() error { _, err := c.ChatHistoryBefore(ctx, "#chan", LatestRef, 10); return err },
+		func() error { _, err := c.ChatHistoryAround(ctx, "#chan", "bogus=1", 10); return err },
+		func() error { _, err := c.ChatHistoryTargets(ctx, MsgIDRef("a"), MsgIDRef("b"), 10); return err },
+	} {
+		if err := f(); !errors.Is(err, ErrBadHistoryRef) {
+			t.Errorf("Bad reference returned %v", err)
+		}
+	}
+
+	// Requests time out if the server doesn't reply.
+	short, cancel2 := context.WithTimeout(ctx, 20*time.Millisecond)
+	defer cancel2()
+	if _, err := c.ChatHistoryBetween(short, "#slow", MsgIDRef("a"), MsgIDRef("b"), 1); err != context.DeadlineExceeded {
+		t.Errorf("ChatHistoryBetween returned %v", err)
+	}
+
+	// Pending requests fail when the client disconnects.
+	if _, err := c.ChatHistoryAround(ctx, "#close", MsgIDRef("abc"), 0); err != ErrDisconnected {
+		t.Errorf("ChatHistoryAround returned %v", err)
+	}
+}
+
+func TestChatHistoryNoCap(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	if _, err := c.ChatHistoryLatest(context.Background(), "#chan", LatestRef, 0); err != ErrNoChatHistory {
+		t.Errorf("ChatHistoryLatest without cap returned %v", err)
+	}
+	s.nc.ExpectNothing()
+
+	c.currCaps.Add(chatHistoryCap)
+	if n := c.historyLimit(0); n != defaultHistoryLimit {
+		t.Errorf("historyLimit(0) = %d without server limit", n)
+	}
+	if n := c.historyLimit(1000); n != 1000 {
+		t.Errorf("historyLimit(1000) = %d without server limit", n)
+	}
+}
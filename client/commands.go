@@ -9,13 +9,23 @@ const (
 	REGISTER      = "REGISTER"
 	CONNECTED     = "CONNECTED"
 	DISCONNECTED  = "DISCONNECTED"
+	PARSEERROR    = "PARSEERROR"
+	ACCOUNT       = "ACCOUNT"
 	ACTION        = "ACTION"
 	AUTHENTICATE  = "AUTHENTICATE"
 	AWAY          = "AWAY"
+	BATCH         = "BATCH"
 	CAP           = "CAP"
+	CAPDISABLED   = "CAPDISABLED"
+	CAPENABLED    = "CAPENABLED"
+	CHATHISTORY   = "CHATHISTORY"
+	CHGHOST       = "CHGHOST"
+	CLIENTINFO    = "CLIENTINFO"
 	CTCP          = "CTCP"
 	CTCPREPLY     = "CTCPREPLY"
 	ERROR         = "ERROR"
+	FAIL          = "FAIL"
+	FINGER        = "FINGER"
 	INVITE        = "INVITE"
 	JOIN          = "JOIN"
 	KICK          = "KICK"
@@ -28,9 +38,14 @@ const (
 	PING          = "PING"
 	PONG          = "PONG"
 	PRIVMSG       = "PRIVMSG"
+	TAGMSG        = "TAGMSG"
 	QUIT          = "QUIT"
+	SETNAME       = "SETNAME"
+	SOURCE        = "SOURCE"
+	TIME          = "TIME"
 	TOPIC         = "TOPIC"
 	USER          = "USER"
+	USERINFO      = "USERINFO"
 	VERSION       = "VERSION"
 	VHOST         = "VHOST"
 	WHO           = "WHO"
@@ -120,7 +135,84 @@ func splitArgs(args []string, maxLen int) []string {
 // debugging purposes but may well come in handy.
 func (conn *Conn) Raw(rawline string) {
 	// Avoid command injection by enforcing one command per line.
-	conn.out <- cutNewLines(rawline)
+	s := cutNewLines(rawline)
+	conn.out <- s
+	conn.echo(s)
+}
+
+// Send marshals the line and sends it to the server. Unlike Raw, which
+// truncates its input at the first newline, Send validates the line and
+// returns an error rather than sending anything if it is malformed,
+// e.g. if it contains CR, LF or NUL or a middle argument contains a space.
+// See Line.Marshal for details.
+//     conn.Send(&Line{Cmd: PRIVMSG, Args: []string{"#chan", "hello world"}})
+//
+// Lines with Tags may only be sent once the message-tags capability has
+// been negotiated, and their tags must fit within the 4094 bytes the
+// spec allows clients.
+func (conn *Conn) Send(line *Line) error {
+	s, err := conn.marshal(line)
+	if err != nil {
+		return err
+	}
+	conn.out <- s
+	conn.echo(s)
+	return nil
+}
+
+// marshal checks that we may send line and marshals it.
+func (conn *Conn) marshal(line *Line) (string, error) {
+	if _, err := conn.clientTags(line); err != nil {
+		return "", err
+	}
+	return line.Marshal()
+}
+
+// RawTags sends a raw line to the server with the given tags prepended.
+// Like Raw, the line is cut at the first newline. See Send for the
+// restrictions on sending tags.
+func (conn *Conn) RawTags(tags map[string]string, rawline string) error {
+	t, err := conn.clientTags(&Line{Tags: tags})
+	if err != nil {
+		return err
+	}
+	if t != "" {
+		rawline = t + " " + rawline
+	}
+	conn.Raw(rawline)
+	return nil
+}
+
+// clientTags returns the marshalled tags for a line we're about to send,
+// or an error if we're not allowed to send them.
+func (conn *Conn) clientTags(line *Line) (string, error) {
+	if len(line.Tags) == 0 {
+		return "", nil
+	}
+	if !conn.HasCapability(messageTagsCap) {
+		return "", ErrNoMessageTags
+	}
+	tags, err := line.marshalTags()
+	if err != nil {
+		return "", err
+	}
+	if len(tags)+1 > maxClientTagsLen {
+		return "", fmt.Errorf("%w: %d > %d bytes", ErrTagsTooLong, len(tags)+1, maxClientTagsLen)
+	}
+	return tags, nil
+}
+
+// Tagmsg sends a TAGMSG, which carries only tags, to the target nick or
+// channel t. This is used for e.g. typing notifications and reactions,
+// which are sent as client-only tags with a '+' prefix:
+//     conn.Tagmsg("#chan", map[string]string{"+typing": "active"})
+//
+//     @tags TAGMSG t
+func (conn *Conn) Tagmsg(t string, tags map[string]string) error {
+	if len(tags) == 0 {
+		return fmt.Errorf("%w: TAGMSG requires tags", ErrBadTag)
+	}
+	return conn.Send(&Line{Tags: tags, Cmd: TAGMSG, Args: []string{t}})
 }
 
 // Pass sends a PASS command to the server.
@@ -139,6 +231,11 @@ func (conn *Conn) User(ident, name string) {
 
 // Join sends a JOIN command to the server with an optional key.
 //     JOIN channel [key]
+//
+// With state tracking enabled, the extended-join capability is requested,
+// which adds the account and real name to JOINs from the server:
+//     :nick!user@host JOIN <channel> <account> :<real name>
+// So handlers should take the channel from Line.Args[0], not Line.Text.
 func (conn *Conn) Join(channel string, key ...string) {
 	k := ""
 	if len(key) > 0 {
@@ -188,8 +285,13 @@ func (conn *Conn) Who(nick string) { conn.Raw(WHO + " " + nick) }
 // Privmsg sends a PRIVMSG to the target nick or channel t.
 // If msg is longer than Config.SplitLen characters, multiple PRIVMSGs
 // will be sent to the target containing sequential parts of msg.
+// If the server supports draft/multiline, msg is instead sent as a
+// multiline batch, and may contain newlines. See Config.SplitLen.
 // PRIVMSG t :msg
 func (conn *Conn) Privmsg(t, msg string) {
+	if conn.sendMultiline(PRIVMSG, t, msg, nil) {
+		return
+	}
 	prefix := PRIVMSG + " " + t + " :"
 	for _, s := range splitMessage(msg, conn.cfg.SplitLen, conn.cfg.SplitMarker) {
 		conn.Raw(prefix + s)
@@ -216,22 +318,57 @@ func (conn *Conn) Privmsgf(t, format string, a ...interface{}) {
 	conn.Privmsg(t, msg)
 }
 
+// PrivmsgTags works like Privmsg, but attaches the tags to the message.
+// If msg is split, every PRIVMSG carries the tags. See Send for the
+// restrictions on sending tags.
+//     @tags PRIVMSG t :msg
+func (conn *Conn) PrivmsgTags(t, msg string, tags map[string]string) error {
+	return conn.sendSplitTags(PRIVMSG, t, msg, tags)
+}
+
+// sendSplitTags splits msg as Privmsg and Notice do and sends each part
+// with the given tags, stopping at the first error.
+func (conn *Conn) sendSplitTags(cmd, t, msg string, tags map[string]string) error {
+	if conn.sendMultiline(cmd, t, msg, tags) {
+		return nil
+	}
+	for _, s := range splitMessage(msg, conn.cfg.SplitLen, conn.cfg.SplitMarker) {
+		if err := conn.Send(&Line{Tags: tags, Cmd: cmd, Args: []string{t, s}}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 // Notice sends a NOTICE to the target nick or channel t.
 // If msg is longer than Config.SplitLen characters, multiple NOTICEs
 // will be sent to the target containing sequential parts of msg.
+// If the server supports draft/multiline, msg is instead sent as a
+// multiline batch, and may contain newlines. See Config.SplitLen.
 //     NOTICE t :msg
 func (conn *Conn) Notice(t, msg string) {
+	if conn.sendMultiline(NOTICE, t, msg, nil) {
+		return
+	}
 	for _, s := range splitMessage(msg, conn.cfg.SplitLen, conn.cfg.SplitMarker) {
 		conn.Raw(NOTICE + " " + t + " :" + s)
 	}
 }
 
+// NoticeTags works like Notice, but attaches the tags to the message.
+// See PrivmsgTags.
+//     @tags NOTICE t :msg
+func (conn *Conn) NoticeTags(t, msg string, tags map[string]string) error {
+	return conn.sendSplitTags(NOTICE, t, msg, tags)
+}
+
 // Ctcp sends a (generic) CTCP message to the target nick
-// or channel t, with an optional argument.
+// or channel t, with an optional argument. The argument is
+// quoted as required by the CTCP specification.
 //     PRIVMSG t :\001CTCP arg\001
 func (conn *Conn) Ctcp(t, ctcp string, arg ...string) {
 	// We need to split again here to ensure
-	for _, s := range splitMessage(strings.Join(arg, " "), conn.cfg.SplitLen, conn.cfg.SplitMarker) {
+	for _, s := range splitMessage(quoteCTCP(strings.Join(arg, " ")), conn.cfg.SplitLen, conn.cfg.SplitMarker) {
 		if s != "" {
 			s = " " + s
 		}
@@ -241,10 +378,11 @@ func (conn *Conn) Ctcp(t, ctcp string, arg ...string) {
 }
 
 // CtcpReply sends a (generic) CTCP reply to the target nick
-// or channel t, with an optional argument.
+// or channel t, with an optional argument. The argument is
+// quoted as required by the CTCP specification.
 //     NOTICE t :\001CTCP arg\001
 func (conn *Conn) CtcpReply(t, ctcp string, arg ...string) {
-	for _, s := range splitMessage(strings.Join(arg, " "), conn.cfg.SplitLen, conn.cfg.SplitMarker) {
+	for _, s := range splitMessage(quoteCTCP(strings.Join(arg, " ")), conn.cfg.SplitLen, conn.cfg.SplitMarker) {
 		if s != "" {
 			s = " " + s
 		}
@@ -296,12 +434,19 @@ func (conn *Conn) Mode(t string, modestring ...string) {
 //     AWAY :message
 func (conn *Conn) Away(message ...string) {
 	msg := strings.Join(message, " ")
+	// Remember the message, so RPL_NOWAWAY can record it in the tracker.
+	conn.awayMsg.Store(msg)
 	if msg != "" {
 		msg = " :" + msg
 	}
 	conn.Raw(AWAY + msg)
 }
 
+// SetName sends a SETNAME command to the server, to change the client's
+// real name. The server must support the setname capability.
+//     SETNAME :name
+func (conn *Conn) SetName(name string) { conn.Raw(SETNAME + " :" + name) }
+
 // Invite sends an INVITE command to the server.
 //     INVITE nick channel
 func (conn *Conn) Invite(nick, channel string) {
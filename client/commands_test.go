@@ -1,6 +1,7 @@
 package client
 
 import (
+	"errors"
 	"reflect"
 	"strconv"
 	"strings"
@@ -217,6 +218,9 @@ func TestClientCommands(t *testing.T) {
 	c.Away("Dave's not here, man.")
 	s.nc.Expect("AWAY :Dave's not here, man.")
 
+	c.SetName("Dave")
+	s.nc.Expect("SETNAME :Dave")
+
 	c.Invite("somebody", "#foo")
 	s.nc.Expect("INVITE somebody #foo")
 
@@ -225,6 +229,15 @@ func TestClientCommands(t *testing.T) {
 
 	c.VHost("user", "pass")
 	s.nc.Expect("VHOST user pass")
+
+	if err := c.Send(&Line{Cmd: PRIVMSG, Args: []string{"#foo", "bar baz"}}); err != nil {
+		t.Errorf("Send returned unexpected error: %v", err)
+	}
+	s.nc.Expect("PRIVMSG #foo :bar baz")
+	if err := c.Send(&Line{Cmd: PRIVMSG, Args: []string{"#foo", "bar\r\nQUIT"}}); err == nil {
+		t.Errorf("Send did not reject line containing CRLF.")
+	}
+	s.nc.ExpectNothing()
 }
 
 func TestSplitCommand(t *testing.T) {
@@ -243,3 +256,63 @@ func TestSplitCommand(t *testing.T) {
 		}
 	}
 }
+
+func TestTagCommands(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	typing := map[string]string{"+typing": "active"}
+	reply := map[string]string{"+draft/reply": "abc;123"}
+
+	// Tags can't be sent without message-tags.
+	if err := c.Tagmsg("#foo", typing); !errors.Is(err, ErrNoMessageTags) {
+		t.Errorf("Tagmsg without message-tags returned %v", err)
+	}
+	if err := c.PrivmsgTags("#foo", "bar", reply); !errors.Is(err, ErrNoMessageTags) {
+		t.Errorf("PrivmsgTags without message-tags returned %v", err)
+	}
+	s.nc.ExpectNothing()
+	// ... but untagged messages are fine.
+	if err := c.PrivmsgTags("#foo", "bar", nil); err != nil {
+		t.Errorf("PrivmsgTags without tags returned %v", err)
+	}
+	s.nc.Expect("PRIVMSG #foo bar")
+
+	c.currCaps.Add(messageTagsCap)
+	if err := c.Tagmsg("#foo", typing); err != nil {
+		t.Errorf("Tagmsg returned %v", err)
+	}
+	s.nc.Expect("@+typing=active TAGMSG #foo")
+
+	c.cfg.SplitLen = 23
+	//                                    0123456789012345678901234567890123
+	if err := c.PrivmsgTags("#foo", "foo bar baz blorp. woo woobly woo.", reply); err != nil {
+		t.Errorf("PrivmsgTags returned %v", err)
+	}
+	s.nc.Expect("@+draft/reply=abc\\:123 PRIVMSG #foo :foo bar baz blorp. ...")
+	s.nc.Expect("@+draft/reply=abc\\:123 PRIVMSG #foo :woo woobly woo.")
+
+	if err := c.NoticeTags("somebody", "something", reply); err != nil {
+		t.Errorf("NoticeTags returned %v", err)
+	}
+	s.nc.Expect("@+draft/reply=abc\\:123 NOTICE somebody something")
+
+	if err := c.RawTags(typing, "TAGMSG #foo\r\nQUIT"); err != nil {
+		t.Errorf("RawTags returned %v", err)
+	}
+	s.nc.Expect("@+typing=active TAGMSG #foo")
+
+	// Client tags are limited to 4094 bytes including '@' and ' '.
+	big := map[string]string{"+big": strings.Repeat("x", maxClientTagsLen-7)}
+	if _, err := c.clientTags(&Line{Tags: big}); err != nil {
+		t.Errorf("Maximum size tags returned %v", err)
+	}
+	big["+big"] += "x"
+	if err := c.Tagmsg("#foo", big); !errors.Is(err, ErrTagsTooLong) {
+		t.Errorf("Tagmsg with oversized tags returned %v", err)
+	}
+	if err := c.Tagmsg("#foo", nil); !errors.Is(err, ErrBadTag) {
+		t.Errorf("Tagmsg with no tags returned %v", err)
+	}
+	s.nc.ExpectNothing()
+}
@@ -10,6 +10,7 @@ import (
 	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	sasl "github.com/emersion/go-sasl"
@@ -31,6 +32,31 @@ type Conn struct {
 	intHandlers *hSet
 	fgHandlers  *hSet
 	bgHandlers  *hSet
+	bgPool      *bgPool
+
+	// Sources whose lines are not passed to fg/bg handlers.
+	ignores *ignoreList
+
+	// Replies to CTCP requests.
+	ctcp *ctcpResponders
+
+	// Features advertised by the server in RPL_ISUPPORT.
+	isupport *ISupport
+
+	// Batches opened by the server that have not yet completed.
+	batches *batchTracker
+
+	// CHATHISTORY requests waiting for replies.
+	history *historyRequests
+
+	// Counter used to generate references for multiline batches we send.
+	multilineRef uint64
+
+	// The message we last sent with AWAY.
+	awayMsg atomic.Value
+
+	// Local echoes of messages we sent, waiting for runLoop.
+	echoes *echoQueue
 
 	// State tracker for nicks and channels
 	st         state.Tracker
@@ -44,6 +70,9 @@ type Conn struct {
 	in          chan *Line
 	out         chan string
 	connected   bool
+	// True while runLoop is dispatching lines. Unlike connected, this
+	// can be checked from handlers without taking mu.
+	running atomic.Bool
 
 	// Capabilities supported by the server
 	supportedCaps *capSet
@@ -51,8 +80,14 @@ type Conn struct {
 	// Capabilites currently enabled
 	currCaps *capSet
 
+	// True from CAP LS until CAP END during registration.
+	negotiating bool
+
 	// SASL internals
-	saslRemainingData []byte
+	sasl saslState
+
+	// The account we are logged in to, from RPL_LOGGEDIN.
+	account atomic.Value
 
 	// CancelFunc and WaitGroup for goroutines
 	die context.CancelFunc
@@ -102,10 +137,22 @@ type Config struct {
 	EnableCapabilityNegotiation bool
 
 	// A list of capabilities to request to the server during registration.
+	// They are also requested if the server starts supporting them later,
+	// via cap-notify. CAPENABLED and CAPDISABLED events are dispatched
+	// with the names of capabilities in Line.Args when they are enabled
+	// or disabled.
 	Capabilites []string
 
 	// SASL configuration to use to authenticate the connection.
 	Sasl sasl.Client
+	// Further SASL mechanisms to try, in order, if Sasl fails or the
+	// server doesn't support it. Mechanisms that the server doesn't
+	// advertise in its CAP LS reply or RPL_SASLMECHS are skipped.
+	SaslMechanisms []sasl.Client
+	// If true, QUIT rather than finishing registration without logging
+	// in if SASL authentication fails. SASLSUCCESS and SASLFAILURE
+	// events are dispatched with the outcome either way.
+	SaslRequired bool
 
 	// Replaceable function to customise the 433 handler's new nick.
 	// By default the current nick's last character is "incremented".
@@ -129,6 +176,20 @@ type Config struct {
 	// Sent as the reply to a CTCP VERSION message.
 	Version string
 
+	// Sent as the reply to CTCP USERINFO and SOURCE messages. USERINFO
+	// defaults to Me.Name if not set, and is disabled by default; see
+	// Conn.EnableCTCP. SOURCE defaults to goirc's repository.
+	UserInfo, Source string
+
+	// Replies to CTCP requests are rate limited to a burst of CTCPBurst
+	// replies, refilling at one per CTCPRate, so that a CTCP flood can't
+	// be reflected back at the server and get the client killed for
+	// excess flood. Requests over the limit are dropped. Defaults to
+	// a burst of 3 and one reply per 2 seconds. Set CTCPRate to 0 to
+	// disable rate limiting.
+	CTCPRate  time.Duration
+	CTCPBurst int
+
 	// Sent as the default QUIT message if Quit is called with no args.
 	QuitMessage string
 
@@ -136,13 +197,90 @@ type Config struct {
 	// Defaults to logging an error, see LogPanic.
 	Recover func(*Conn, *Line)
 
+	// Configurable reporting for errors returned by handlers added with
+	// HandleE, HandleBGE and HandleFuncE. Defaults to logging an error,
+	// see LogHandlerError. Set to nil to ignore handler errors.
+	OnHandlerError func(*Conn, *HandlerError)
+
+	// Controls how lines received from the server are parsed, and what
+	// happens to lines that are malformed. See ParseMode for details.
+	ParseErrors ParseMode
+
+	// Run background handlers on a fixed pool of BGWorkers goroutines,
+	// each running one handler at a time from a queue of BGQueueLen
+	// lines (defaulting to 64). When a
+	// worker's queue is full, dispatch blocks until there is space.
+	// If BGWorkers is 0, each line gets its own goroutine. Changing
+	// these after calling Client will have no effect.
+	BGWorkers, BGQueueLen int
+
+	// If set, background handlers see lines for which BGSerialize
+	// returns the same non-empty key in the order they were received.
+	// Requires BGWorkers. See SerializeByTarget.
+	BGSerialize func(*Line) string
+
+	// Background handlers that take longer than this to complete are
+	// logged and counted in BGStats. Requires BGWorkers.
+	BGSlowHandler time.Duration
+
+	// Whether lines in IRCv3 batches of a given type are dispatched
+	// individually, as well as being collected into a BATCH event when
+	// the batch is complete. Lines in batches of types not listed here
+	// are dispatched individually. By default, lines in chathistory
+	// batches are not, so replayed JOINs etc. don't confuse the state
+	// tracker, and neither are the parts of draft/multiline messages,
+	// which are reassembled and dispatched as a single PRIVMSG or NOTICE.
+	// Lines in a nested batch are only dispatched individually if the
+	// enclosing batches' lines are too.
+	BatchDispatch map[string]bool
+
+	// Limits on the number of batches the server may have open at once,
+	// and the number of lines buffered in each, so a server that never
+	// closes its batches can't use unbounded memory. If a batch is opened
+	// when MaxOpenBatches are already open, the oldest is discarded, and
+	// a batch with more than MaxBatchLines lines is discarded. A warning
+	// is logged either way. Default to 100 and 10000 if not set.
+	MaxOpenBatches, MaxBatchLines int
+
+	// If true, PRIVMSGs, NOTICEs and TAGMSGs sent by the client are also
+	// dispatched to handlers, with Line.Self set, so they can be logged
+	// in the same way as messages from others. The echo-message
+	// capability is requested so the server echoes messages as it
+	// delivered them, with any tags it adds. If the server doesn't
+	// support it, messages are echoed locally as they are sent.
+	// Handlers that reply to messages should ignore lines with Self set.
+	EchoMessages bool
+
 	// Split PRIVMSGs, NOTICEs and CTCPs longer than SplitLen characters
-	// over multiple lines. Default to 450 if not set.
+	// over multiple lines. Default to 450 if not set. If the server
+	// supports draft/multiline, long PRIVMSGs and NOTICEs are sent as a
+	// multiline batch of lines of up to SplitLen characters instead.
 	SplitLen int
 	// Defaults to appending "..." to a split line to indicate a split.
 	SplitMarker string
 }
 
+// ParseMode determines how the client handles malformed lines from
+// the server. See Config.ParseErrors.
+type ParseMode int
+
+const (
+	// ParseLenient parses lines with ParseLine, which makes a best effort
+	// at parsing everything and drops the few lines it cannot parse.
+	// This is the default.
+	ParseLenient ParseMode = iota
+	// ParseDrop drops any line that ParseLineStrict rejects.
+	ParseDrop
+	// ParsePassThrough logs lines that ParseLineStrict rejects, but still
+	// dispatches them if ParseLine can make sense of them.
+	ParsePassThrough
+	// ParseEvent replaces lines that ParseLineStrict rejects with a
+	// PARSEERROR event. The event's Line has Raw set to the line received
+	// from the server, and Text() returns the error. Pass Raw to
+	// ParseLineStrict to get at the underlying *ParseError.
+	ParseEvent
+)
+
 // NewConfig creates a Config struct containing sensible defaults.
 // It takes one required argument: the nick to use for the client.
 // Subsequent string arguments set the client's ident and "real"
@@ -153,8 +291,13 @@ func NewConfig(nick string, args ...string) *Config {
 		PingFreq:                    3 * time.Minute,
 		NewNick:                     DefaultNewNick,
 		Recover:                     (*Conn).LogPanic, // in dispatch.go
+		OnHandlerError:              (*Conn).LogHandlerError,
 		SplitLen:                    defaultSplit,
 		SplitMarker:                 defaultMarker,
+		Source:                      defaultSource,
+		CTCPRate:                    2 * time.Second,
+		CTCPBurst:                   3,
+		BatchDispatch:               make(map[string]bool),
 		Timeout:                     60 * time.Second,
 		EnableCapabilityNegotiation: false,
 	}
@@ -229,22 +372,30 @@ func Client(cfg *Config) *Conn {
 		}
 	}
 
-	if cfg.Sasl != nil && !cfg.EnableCapabilityNegotiation {
+	if (cfg.Sasl != nil || len(cfg.SaslMechanisms) > 0) && !cfg.EnableCapabilityNegotiation {
 		logging.Warn("Enabling capability negotiation as it's required for SASL")
 		cfg.EnableCapabilityNegotiation = true
 	}
 
 	conn := &Conn{
-		cfg:               cfg,
-		dialer:            dialer,
-		intHandlers:       handlerSet(),
-		fgHandlers:        handlerSet(),
-		bgHandlers:        handlerSet(),
-		stRemovers:        make([]Remover, 0, len(stHandlers)),
-		lastsent:          time.Now(),
-		supportedCaps:     capabilitySet(),
-		currCaps:          capabilitySet(),
-		saslRemainingData: nil,
+		cfg:           cfg,
+		dialer:        dialer,
+		intHandlers:   handlerSet(),
+		fgHandlers:    handlerSet(),
+		bgHandlers:    handlerSet(),
+		ignores:       newIgnoreList(),
+		ctcp:          newCTCPResponders(),
+		isupport:      newISupport(),
+		batches:       newBatchTracker(),
+		history:       &historyRequests{},
+		stRemovers:    make([]Remover, 0, len(stHandlers)),
+		lastsent:      time.Now(),
+		supportedCaps: capabilitySet(),
+		currCaps:      capabilitySet(),
+		echoes:        newEchoQueue(),
+	}
+	if cfg.BGWorkers > 0 {
+		conn.bgPool = newBGPool(cfg)
 	}
 	conn.addIntHandlers()
 	return conn
@@ -302,6 +453,7 @@ func (conn *Conn) EnableStateTracking() {
 	if conn.st == nil {
 		n := conn.cfg.Me
 		conn.st = state.NewTracker(n.Nick)
+		conn.st.SetCaseMapping(conn.CaseMapping())
 		conn.st.NickInfo(n.Nick, n.Ident, n.Host, n.Name)
 		conn.cfg.Me = conn.st.Me()
 		conn.addSTHandlers()
@@ -322,6 +474,23 @@ func (conn *Conn) DisableStateTracking() {
 	}
 }
 
+// ISupport returns the features advertised by the server in RPL_ISUPPORT.
+// They are reset when the client connects to a server.
+func (conn *Conn) ISupport() *ISupport {
+	return conn.isupport
+}
+
+// CaseMapping returns the casemapping the server uses to compare nicks
+// and channel names, from the CASEMAPPING token of RPL_ISUPPORT.
+func (conn *Conn) CaseMapping() state.CaseMapping {
+	return conn.isupport.CaseMapping()
+}
+
+// isMe returns true if nick is the client's nick.
+func (conn *Conn) isMe(nick string) bool {
+	return conn.CaseMapping().Equal(nick, conn.Me().Nick)
+}
+
 // SupportsCapability returns true if the server supports the given capability.
 func (conn *Conn) SupportsCapability(cap string) bool {
 	return conn.supportedCaps.Has(cap)
@@ -332,6 +501,13 @@ func (conn *Conn) HasCapability(cap string) bool {
 	return conn.currCaps.Has(cap)
 }
 
+// CapabilityValue returns the value the server advertised for the given
+// capability, e.g. "PLAIN,EXTERNAL" for the SASL mechanisms, or "" if it
+// has no value or isn't supported.
+func (conn *Conn) CapabilityValue(cap string) string {
+	return conn.supportedCaps.Value(cap)
+}
+
 // Per-connection state initialisation.
 func (conn *Conn) initialise() {
 	conn.io = nil
@@ -339,6 +515,12 @@ func (conn *Conn) initialise() {
 	conn.in = make(chan *Line, 32)
 	conn.out = make(chan string, 32)
 	conn.die = nil
+	conn.isupport.reset()
+	conn.supportedCaps.reset()
+	conn.currCaps.reset()
+	conn.negotiating = false
+	conn.sasl = saslState{}
+	conn.account.Store("")
 	if conn.st != nil {
 		conn.st.Wipe()
 	}
@@ -469,6 +651,7 @@ func (conn *Conn) postConnect(ctx context.Context, start bool) {
 		bufio.NewWriter(conn.sock))
 	if start {
 		ctx, conn.die = context.WithCancel(ctx)
+		conn.running.Store(true)
 		conn.wg.Add(3)
 		go conn.send(ctx)
 		go conn.recv()
@@ -524,17 +707,56 @@ func (conn *Conn) recv() {
 			return
 		}
 		s = strings.Trim(s, "\r\n")
-		logging.Debug("<- %s", s)
+		line := conn.parse(s)
+		if line != nil && line.NumericName() != "" {
+			logging.Debug("<- %s [%s]", s, line.NumericName())
+		} else {
+			logging.Debug("<- %s", s)
+		}
 
-		if line := ParseLine(s); line != nil {
-			line.Time = time.Now()
+		if line != nil {
+			line.setTime(time.Now())
+			conn.markSelf(line)
+			if lines := SplitCTCP(line); lines != nil {
+				putLine(line)
+				for _, l := range lines {
+					conn.in <- l
+				}
+				continue
+			}
 			conn.in <- line
-		} else {
-			logging.Warn("irc.recv(): problems parsing line:\n  %s", s)
 		}
 	}
 }
 
+// parse turns a line received from the server into a Line, handling
+// malformed lines as configured by Config.ParseErrors.
+// Lines are taken from linePool and have their tags decoded lazily.
+func (conn *Conn) parse(s string) *Line {
+	if conn.cfg.ParseErrors != ParseLenient {
+		if err := validateLine(s); err != nil {
+			err = &ParseError{Raw: s, Err: err}
+			logging.Warn("irc.recv(): %v", err)
+			switch conn.cfg.ParseErrors {
+			case ParseDrop:
+				return nil
+			case ParseEvent:
+				return &Line{Cmd: PARSEERROR, Raw: s, Args: []string{err.Error()}}
+			}
+			// ParsePassThrough falls through to lenient parsing, which
+			// may still fail if the line is really broken.
+		}
+	}
+	line := getLine()
+	if !parseLine(line, s, true) {
+		putLine(line)
+		logging.Warn("irc.recv(): problems parsing line:\n  %s", s)
+		return nil
+	}
+	line.isupport = conn.isupport
+	return line
+}
+
 // ping is started as a goroutine after a connection is established, as
 // long as Config.PingFreq >0. It pings the server every PingFreq seconds.
 func (conn *Conn) ping(ctx context.Context) {
@@ -560,6 +782,14 @@ func (conn *Conn) runLoop(ctx context.Context) {
 		select {
 		case line := <-conn.in:
 			conn.dispatch(line)
+			// Handlers get a copy of the line unless they are
+			// ReadOnly, and those must not retain it, so it's
+			// safe to reuse now.
+			putLine(line)
+		case <-conn.echoes.ready:
+			for _, line := range conn.echoes.take() {
+				conn.dispatch(line)
+			}
 		case <-ctx.Done():
 			// control channel closed, trigger Cancel() to clean
 			// things up properly and bail out
@@ -629,19 +859,33 @@ func (conn *Conn) Close() error {
 	}
 	logging.Info("irc.Close(): Disconnected from server.")
 	conn.connected = false
+	conn.running.Store(false)
 	err := conn.sock.Close()
 	if conn.die != nil {
 		conn.die()
 	}
+	// A background handler may be calling Close while runLoop waits
+	// for space in its worker's queue, so unblock runLoop.
+	if conn.bgPool != nil {
+		conn.bgPool.stop()
+	}
 	// Drain both in and out channels to avoid a deadlock if the buffers
 	// have filled. See TestSendDeadlockOnFullBuffer in connection_test.go.
 	conn.drainIn()
 	conn.drainOut()
 	conn.wg.Wait()
 	conn.mu.Unlock()
+	conn.echoes.reset()
+	conn.batches.reset()
+	conn.history.fail(ErrDisconnected)
 	// Dispatch after closing connection but before reinit
 	// so event handlers can still access state information.
 	conn.dispatch(&Line{Cmd: DISCONNECTED, Time: time.Now()})
+	// Background workers exit once they have handled DISCONNECTED, and
+	// are started again if there are more lines to handle.
+	if conn.bgPool != nil {
+		conn.bgPool.stop()
+	}
 	return err
 }
 
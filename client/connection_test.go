@@ -381,6 +381,25 @@ func TestRecv(t *testing.T) {
 		t.Errorf("Bad second line received on input channel.")
 	}
 
+	// Embedded CTCPs are split out into separate lines.
+	s.nc.Send(":nick!user@host PRIVMSG test :hi \001ACTION waves\001 there\001VERSION\001")
+	for _, cmd := range []string{PRIVMSG, ACTION, CTCP} {
+		if l := reader(); l == nil || l.Cmd != cmd {
+			t.Errorf("Expected %s line split from embedded CTCP, got %v.", cmd, l)
+		}
+	}
+
+	// Lines with a server-time tag keep their original time.
+	s.nc.Send("@time=2011-10-19T16:40:51.620Z :nick!user@host PRIVMSG #chan :old")
+	if l := reader(); l == nil || l.Time.UnixMilli() != 1319042451620 ||
+		time.Since(l.Received) > time.Second {
+		t.Errorf("server-time tag not parsed into Time, got %v.", l)
+	}
+	s.nc.Send("@time=yesterday :nick!user@host PRIVMSG #chan :new")
+	if l := reader(); l == nil || !l.Time.Equal(l.Received) || l.Time.IsZero() {
+		t.Errorf("Bad server-time tag not ignored, got %v.", l)
+	}
+
 	// Test that recv does something useful with a line it can't parse
 	// (not that there are many, ParseLine is forgiving).
 	s.nc.Send(":textwithnospaces")
@@ -388,6 +407,34 @@ func TestRecv(t *testing.T) {
 		t.Errorf("Bad line still caused receive on input channel.")
 	}
 
+	// Lines that only the strict parser rejects are let through by default.
+	s.nc.Send(":irc.server.org 003 test :Bad UTF-8 \xff.")
+	if l := reader(); l == nil || l.Cmd != "003" {
+		t.Errorf("Lenient parsing didn't pass through bad UTF-8.")
+	}
+	c.cfg.ParseErrors = ParseDrop
+	s.nc.Send(":irc.server.org 004 test :Bad UTF-8 \xff.")
+	if l := reader(); l != nil {
+		t.Errorf("ParseDrop didn't drop bad UTF-8.")
+	}
+	// Make sure recv is done with the dropped line before changing config.
+	s.nc.Send(":irc.server.org 004 test :Good UTF-8.")
+	if l := reader(); l == nil || l.Cmd != "004" {
+		t.Errorf("ParseDrop dropped a good line.")
+	}
+	c.cfg.ParseErrors = ParsePassThrough
+	s.nc.Send(":irc.server.org 005 test :Bad UTF-8 \xff.")
+	if l := reader(); l == nil || l.Cmd != "005" {
+		t.Errorf("ParsePassThrough didn't pass through bad UTF-8.")
+	}
+	c.cfg.ParseErrors = ParseEvent
+	s.nc.Send(":irc.server.org 006 test :Bad UTF-8 \xff.")
+	if l := reader(); l == nil || l.Cmd != PARSEERROR ||
+		l.Raw != ":irc.server.org 006 test :Bad UTF-8 \xff." || l.Text() == "" {
+		t.Errorf("ParseEvent didn't produce a PARSEERROR event: %#v", l)
+	}
+	c.cfg.ParseErrors = ParseLenient
+
 	// The only way recv() exits is when the socket closes.
 	exited.assertNotCalled("Exited before socket close.")
 	s.nc.Close()
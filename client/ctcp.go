@@ -0,0 +1,314 @@
+package client
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+// CTCP messages are wrapped in \001 bytes within the text of a PRIVMSG or
+// NOTICE. A single message may contain several CTCPs, optionally mixed with
+// ordinary text, and the final \001 may be omitted. The CTCP payload may
+// use the low-level quoting described in the original CTCP specification:
+// \020 is used as an escape character for NUL, CR, LF and itself. The
+// CTCP-level quoting (\134 escapes) from that specification is not
+// implemented, as next to no clients ever supported it.
+
+const (
+	ctcpDelim = "\001"
+	ctcpQuote = '\020'
+
+	defaultSource = "https://github.com/fluffle/goirc"
+)
+
+var ctcpQuoter = strings.NewReplacer("\020", "\020\020", "\000", "\0200", "\n", "\020n", "\r", "\020r")
+
+// quoteCTCP applies low-level quoting to a CTCP payload.
+func quoteCTCP(s string) string {
+	if strings.IndexAny(s, "\020\000\n\r") == -1 {
+		return s
+	}
+	return ctcpQuoter.Replace(s)
+}
+
+// dequoteCTCP reverses low-level quoting. Unknown escapes are dropped,
+// leaving the escaped character, as the specification requires.
+func dequoteCTCP(s string) string {
+	i := strings.IndexByte(s, ctcpQuote)
+	if i == -1 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	b.WriteString(s[:i])
+	for ; i < len(s); i++ {
+		c := s[i]
+		if c == ctcpQuote {
+			if i++; i == len(s) {
+				break
+			}
+			switch c = s[i]; c {
+			case '0':
+				c = '\000'
+			case 'n':
+				c = '\n'
+			case 'r':
+				c = '\r'
+			}
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// singleCTCP returns the payload of text if it consists of a single CTCP,
+// with or without the trailing \001.
+func singleCTCP(text string) (string, bool) {
+	if len(text) < 2 || text[0] != '\001' {
+		return "", false
+	}
+	payload := text[1:]
+	if i := strings.IndexByte(payload, '\001'); i != -1 {
+		if i != len(payload)-1 {
+			// Multiple or embedded CTCPs; see SplitCTCP.
+			return "", false
+		}
+		payload = payload[:i]
+	}
+	return payload, payload != ""
+}
+
+// unwrapCTCP rewrites a PRIVMSG or NOTICE line with at least two arguments
+// whose text is the CTCP payload into an ACTION, CTCP or CTCPREPLY line.
+func unwrapCTCP(line *Line, payload string) {
+	c, t, _ := strings.Cut(payload, " ")
+	line.Args[1] = dequoteCTCP(t)
+	if c = strings.ToUpper(c); c == ACTION && line.Cmd == PRIVMSG {
+		// make a CTCP ACTION it's own event a-la PRIVMSG
+		line.Cmd = c
+		return
+	}
+	// otherwise, dispatch a generic CTCP/CTCPREPLY event that
+	// contains the type of CTCP in line.Args[0]
+	if line.Cmd == PRIVMSG {
+		line.Cmd = CTCP
+	} else {
+		line.Cmd = CTCPREPLY
+	}
+	line.Args = append(line.Args, "")
+	copy(line.Args[1:], line.Args)
+	line.Args[0] = c
+}
+
+// SplitCTCP handles PRIVMSGs and NOTICEs that contain more than one CTCP,
+// or CTCPs embedded in ordinary text, which ParseLine leaves alone. It
+// returns a PRIVMSG or NOTICE line containing any ordinary text, followed
+// by an ACTION, CTCP or CTCPREPLY line for each CTCP, in the order they
+// appeared in the message. The returned lines are copies, so the original
+// line is not modified. If the line contains no embedded CTCPs, SplitCTCP
+// returns nil. Lines received by a Conn have already been split.
+func SplitCTCP(line *Line) []*Line {
+	if (line.Cmd != PRIVMSG && line.Cmd != NOTICE) || len(line.Args) < 2 ||
+		strings.IndexByte(line.Args[1], '\001') == -1 {
+		return nil
+	}
+	var text strings.Builder
+	var payloads []string
+	for s := line.Args[1]; s != ""; {
+		before, after, found := strings.Cut(s, ctcpDelim)
+		text.WriteString(before)
+		if !found {
+			break
+		}
+		// If the final \001 is missing, the CTCP runs to the end.
+		payload, rest, _ := strings.Cut(after, ctcpDelim)
+		if payload != "" {
+			payloads = append(payloads, payload)
+		}
+		s = rest
+	}
+	if len(payloads) == 0 {
+		return nil
+	}
+	lines := make([]*Line, 0, len(payloads)+1)
+	if t := text.String(); strings.TrimSpace(t) != "" {
+		l := line.Copy()
+		l.Args[1] = t
+		lines = append(lines, l)
+	}
+	for _, p := range payloads {
+		l := line.Copy()
+		unwrapCTCP(l, p)
+		lines = append(lines, l)
+	}
+	return lines
+}
+
+// A CTCPResponder generates the reply to a CTCP request. If it returns
+// false, no reply is sent. The line is only valid until the responder
+// returns, and must not be modified.
+type CTCPResponder func(conn *Conn, line *Line) (string, bool)
+
+// ctcpBuiltins are the responders that can be enabled with EnableCTCP.
+var ctcpBuiltins = map[string]CTCPResponder{
+	VERSION: func(conn *Conn, line *Line) (string, bool) {
+		return conn.cfg.Version, true
+	},
+	PING: func(conn *Conn, line *Line) (string, bool) {
+		return strings.Join(line.Args[2:], " "), true
+	},
+	TIME: func(conn *Conn, line *Line) (string, bool) {
+		return time.Now().Format(time.RFC1123Z), true
+	},
+	CLIENTINFO: func(conn *Conn, line *Line) (string, bool) {
+		return strings.Join(conn.CTCPVerbs(), " "), true
+	},
+	USERINFO: func(conn *Conn, line *Line) (string, bool) {
+		if conn.cfg.UserInfo != "" {
+			return conn.cfg.UserInfo, true
+		}
+		return conn.cfg.Me.Name, true
+	},
+	SOURCE: func(conn *Conn, line *Line) (string, bool) {
+		return conn.cfg.Source, conn.cfg.Source != ""
+	},
+	FINGER: func(conn *Conn, line *Line) (string, bool) {
+		return conn.cfg.Me.Name, true
+	},
+}
+
+// defaultCTCP lists the responders enabled for a new Conn. USERINFO and
+// FINGER give away a little more about the client, so are off by default.
+var defaultCTCP = []string{VERSION, PING, TIME, CLIENTINFO, SOURCE}
+
+// ctcpResponders holds a Conn's CTCP responders and the token bucket used
+// to rate limit replies.
+type ctcpResponders struct {
+	mu     sync.Mutex
+	resp   map[string]CTCPResponder
+	tokens int
+	last   time.Time
+}
+
+func newCTCPResponders() *ctcpResponders {
+	cr := &ctcpResponders{resp: make(map[string]CTCPResponder)}
+	for _, verb := range defaultCTCP {
+		cr.resp[verb] = ctcpBuiltins[verb]
+	}
+	return cr
+}
+
+// allow returns true if a reply may be sent at time now, given a maximum
+// of burst replies refilling at one per rate.
+func (cr *ctcpResponders) allow(now time.Time, rate time.Duration, burst int) bool {
+	if rate <= 0 {
+		return true
+	}
+	if burst < 1 {
+		burst = 1
+	}
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	if cr.last.IsZero() {
+		cr.tokens, cr.last = burst, now
+	}
+	if n := int(now.Sub(cr.last) / rate); n > 0 {
+		cr.tokens += n
+		cr.last = cr.last.Add(time.Duration(n) * rate)
+	}
+	if cr.tokens >= burst {
+		// Don't accumulate tokens while idle.
+		cr.tokens, cr.last = burst, now
+	}
+	if cr.tokens == 0 {
+		return false
+	}
+	cr.tokens--
+	return true
+}
+
+func (cr *ctcpResponders) get(verb string) CTCPResponder {
+	cr.mu.Lock()
+	defer cr.mu.Unlock()
+	return cr.resp[verb]
+}
+
+// HandleCTCP sets the responder for CTCP requests with the given verb,
+// replacing any existing responder. Passing a nil responder disables
+// replies to that verb. For example, to reply to CTCP ECHO:
+//
+//	conn.HandleCTCP("ECHO", func(conn *Conn, line *Line) (string, bool) {
+//		return line.Text(), true
+//	})
+//
+// All replies are subject to the rate limit set by Config.CTCPRate.
+func (conn *Conn) HandleCTCP(verb string, r CTCPResponder) {
+	verb = strings.ToUpper(verb)
+	conn.ctcp.mu.Lock()
+	defer conn.ctcp.mu.Unlock()
+	if r == nil {
+		delete(conn.ctcp.resp, verb)
+		return
+	}
+	conn.ctcp.resp[verb] = r
+}
+
+// EnableCTCP enables the built-in responders for the given verbs, which
+// may be any of VERSION, PING, TIME, CLIENTINFO, USERINFO, SOURCE and
+// FINGER. All but USERINFO and FINGER are enabled by default. This
+// replaces any responder set with HandleCTCP for those verbs.
+func (conn *Conn) EnableCTCP(verbs ...string) {
+	for _, verb := range verbs {
+		r, ok := ctcpBuiltins[strings.ToUpper(verb)]
+		if !ok {
+			logging.Warn("irc.EnableCTCP(): no built-in responder for %q", verb)
+			continue
+		}
+		conn.HandleCTCP(verb, r)
+	}
+}
+
+// DisableCTCP disables replies to CTCP requests with the given verbs.
+func (conn *Conn) DisableCTCP(verbs ...string) {
+	for _, verb := range verbs {
+		conn.HandleCTCP(verb, nil)
+	}
+}
+
+// CTCPVerbs returns the sorted list of CTCP verbs the client will reply to.
+func (conn *Conn) CTCPVerbs() []string {
+	conn.ctcp.mu.Lock()
+	defer conn.ctcp.mu.Unlock()
+	verbs := make([]string, 0, len(conn.ctcp.resp)+1)
+	for verb := range conn.ctcp.resp {
+		verbs = append(verbs, verb)
+	}
+	// ACTION isn't something we reply to, but we do understand it.
+	verbs = append(verbs, ACTION)
+	sort.Strings(verbs)
+	return verbs
+}
+
+// Reply to CTCP requests using the Conn's CTCP responders.
+func (conn *Conn) h_CTCP(line *Line) {
+	// Don't reply to our own requests if they are echoed back to us.
+	if line.Nick == "" || !line.argslen(1) || line.Self {
+		return
+	}
+	verb := strings.ToUpper(line.Args[0])
+	r := conn.ctcp.get(verb)
+	if r == nil {
+		return
+	}
+	if !conn.ctcp.allow(time.Now(), conn.cfg.CTCPRate, conn.cfg.CTCPBurst) {
+		logging.Warn("irc.h_CTCP(): rate limit exceeded, not replying to %s from %s",
+			verb, line.Nick)
+		return
+	}
+	if reply, ok := r(conn, line); ok {
+		conn.CtcpReply(line.Nick, verb, reply)
+	}
+}
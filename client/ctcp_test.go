@@ -0,0 +1,200 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCTCPQuoting(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"", ""},
+		{"nothing to see here", "nothing to see here"},
+		{"a\nb\rc\000d", "a\020nb\020rc\0200d"},
+		{"\020", "\020\020"},
+		{"\020n", "\020\020n"},
+	}
+	for i, test := range tests {
+		if out := quoteCTCP(test.in); out != test.out {
+			t.Errorf("%d: quoteCTCP(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+		if in := dequoteCTCP(test.out); in != test.in {
+			t.Errorf("%d: dequoteCTCP(%q) = %q, want %q", i, test.out, in, test.in)
+		}
+	}
+	// Unknown escapes leave the escaped character; a trailing \020 is dropped.
+	if s := dequoteCTCP("\020x\020"); s != "x" {
+		t.Errorf("dequoteCTCP didn't handle bad escapes: %q", s)
+	}
+}
+
+func TestParseCTCP(t *testing.T) {
+	tests := []struct {
+		in   string
+		cmd  string
+		args []string
+	}{
+		{":n!u@h PRIVMSG #foo :\001ACTION waves\001", ACTION, []string{"#foo", "waves"}},
+		// The trailing \001 is optional.
+		{":n!u@h PRIVMSG #foo :\001ACTION waves", ACTION, []string{"#foo", "waves"}},
+		{":n!u@h PRIVMSG me :\001version\001", CTCP, []string{"VERSION", "me", ""}},
+		{":n!u@h NOTICE me :\001PING 1 2 3\001", CTCPREPLY, []string{"PING", "me", "1 2 3"}},
+		{":n!u@h PRIVMSG me :\001ECHO a\020nb\001", CTCP, []string{"ECHO", "me", "a\nb"}},
+		// Empty CTCPs aren't CTCPs.
+		{":n!u@h PRIVMSG me :\001\001", PRIVMSG, []string{"me", "\001\001"}},
+		// Neither are multiple CTCPs, until they're split.
+		{":n!u@h PRIVMSG me :\001PING 1\001\001TIME\001", PRIVMSG, []string{"me", "\001PING 1\001\001TIME\001"}},
+	}
+	for i, test := range tests {
+		l := ParseLine(test.in)
+		if l.Cmd != test.cmd || !reflect.DeepEqual(l.Args, test.args) {
+			t.Errorf("%d: ParseLine(%q) = %s %q, want %s %q",
+				i, test.in, l.Cmd, l.Args, test.cmd, test.args)
+		}
+	}
+
+	// Quoting survives a round trip.
+	l := &Line{Cmd: CTCP, Args: []string{"ECHO", "me", "a\nb"}}
+	if s, err := l.Marshal(); err != nil || s != "PRIVMSG me :\001ECHO a\020nb\001" {
+		t.Errorf("Marshal didn't quote CTCP: %q, %v", s, err)
+	}
+}
+
+func TestSplitCTCP(t *testing.T) {
+	split := func(s string) (out []string) {
+		for _, l := range SplitCTCP(ParseLine(s)) {
+			if l.Nick != "n" {
+				t.Errorf("Split line lost source: %#v", l)
+			}
+			out = append(out, l.Cmd+" "+strings.Join(l.Args, ","))
+		}
+		return out
+	}
+	tests := []struct {
+		in  string
+		out []string
+	}{
+		{":n!u@h PRIVMSG #foo :hello", nil},
+		{":n!u@h PRIVMSG #foo :\001ACTION waves\001", nil},
+		{":n!u@h JOIN #foo", nil},
+		{":n!u@h PRIVMSG #foo :\001VERSION\001\001TIME\001", []string{
+			"CTCP VERSION,#foo,", "CTCP TIME,#foo,"}},
+		{":n!u@h PRIVMSG #foo :hi \001ACTION waves\001 there \001PING 1", []string{
+			"PRIVMSG #foo,hi  there ", "ACTION #foo,waves", "CTCP PING,#foo,1"}},
+		{":n!u@h NOTICE #foo :\001PING 1\001 \001PING 2\001", []string{
+			"CTCPREPLY PING,#foo,1", "CTCPREPLY PING,#foo,2"}},
+		{":n!u@h PRIVMSG #foo :no \001\001 ctcp", nil},
+	}
+	for i, test := range tests {
+		if out := split(test.in); !reflect.DeepEqual(out, test.out) {
+			t.Errorf("%d: SplitCTCP(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+	}
+}
+
+func TestCTCPResponders(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.CTCPRate = 0
+
+	ctcp := func(verb string) {
+		c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001" + verb + "\001"))
+	}
+
+	ctcp("CLIENTINFO")
+	s.nc.Expect("NOTICE blah :\001CLIENTINFO ACTION CLIENTINFO PING SOURCE TIME VERSION\001")
+	ctcp("SOURCE")
+	s.nc.Expect("NOTICE blah :\001SOURCE " + defaultSource + "\001")
+	ctcp("PING")
+	s.nc.Expect("NOTICE blah :\001PING\001")
+
+	// USERINFO and FINGER are disabled by default.
+	ctcp("USERINFO")
+	ctcp("FINGER")
+	s.nc.ExpectNothing()
+
+	c.EnableCTCP(USERINFO, "finger", "bogus")
+	ctcp("USERINFO")
+	s.nc.Expect("NOTICE blah :\001USERINFO Testing IRC\001")
+	c.cfg.UserInfo = "I like cheese"
+	ctcp("USERINFO")
+	s.nc.Expect("NOTICE blah :\001USERINFO I like cheese\001")
+	ctcp("FINGER")
+	s.nc.Expect("NOTICE blah :\001FINGER Testing IRC\001")
+
+	// Responders can be overridden, added and disabled.
+	c.HandleCTCP("version", func(conn *Conn, line *Line) (string, bool) {
+		return "something else", true
+	})
+	c.HandleCTCP("ECHO", func(conn *Conn, line *Line) (string, bool) {
+		return line.Text(), line.Text() != ""
+	})
+	c.DisableCTCP(TIME, SOURCE)
+	ctcp("VERSION")
+	s.nc.Expect("NOTICE blah :\001VERSION something else\001")
+	ctcp("ECHO a\020nb")
+	s.nc.Expect("NOTICE blah :\001ECHO a\020nb\001")
+	ctcp("ECHO")
+	ctcp("TIME")
+	ctcp("SOURCE")
+	s.nc.ExpectNothing()
+	if verbs := c.CTCPVerbs(); !reflect.DeepEqual(verbs, []string{
+		ACTION, CLIENTINFO, "ECHO", FINGER, PING, USERINFO, VERSION}) {
+		t.Errorf("Unexpected CTCP verbs: %q", verbs)
+	}
+
+	c.EnableCTCP(TIME, VERSION)
+	ctcp("TIME")
+	var l *Line
+	select {
+	case out := <-s.nc.Out:
+		l = ParseLine(strings.Trim(out, "\r\n"))
+	case <-time.After(time.Millisecond):
+		t.Fatalf("No reply to TIME.")
+	}
+	if l.Cmd != CTCPREPLY || l.Args[0] != TIME {
+		t.Errorf("Unexpected TIME reply: %#v", l)
+	} else if _, err := time.Parse(time.RFC1123Z, l.Args[2]); err != nil {
+		t.Errorf("Couldn't parse TIME reply: %v", err)
+	}
+}
+
+func TestCTCPRateLimit(t *testing.T) {
+	cr := newCTCPResponders()
+	now := time.Now()
+	allowed := func(n int) (count int) {
+		for i := 0; i < n; i++ {
+			if cr.allow(now, time.Second, 3) {
+				count++
+			}
+		}
+		return count
+	}
+	if n := allowed(5); n != 3 {
+		t.Errorf("Burst allowed %d replies, want 3", n)
+	}
+	now = now.Add(1500 * time.Millisecond)
+	if n := allowed(5); n != 1 {
+		t.Errorf("Refill allowed %d replies, want 1", n)
+	}
+	now = now.Add(500 * time.Millisecond)
+	if n := allowed(5); n != 1 {
+		t.Errorf("Partial refill allowed %d replies, want 1", n)
+	}
+	// Tokens don't accumulate past the burst.
+	now = now.Add(time.Hour)
+	if n := allowed(5); n != 3 {
+		t.Errorf("Idle refill allowed %d replies, want 3", n)
+	}
+
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.CTCPRate, c.cfg.CTCPBurst = time.Hour, 2
+	for i := 0; i < 5; i++ {
+		c.h_CTCP(ParseLine(":blah!moo@cows.com PRIVMSG test :\001PING 1\001"))
+	}
+	s.nc.Expect("NOTICE blah :\001PING 1\001")
+	s.nc.Expect("NOTICE blah :\001PING 1\001")
+	s.nc.ExpectNothing()
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"fmt"
 	"runtime"
 	"strings"
 	"sync"
@@ -21,11 +22,26 @@ import (
 // loop, so care should be taken to ensure these handlers are quick :-)
 //
 // Background handlers are run in parallel and do not block the event loop.
-// This is useful for things that may need to do significant work.
+// This is useful for things that may need to do significant work. Setting
+// Config.BGWorkers bounds the number of goroutines used to run them; see
+// bgpool.go for details.
 type Handler interface {
 	Handle(*Conn, *Line)
 }
 
+// ReadOnly wraps a Handler to declare that it neither modifies nor retains
+// the *Line it is passed, so the line need not be copied for it. Lines
+// passed to ReadOnly handlers may have their tags decoded lazily, so
+// these handlers must use Line.Tag rather than Line.Tags. This is an
+// optimisation for busy clients; if in doubt, don't use it.
+func ReadOnly(h Handler) Handler {
+	return readOnlyHandler{h}
+}
+
+type readOnlyHandler struct {
+	Handler
+}
+
 // Removers allow for a handler that has been previously added to the client
 // to be removed.
 type Remover interface {
@@ -40,6 +56,72 @@ func (hf HandlerFunc) Handle(conn *Conn, line *Line) {
 	hf(conn, line)
 }
 
+// HandlerE is a Handler that may fail. Errors returned by HandleE are
+// passed to Config.OnHandlerError along with the event name, the line that
+// triggered the handler, and where in your code the handler was added.
+// HandlerEs are added with Conn.HandleE, Conn.HandleBGE or Conn.HandleFuncE
+// and live alongside regular Handlers for the same event.
+type HandlerE interface {
+	HandleE(*Conn, *Line) error
+}
+
+// HandlerFuncE allows a bare function with this signature to implement the
+// HandlerE interface. It is used by Conn.HandleFuncE.
+type HandlerFuncE func(*Conn, *Line) error
+
+func (hf HandlerFuncE) HandleE(conn *Conn, line *Line) error {
+	return hf(conn, line)
+}
+
+// HandlerError is passed to Config.OnHandlerError when a HandlerE fails.
+type HandlerError struct {
+	// The event the handler was added for, e.g. "privmsg".
+	Event string
+	// The line that triggered the handler.
+	Line *Line
+	// The file:line the handler was added from.
+	Site string
+	// The error returned by the handler.
+	Err error
+}
+
+func (he *HandlerError) Error() string {
+	return fmt.Sprintf("%s handler added at %s: %v", he.Event, he.Site, he.Err)
+}
+
+func (he *HandlerError) Unwrap() error {
+	return he.Err
+}
+
+// errHandler adapts a HandlerE to the Handler interface, so that it can be
+// stored in a hSet and removed in the same way as any other handler.
+type errHandler struct {
+	event, site string
+	handler     HandlerE
+}
+
+func newErrHandler(ev string, h HandlerE) *errHandler {
+	// Skip newErrHandler and the Conn.HandleE method that called it.
+	site := "unknown"
+	if _, f, l, ok := runtime.Caller(2); ok {
+		site = fmt.Sprintf("%s:%d", f, l)
+	}
+	return &errHandler{event: strings.ToLower(ev), site: site, handler: h}
+}
+
+func (eh *errHandler) Handle(conn *Conn, line *Line) {
+	err := eh.handler.HandleE(conn, line)
+	if err == nil || conn.cfg.OnHandlerError == nil {
+		return
+	}
+	conn.cfg.OnHandlerError(conn, &HandlerError{
+		Event: eh.event,
+		Line:  line,
+		Site:  eh.site,
+		Err:   err,
+	})
+}
+
 // Handlers are organised using a map of linked-lists, with each map
 // key representing an IRC verb or numeric, and the linked list values
 // being handlers that are executed in parallel when a Line from the
@@ -60,6 +142,7 @@ type hNode struct {
 	set        *hSet
 	event      string
 	handler    Handler
+	readOnly   bool
 }
 
 // A hNode implements both Handler (with configurable panic recovery)...
@@ -68,6 +151,14 @@ func (hn *hNode) Handle(conn *Conn, line *Line) {
 	hn.handler.Handle(conn, line)
 }
 
+// line returns the line to pass to the handler, copying it if necessary.
+func (hn *hNode) line(line *Line) *Line {
+	if hn.readOnly {
+		return line
+	}
+	return line.Copy()
+}
+
 // ... and Remover.
 func (hn *hNode) Remove() {
 	hn.set.remove(hn)
@@ -92,6 +183,9 @@ func (hs *hSet) add(ev string, h Handler) Remover {
 		event:   ev,
 		handler: h,
 	}
+	if ro, ok := h.(readOnlyHandler); ok {
+		hn.handler, hn.readOnly = ro.Handler, true
+	}
 	if !ok {
 		l.start = hn
 	} else {
@@ -144,13 +238,20 @@ func (hs *hSet) getHandlers(ev string) []*hNode {
 	return handlers
 }
 
+func (hs *hSet) has(ev string) bool {
+	hs.RLock()
+	defer hs.RUnlock()
+	_, ok := hs.set[ev]
+	return ok
+}
+
 func (hs *hSet) dispatch(conn *Conn, line *Line) {
 	ev := strings.ToLower(line.Cmd)
 	wg := &sync.WaitGroup{}
 	for _, hn := range hs.getHandlers(ev) {
 		wg.Add(1)
 		go func(hn *hNode) {
-			hn.Handle(conn, line.Copy())
+			hn.Handle(conn, hn.line(line))
 			wg.Done()
 		}(hn)
 	}
@@ -181,12 +282,58 @@ func (conn *Conn) HandleFunc(name string, hf HandlerFunc) Remover {
 	return conn.Handle(name, hf)
 }
 
+// HandleE adds the provided error-returning handler to the foreground set
+// for the named event. It will return a Remover that allows that handler
+// to be removed again.
+func (conn *Conn) HandleE(name string, h HandlerE) Remover {
+	return conn.fgHandlers.add(name, newErrHandler(name, h))
+}
+
+// HandleBGE adds the provided error-returning handler to the background
+// set for the named event. It will return a Remover that allows that
+// handler to be removed again.
+func (conn *Conn) HandleBGE(name string, h HandlerE) Remover {
+	return conn.bgHandlers.add(name, newErrHandler(name, h))
+}
+
+// HandleFuncE adds the provided function as an error-returning handler
+// in the foreground set for the named event. It will return a Remover
+// that allows that handler to be removed again.
+func (conn *Conn) HandleFuncE(name string, hf HandlerFuncE) Remover {
+	return conn.fgHandlers.add(name, newErrHandler(name, hf))
+}
+
 func (conn *Conn) dispatch(line *Line) {
+	// Lines in batches are collected until the batch is complete, and may
+	// not be dispatched individually.
+	ok, ev := conn.batch(line)
+	if ok {
+		conn.dispatchLine(line)
+	}
+	if ev != nil {
+		conn.dispatchLine(ev)
+	}
+}
+
+func (conn *Conn) dispatchLine(line *Line) {
 	// We run the internal handlers first, including all state tracking ones.
 	// This ensures that user-supplied handlers that use the tracker have a
 	// consistent view of the connection state in handlers that mutate it.
 	conn.intHandlers.dispatch(conn, line)
-	go conn.bgHandlers.dispatch(conn, line)
+	// Lines from ignored sources stop here, after state tracking.
+	if conn.ignores.ignored(line, conn.CaseMapping()) {
+		logging.Debug("irc.dispatch(): ignoring %s from %s", line.Cmd, line.Src)
+		return
+	}
+	// Background handlers may still be running after dispatch returns and
+	// the line is reused, so they need their own copy.
+	if conn.bgHandlers.has(strings.ToLower(line.Cmd)) {
+		if conn.bgPool == nil {
+			go conn.bgHandlers.dispatch(conn, line.Copy())
+		} else {
+			conn.bgPool.queue(conn, line.Copy())
+		}
+	}
 	conn.fgHandlers.dispatch(conn, line)
 }
 
@@ -200,3 +347,10 @@ func (conn *Conn) LogPanic(line *Line) {
 		logging.Error("%s:%d: panic: %v", f, l, err)
 	}
 }
+
+// LogHandlerError is used as the default error reporter for handlers added
+// with HandleE and friends. It logs the error along with where the handler
+// was added.
+func (conn *Conn) LogHandlerError(he *HandlerError) {
+	logging.Error("%v", he)
+}
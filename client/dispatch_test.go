@@ -1,6 +1,8 @@
 package client
 
 import (
+	"errors"
+	"strings"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -199,3 +201,58 @@ func TestPanicRecovery(t *testing.T) {
 	c.in <- ParseLine(":nick!user@host.com PRIVMSG #channel :OH NO PIGEONS")
 	recovered.assertWasCalled("Failed to recover panic!")
 }
+
+func TestHandlerError(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	errBad := errors.New("bad pigeon")
+	reported := make(chan *HandlerError, 1)
+	c.cfg.OnHandlerError = func(conn *Conn, he *HandlerError) {
+		reported <- he
+	}
+	c.HandleFuncE(PRIVMSG, func(conn *Conn, line *Line) error {
+		if line.Text() == "OK" {
+			return nil
+		}
+		return errBad
+	})
+
+	c.in <- ParseLine(":nick!user@host.com PRIVMSG #channel :OK")
+	c.in <- ParseLine(":nick!user@host.com PRIVMSG #channel :OH NO PIGEONS")
+	select {
+	case he := <-reported:
+		if he.Event != "privmsg" || he.Line.Text() != "OH NO PIGEONS" ||
+			!errors.Is(he, errBad) || !strings.Contains(he.Site, "dispatch_test.go:") {
+			t.Errorf("Handler error not reported correctly: %#v", he)
+		}
+	case <-time.After(10 * time.Millisecond):
+		t.Errorf("Handler error not reported.")
+	}
+	select {
+	case he := <-reported:
+		t.Errorf("Unexpected handler error reported: %v", he)
+	default:
+	}
+}
+
+func benchmarkDispatch(b *testing.B, h Handler) {
+	c := SimpleClient("test")
+	for i := 0; i < 4; i++ {
+		c.Handle(PRIVMSG, h)
+	}
+	l := ParseLine(benchLines[1])
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		c.fgHandlers.dispatch(c, l)
+	}
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	benchmarkDispatch(b, HandlerFunc(func(_ *Conn, _ *Line) {}))
+}
+
+func BenchmarkDispatchReadOnly(b *testing.B) {
+	benchmarkDispatch(b, ReadOnly(HandlerFunc(func(_ *Conn, _ *Line) {})))
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// echoMessageCap is the IRCv3 capability that makes the server send our
+// own PRIVMSGs, NOTICEs and TAGMSGs back to us as it delivered them.
+const echoMessageCap = "echo-message"
+
+// isMessage returns true if cmd is one that echo-message echoes,
+// including CTCPs parsed from PRIVMSGs and NOTICEs.
+func isMessage(cmd string) bool {
+	switch cmd {
+	case PRIVMSG, NOTICE, TAGMSG, CTCP, CTCPREPLY, ACTION:
+		return true
+	}
+	return false
+}
+
+// markSelf sets Line.Self on messages the server echoed back to us.
+func (conn *Conn) markSelf(line *Line) {
+	if isMessage(line.Cmd) && conn.HasCapability(echoMessageCap) {
+		line.Self = conn.isMe(line.Nick)
+	}
+}
+
+// echo queues a local echo of a line we sent, if Config.EchoMessages
+// is set and the server won't echo it for us. Nothing is echoed while
+// the client is disconnected.
+func (conn *Conn) echo(s string) {
+	if !conn.cfg.EchoMessages || conn.HasCapability(echoMessageCap) {
+		return
+	}
+	if line := ParseLine(s); line != nil {
+		conn.echoLine(line)
+	}
+}
+
+// echoLine does the work for echo, given the parsed line. It may be
+// modified, and must not be used afterwards.
+func (conn *Conn) echoLine(line *Line) {
+	if !conn.running.Load() || !conn.cfg.EchoMessages || conn.HasCapability(echoMessageCap) ||
+		!isMessage(line.Cmd) {
+		return
+	}
+	me := conn.Me()
+	line.Nick, line.Ident, line.Host = me.Nick, me.Ident, me.Host
+	line.Src = me.Nick + "!" + me.Ident + "@" + me.Host
+	line.Time = time.Now()
+	line.Received = line.Time
+	line.Self = true
+	line.isupport = conn.isupport
+	// Echoes are dispatched by runLoop, so handlers still see one line
+	// at a time, and any messages they send are echoed after they return.
+	if lines := SplitCTCP(line); lines != nil {
+		conn.echoes.push(lines...)
+		return
+	}
+	conn.echoes.push(line)
+}
+
+// echoQueue holds local echoes waiting for runLoop to dispatch them.
+// It is unbounded so that handlers sending messages from within runLoop
+// never block on it.
+type echoQueue struct {
+	mu    sync.Mutex
+	lines []*Line
+	// Signalled when lines are added.
+	ready chan struct{}
+}
+
+func newEchoQueue() *echoQueue {
+	return &echoQueue{ready: make(chan struct{}, 1)}
+}
+
+func (q *echoQueue) push(lines ...*Line) {
+	q.mu.Lock()
+	q.lines = append(q.lines, lines...)
+	q.mu.Unlock()
+	select {
+	case q.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take removes and returns all the queued lines.
+func (q *echoQueue) take() []*Line {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	lines := q.lines
+	q.lines = nil
+	return lines
+}
+
+// reset discards any queued lines.
+func (q *echoQueue) reset() {
+	q.take()
+	select {
+	case <-q.ready:
+	default:
+	}
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEchoMessage(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	s.st.EXPECT().Me().Return(c.cfg.Me).AnyTimes()
+
+	lines := make(chan *Line, 10)
+	save := func(_ *Conn, l *Line) { lines <- l.Copy() }
+	c.HandleFunc(PRIVMSG, save)
+	c.HandleFunc(CTCP, save)
+	expect := func(cmd string, self bool, args ...string) *Line {
+		t.Helper()
+		select {
+		case l := <-lines:
+			if l.Cmd != cmd || l.Self != self || !reflect.DeepEqual(l.Args, args) {
+				t.Errorf("Expected %s %q (self %t), got %s %q (self %t)",
+					cmd, args, self, l.Cmd, l.Args, l.Self)
+			}
+			return l
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s.", cmd)
+		}
+		return nil
+	}
+
+	// Nothing is echoed unless asked for.
+	c.Privmsg("#chan", "hello")
+	s.nc.Expect("PRIVMSG #chan :hello")
+	if len(lines) != 0 {
+		t.Errorf("Message echoed without EchoMessages.")
+	}
+
+	c.cfg.EchoMessages = true
+	if !c.getRequestCapabilities().Has(echoMessageCap) {
+		t.Errorf("echo-message not requested with EchoMessages.")
+	}
+
+	// Without the cap, messages are echoed locally, and CTCPs are split
+	// out but not replied to.
+	c.Privmsg("#chan", "hello")
+	s.nc.Expect("PRIVMSG #chan :hello")
+	l := expect(PRIVMSG, true, "#chan", "hello")
+	if l.Src != "test!test@" || l.Time.IsZero() {
+		t.Errorf("Bad local echo: %#v", l)
+	}
+	c.Ctcp("#chan", VERSION)
+	s.nc.Expect("PRIVMSG #chan :\001VERSION\001")
+	expect(CTCP, true, VERSION, "#chan", "")
+
+	// With the cap, the server's echoes are marked instead.
+	c.currCaps.Add(echoMessageCap)
+	c.Privmsg("#chan", "hello")
+	s.nc.Expect("PRIVMSG #chan :hello")
+	s.nc.Send("@msgid=abc :Test!test@host PRIVMSG #chan :hello")
+	if l := expect(PRIVMSG, true, "#chan", "hello"); l.Tags["msgid"] != "abc" {
+		t.Errorf("Bad server echo: %#v", l)
+	}
+	s.nc.Send(":other!other@host PRIVMSG #chan :hello")
+	expect(PRIVMSG, false, "#chan", "hello")
+}
+
+func TestEchoMessageNotRunning(t *testing.T) {
+	// With no runLoop to dispatch them, nothing is echoed.
+	c, s := setUp(t, false)
+	defer s.tearDown()
+	c.cfg.EchoMessages = true
+
+	dispatched := false
+	c.HandleFunc(PRIVMSG, func(_ *Conn, _ *Line) { dispatched = true })
+	c.Privmsg("#chan", "hello")
+	if len(c.echoes.take()) != 0 || dispatched {
+		t.Errorf("Message echoed while not running.")
+	}
+}
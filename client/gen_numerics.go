@@ -0,0 +1,89 @@
+//go:build ignore
+
+// gen_numerics generates numerics.go from numerics.txt.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	lineRx  = regexp.MustCompile(`^(\d{3})\s+([A-Z][A-Z0-9_]*)\s+(\S+)\s+(.*)$`)
+	classes = map[string]string{
+		"registration": "NumericRegistration",
+		"reply":        "NumericReply",
+		"whois":        "NumericWhois",
+		"error":        "NumericError",
+	}
+)
+
+type numeric struct {
+	code, name, class, args string
+}
+
+func main() {
+	f, err := os.Open("numerics.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	var nums []numeric
+	seen := map[string]bool{}
+	s := bufio.NewScanner(f)
+	for n := 1; s.Scan(); n++ {
+		l := strings.TrimSpace(s.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		m := lineRx.FindStringSubmatch(l)
+		if m == nil {
+			log.Fatalf("numerics.txt:%d: can't parse %q", n, l)
+		}
+		class, ok := classes[m[3]]
+		if !ok {
+			log.Fatalf("numerics.txt:%d: unknown class %q", n, m[3])
+		}
+		if seen[m[1]] || seen[m[2]] {
+			log.Fatalf("numerics.txt:%d: duplicate numeric %s %s", n, m[1], m[2])
+		}
+		seen[m[1]], seen[m[2]] = true, true
+		nums = append(nums, numeric{m[1], m[2], class, m[4]})
+	}
+	if err := s.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintln(&b, "// Code generated by gen_numerics.go from numerics.txt; DO NOT EDIT.")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "package client")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "// Numeric replies. See numerics.txt for their expected arguments.")
+	fmt.Fprintln(&b, "const (")
+	for _, n := range nums {
+		fmt.Fprintf(&b, "\t%s = %q\n", n.name, n.code)
+	}
+	fmt.Fprintln(&b, ")")
+	fmt.Fprintln(&b)
+	fmt.Fprintln(&b, "var numerics = map[string]Numeric{")
+	for _, n := range nums {
+		fmt.Fprintf(&b, "\t%s: {%s, %q, %s, %q},\n", n.name, n.name, n.name, n.class, n.args)
+	}
+	fmt.Fprintln(&b, "}")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile("numerics.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
@@ -9,37 +9,82 @@ import (
 	"sync"
 	"time"
 
-	"encoding/base64"
 	"github.com/fluffle/goirc/logging"
+	"github.com/fluffle/goirc/state"
 )
 
-// saslCap is the IRCv3 capability used for SASL authentication.
-const saslCap = "sasl"
+// messageTagsCap is the IRCv3 capability that allows clients to send tags.
+const messageTagsCap = "message-tags"
 
 // sets up the internal event handlers to do essential IRC protocol things
 var intHandlers = map[string]HandlerFunc{
-	REGISTER:     (*Conn).h_REGISTER,
-	"001":        (*Conn).h_001,
-	"433":        (*Conn).h_433,
-	CTCP:         (*Conn).h_CTCP,
-	NICK:         (*Conn).h_NICK,
-	PING:         (*Conn).h_PING,
-	CAP:          (*Conn).h_CAP,
-	"410":        (*Conn).h_410,
-	AUTHENTICATE: (*Conn).h_AUTHENTICATE,
-	"903":        (*Conn).h_903,
-	"904":        (*Conn).h_904,
-	"908":        (*Conn).h_908,
+	REGISTER:          (*Conn).h_REGISTER,
+	RPL_WELCOME:       (*Conn).h_001,
+	RPL_ISUPPORT:      (*Conn).h_005,
+	ERR_NICKNAMEINUSE: (*Conn).h_433,
+	CTCP:              (*Conn).h_CTCP,
+	NICK:              (*Conn).h_NICK,
+	PING:              (*Conn).h_PING,
+	CAP:               (*Conn).h_CAP,
+	ERR_INVALIDCAPCMD: (*Conn).h_410,
+	AUTHENTICATE:      (*Conn).h_AUTHENTICATE,
+	RPL_LOGGEDIN:      (*Conn).h_900,
+	RPL_LOGGEDOUT:     (*Conn).h_901,
+	ERR_NICKLOCKED:    (*Conn).h_902,
+	RPL_SASLSUCCESS:   (*Conn).h_903,
+	ERR_SASLFAIL:      (*Conn).h_904,
+	ERR_SASLTOOLONG:   (*Conn).h_904,
+	ERR_SASLABORTED:   (*Conn).h_906,
+	ERR_SASLALREADY:   (*Conn).h_907,
+	RPL_SASLMECHS:     (*Conn).h_908,
+	BATCH:             (*Conn).h_BATCH,
+	FAIL:              (*Conn).h_FAIL,
 }
 
+// serverTimeCap is the IRCv3 capability that adds a time tag to messages,
+// which is used to set Line.Time.
+const serverTimeCap = "server-time"
+
+// serverTimeTag is the tag containing the time a message was sent.
+const serverTimeTag = "time"
+
 // set up the ircv3 capabilities supported by this client which will be requested by default to the server.
-var defaultCaps = []string{}
+var defaultCaps = []string{messageTagsCap, serverTimeCap, batchCap, chatHistoryCap, multilineCap, capNotifyCap,
+	accountNotifyCap, accountTagCap, awayNotifyCap,
+	chghostCap, setnameCap}
+
+// capNotifyCap is the IRCv3 capability that enables CAP NEW and CAP DEL
+// messages. It is implicitly enabled by CAP LS 302, but requesting it
+// does no harm.
+const capNotifyCap = "cap-notify"
+
+// capVersion is the version of capability negotiation we support.
+const capVersion = "302"
+
+// readOnlyIntHandlers lists the internal handlers that have been checked
+// to read tags only with Line.Tag and not to keep the line or its Args,
+// so they can be passed pooled lines without copying. h_CTCP is not one,
+// as it passes the line to CTCP responders. Check any new handler before
+// adding it here.
+var readOnlyIntHandlers = map[string]bool{
+	REGISTER: true, RPL_WELCOME: true, RPL_ISUPPORT: true,
+	ERR_NICKNAMEINUSE: true, NICK: true, PING: true, CAP: true,
+	ERR_INVALIDCAPCMD: true, AUTHENTICATE: true, RPL_LOGGEDIN: true,
+	RPL_LOGGEDOUT: true, ERR_NICKLOCKED: true, RPL_SASLSUCCESS: true,
+	ERR_SASLFAIL: true, ERR_SASLTOOLONG: true, ERR_SASLABORTED: true,
+	ERR_SASLALREADY: true, RPL_SASLMECHS: true, BATCH: true, FAIL: true,
+}
 
 func (conn *Conn) addIntHandlers() {
 	for n, h := range intHandlers {
 		// internal handlers are essential for the IRC client
 		// to function, so we don't save their Removers here
-		conn.handle(n, h)
+		var hd Handler = h
+		if readOnlyIntHandlers[n] {
+			// Checked not to keep the line; see readOnlyIntHandlers.
+			hd = ReadOnly(h)
+		}
+		conn.handle(n, hd)
 	}
 }
 
@@ -51,7 +96,8 @@ func (conn *Conn) h_PING(line *Line) {
 // Handler for initial registration with server once tcp connection is made.
 func (conn *Conn) h_REGISTER(line *Line) {
 	if conn.cfg.EnableCapabilityNegotiation {
-		conn.Cap(CAP_LS)
+		conn.negotiating = true
+		conn.Raw(CAP + " " + CAP_LS + " " + capVersion)
 	}
 
 	if conn.cfg.Pass != "" {
@@ -67,17 +113,28 @@ func (conn *Conn) getRequestCapabilities() *capSet {
 	// add capabilites supported by the client
 	s.Add(defaultCaps...)
 
-	if conn.cfg.Sasl != nil {
+	if len(conn.saslClients()) > 0 {
 		// add the SASL cap if enabled
 		s.Add(saslCap)
 	}
 
+	if conn.cfg.EchoMessages {
+		s.Add(echoMessageCap)
+	}
+
+	if conn.st != nil {
+		// Only ask for caps that change existing replies if we need them.
+		s.Add(stateCaps...)
+	}
+
 	// add capabilites requested by the user
 	s.Add(conn.cfg.Capabilites...)
 
 	return s
 }
 
+// negotiateCapabilities requests the capabilities we want once the server
+// has finished listing the capabilities it supports.
 func (conn *Conn) negotiateCapabilities(supportedCaps []string) {
 	conn.supportedCaps.Add(supportedCaps...)
 
@@ -87,73 +144,142 @@ func (conn *Conn) negotiateCapabilities(supportedCaps []string) {
 	if reqCaps.Size() > 0 {
 		conn.Cap(CAP_REQ, reqCaps.Slice()...)
 	} else {
+		conn.endNegotiation()
+	}
+}
+
+// endNegotiation sends CAP END to finish registration, if initial
+// capability negotiation is still in progress.
+func (conn *Conn) endNegotiation() {
+	if conn.negotiating {
+		conn.negotiating = false
 		conn.Cap(CAP_END)
 	}
 }
 
+// requestNewCapabilities requests any capabilities we want from those
+// the server has started to support since negotiation.
+func (conn *Conn) requestNewCapabilities(newCaps []string) {
+	conn.supportedCaps.Add(newCaps...)
+
+	reqCaps := capabilitySet()
+	reqCaps.Add(newCaps...)
+	reqCaps.Intersect(conn.getRequestCapabilities())
+	var req []string
+	for _, cap := range reqCaps.Slice() {
+		if !conn.currCaps.Has(cap) {
+			req = append(req, cap)
+		}
+	}
+	if len(req) > 0 {
+		conn.Cap(CAP_REQ, req...)
+	}
+}
+
+// removeCapabilities handles capabilities the server no longer supports.
+func (conn *Conn) removeCapabilities(delCaps []string) {
+	var removed []string
+	for _, cap := range delCaps {
+		conn.supportedCaps.Add("-" + cap)
+		if conn.currCaps.Has(cap) {
+			conn.currCaps.Add("-" + cap)
+			removed = append(removed, cap)
+		}
+	}
+	if len(removed) > 0 {
+		conn.dispatch(&Line{Cmd: CAPDISABLED, Args: removed, Time: time.Now()})
+	}
+}
+
 func (conn *Conn) handleCapAck(caps []string) {
 	gotSasl := false
+	var enabled, disabled []string
 	for _, cap := range caps {
 		conn.currCaps.Add(cap)
+		if strings.HasPrefix(cap, "-") {
+			disabled = append(disabled, cap[1:])
+		} else {
+			enabled = append(enabled, cap)
+		}
 
-		if conn.cfg.Sasl != nil && cap == saslCap {
-			mech, ir, err := conn.cfg.Sasl.Start()
-
-			if err != nil {
-				logging.Warn("SASL authentication failed: %v", err)
-				continue
-			}
-
-			// TODO: when IRC 3.2 capability negotiation is supported, ensure the
-			// capability value is used to match the chosen mechanism
-
+		// SASL is only possible before registration completes.
+		if cap == saslCap && conn.negotiating && conn.saslStart() {
 			gotSasl = true
-			conn.saslRemainingData = ir
-
-			conn.Authenticate(mech)
 		}
 	}
 
+	if len(enabled) > 0 {
+		conn.dispatch(&Line{Cmd: CAPENABLED, Args: enabled, Time: time.Now()})
+	}
+	if len(disabled) > 0 {
+		conn.dispatch(&Line{Cmd: CAPDISABLED, Args: disabled, Time: time.Now()})
+	}
+
 	if !gotSasl {
-		conn.Cap(CAP_END)
+		conn.endNegotiation()
 	}
 }
 
 func (conn *Conn) handleCapNak(caps []string) {
-	conn.Cap(CAP_END)
+	conn.endNegotiation()
 }
 
 const (
-	CAP_LS  = "LS"
-	CAP_REQ = "REQ"
-	CAP_ACK = "ACK"
-	CAP_NAK = "NAK"
-	CAP_END = "END"
+	CAP_LS   = "LS"
+	CAP_LIST = "LIST"
+	CAP_REQ  = "REQ"
+	CAP_ACK  = "ACK"
+	CAP_NAK  = "NAK"
+	CAP_END  = "END"
+	CAP_NEW  = "NEW"
+	CAP_DEL  = "DEL"
 )
 
 type capSet struct {
-	caps map[string]bool
-	mu   sync.RWMutex
+	caps   map[string]bool
+	values map[string]string
+	mu     sync.RWMutex
 }
 
 func capabilitySet() *capSet {
 	return &capSet{
-		caps: make(map[string]bool),
+		caps:   make(map[string]bool),
+		values: make(map[string]string),
 	}
 }
 
+// Add adds capabilities to the set. Capabilities may have values, as in
+// "draft/multiline=max-bytes=4096", and are removed if prefixed with "-".
 func (c *capSet) Add(caps ...string) {
 	c.mu.Lock()
 	for _, cap := range caps {
 		if strings.HasPrefix(cap, "-") {
-			c.caps[cap[1:]] = false
+			delete(c.caps, cap[1:])
+			delete(c.values, cap[1:])
 		} else {
-			c.caps[cap] = true
+			name, value, _ := strings.Cut(cap, "=")
+			c.caps[name] = true
+			c.values[name] = value
 		}
 	}
 	c.mu.Unlock()
 }
 
+// Value returns the value of a capability, or "" if it has none.
+func (c *capSet) Value(cap string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.values[cap]
+}
+
+// reset removes all the capabilities from the set.
+func (c *capSet) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.caps = make(map[string]bool)
+	c.values = make(map[string]string)
+}
+
 func (c *capSet) Has(cap string) bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -167,6 +293,7 @@ func (c *capSet) Intersect(other *capSet) {
 	for cap := range c.caps {
 		if !other.Has(cap) {
 			delete(c.caps, cap)
+			delete(c.values, cap)
 		}
 	}
 
@@ -202,78 +329,41 @@ func (conn *Conn) h_410(line *Line) {
 // Note that even if multiple CAP_END commands may be sent to the server during negotiation,
 // only the first will be considered.
 func (conn *Conn) h_CAP(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
 	subcommand := line.Args[1]
 
 	caps := strings.Fields(line.Text())
+	// Long replies to CAP LS 302 are split over several lines, with a "*"
+	// before the list of caps on all but the last one.
+	//     :<server> CAP <nick> LS * :<caps>
+	more := len(line.Args) > 3 && line.Args[2] == "*"
 	switch subcommand {
 	case CAP_LS:
-		conn.negotiateCapabilities(caps)
+		if more {
+			conn.supportedCaps.Add(caps...)
+		} else {
+			conn.negotiateCapabilities(caps)
+		}
 	case CAP_ACK:
 		conn.handleCapAck(caps)
 	case CAP_NAK:
 		conn.handleCapNak(caps)
+	case CAP_NEW:
+		conn.requestNewCapabilities(caps)
+	case CAP_DEL:
+		conn.removeCapabilities(caps)
 	}
 }
 
-// Handler for SASL authentication
-func (conn *Conn) h_AUTHENTICATE(line *Line) {
-	if conn.cfg.Sasl == nil {
-		return
-	}
-
-	if conn.saslRemainingData != nil {
-		data := "+" // plus sign representing empty data
-		if len(conn.saslRemainingData) > 0 {
-			data = base64.StdEncoding.EncodeToString(conn.saslRemainingData)
-		}
-
-		// TODO: batch data into chunks of 400 bytes per the spec
-
-		conn.Authenticate(data)
-		conn.saslRemainingData = nil
-		return
-	}
-
-	// TODO: handle data over 400 bytes long (which will be chunked into multiple messages per the spec)
-	challenge, err := base64.StdEncoding.DecodeString(line.Args[0])
-	if err != nil {
-		logging.Error("Failed to decode SASL challenge: %v", err)
-		return
-	}
-
-	response, err := conn.cfg.Sasl.Next(challenge)
-	if err != nil {
-		logging.Error("Failed to generate response for SASL challenge: %v", err)
-		return
-	}
-
-	// TODO: batch data into chunks of 400 bytes per the spec
-	data := base64.StdEncoding.EncodeToString(response)
-	conn.Authenticate(data)
-}
-
-// Handler for RPL_SASLSUCCESS.
-func (conn *Conn) h_903(line *Line) {
-	conn.Cap(CAP_END)
-}
-
-// Handler for RPL_SASLFAILURE.
-func (conn *Conn) h_904(line *Line) {
-	logging.Warn("SASL authentication failed")
-	conn.Cap(CAP_END)
-}
-
-// Handler for RPL_SASLMECHS.
-func (conn *Conn) h_908(line *Line) {
-	logging.Warn("SASL mechanism not supported, supported mechanisms are: %v", line.Args[1])
-	conn.Cap(CAP_END)
-}
-
 // Handler to trigger a CONNECTED event on receipt of numeric 001
 // :<server> 001 <nick> :Welcome message <nick>!<user>@<host>
 func (conn *Conn) h_001(line *Line) {
 	// We're connected! Defer this for control flow reasons.
 	defer conn.dispatch(&Line{Cmd: CONNECTED, Time: time.Now()})
+	// Registration is complete, so capability negotiation must be too.
+	conn.negotiating = false
 
 	// Accept the server's opinion of what our nick actually is
 	// and record our ident and hostname (from the server's perspective)
@@ -300,17 +390,27 @@ func (conn *Conn) h_001(line *Line) {
 	}
 }
 
-// XXX: do we need 005 protocol support message parsing here?
-// probably in the future, but I can't quite be arsed yet.
-/*
-	:irc.pl0rt.org 005 GoTest CMDS=KNOCK,MAP,DCCALLOW,USERIP UHNAMES NAMESX SAFELIST HCN MAXCHANNELS=20 CHANLIMIT=#:20 MAXLIST=b:60,e:60,I:60 NICKLEN=30 CHANNELLEN=32 TOPICLEN=307 KICKLEN=307 AWAYLEN=307 :are supported by this server
-	:irc.pl0rt.org 005 GoTest MAXTARGETS=20 WALLCHOPS WATCH=128 WATCHOPTS=A SILENCE=15 MODES=12 CHANTYPES=# PREFIX=(qaohv)~&@%+ CHANMODES=beI,kfL,lj,psmntirRcOAQKVCuzNSMT NETWORK=bb101.net CASEMAPPING=ascii EXTBAN=~,cqnr ELIST=MNUCT :are supported by this server
-	:irc.pl0rt.org 005 GoTest STATUSMSG=~&@%+ EXCEPTS INVEX :are supported by this server
-*/
+// Handler for RPL_ISUPPORT, which lists the features the server supports.
+// :<server> 005 <nick> <token>[=<value>] ... :are supported by this server
+func (conn *Conn) h_005(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
+	cm := conn.isupport.CaseMapping()
+	conn.isupport.parse(line.Args[1 : len(line.Args)-1])
+	if v, ok := conn.isupport.Get("CASEMAPPING"); ok {
+		if _, known := state.ParseCaseMapping(v); !known {
+			logging.Warn("irc.005(): unknown casemapping %q, using %s", v, state.RFC1459)
+		}
+	}
+	if neu := conn.isupport.CaseMapping(); neu != cm && conn.st != nil {
+		conn.st.SetCaseMapping(neu)
+	}
+}
 
 // Handler to deal with "433 :Nickname already in use"
 func (conn *Conn) h_433(line *Line) {
-	// Delay trying again if a non-zero delay was set 
+	// Delay trying again if a non-zero delay was set
 	time.Sleep(conn.cfg.ReNickDelay)
 
 	// Args[1] is the new nick we were attempting to acquire
@@ -323,7 +423,7 @@ func (conn *Conn) h_433(line *Line) {
 	// if this is happening before we're properly connected (i.e. the nick
 	// we sent in the initial NICK command is in use) we will not receive
 	// a NICK message to confirm our change of nick, so ReNick here...
-	if line.Args[1] == me.Nick {
+	if conn.CaseMapping().Equal(line.Args[1], me.Nick) {
 		if conn.st != nil {
 			conn.cfg.Me = conn.st.ReNick(me.Nick, neu)
 		} else {
@@ -332,18 +432,9 @@ func (conn *Conn) h_433(line *Line) {
 	}
 }
 
-// Handle VERSION requests and CTCP PING
-func (conn *Conn) h_CTCP(line *Line) {
-	if line.Args[0] == VERSION {
-		conn.CtcpReply(line.Nick, VERSION, conn.cfg.Version)
-	} else if line.Args[0] == PING && line.argslen(2) {
-		conn.CtcpReply(line.Nick, PING, line.Args[2])
-	}
-}
-
 // Handle updating our own NICK if we're not using the state tracker
 func (conn *Conn) h_NICK(line *Line) {
-	if conn.st == nil && line.Nick == conn.cfg.Me.Nick {
+	if conn.st == nil && conn.isMe(line.Nick) {
 		conn.cfg.Me.Nick = line.Args[0]
 	}
 }
@@ -1,6 +1,8 @@
 package client
 
 import (
+	"reflect"
+	"slices"
 	"testing"
 	"time"
 
@@ -143,6 +145,43 @@ func TestNICK(t *testing.T) {
 	if c.cfg.Me.Nick != "test1" {
 		t.Errorf("NICK changed our nick when state tracking enabled.")
 	}
+
+	// Our nick is compared using the server's casemapping.
+	c.st = nil
+	c.h_NICK(ParseLine(":TEST1!test@somehost.com NICK :test[]"))
+	c.h_NICK(ParseLine(":test{}!test@somehost.com NICK :test2"))
+	if c.cfg.Me.Nick != "test2" {
+		t.Errorf("NICK not compared using casemapping, got %q.", c.cfg.Me.Nick)
+	}
+}
+
+// Test the handler for RPL_ISUPPORT
+func Test005(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	if c.CaseMapping() != state.RFC1459 {
+		t.Errorf("Default casemapping is not rfc1459.")
+	}
+	s.st.EXPECT().SetCaseMapping(state.ASCII)
+	c.h_005(ParseLine(":irc.server.org 005 test NICKLEN=30 CASEMAPPING=ascii " +
+		"CHANTYPES=# :are supported by this server"))
+	if c.CaseMapping() != state.ASCII {
+		t.Errorf("CASEMAPPING not parsed from 005.")
+	}
+
+	// Other 005 lines don't change the casemapping.
+	c.h_005(ParseLine(":irc.server.org 005 test EXCEPTS INVEX :are supported by this server"))
+	if c.CaseMapping() != state.ASCII {
+		t.Errorf("Casemapping changed by unrelated 005.")
+	}
+
+	// Unknown casemappings fall back to rfc1459.
+	s.st.EXPECT().SetCaseMapping(state.RFC1459)
+	c.h_005(ParseLine(":irc.server.org 005 test CASEMAPPING=bogus :are supported by this server"))
+	if c.CaseMapping() != state.RFC1459 {
+		t.Errorf("Unknown casemapping not treated as rfc1459.")
+	}
 }
 
 // Test the handler for CTCP messages
@@ -216,6 +255,19 @@ func TestJOIN(t *testing.T) {
 	)
 	c.h_JOIN(ParseLine(":user2!ident2@host2.com JOIN :#test1"))
 
+	// With userhost-in-names, we don't need a WHO for the channel.
+	c.currCaps.Add(userhostInNamesCap)
+	gomock.InOrder(
+		s.st.EXPECT().GetChannel("#test3").Return(nil),
+		s.st.EXPECT().GetNick("test").Return(c.cfg.Me),
+		s.st.EXPECT().Me().Return(c.cfg.Me),
+		s.st.EXPECT().NewChannel("#test3").Return(&state.Channel{Name: "#test3"}),
+		s.st.EXPECT().Associate("#test3", "test"),
+	)
+	c.h_JOIN(ParseLine(":test!test@somehost.com JOIN :#test3"))
+	s.nc.Expect("MODE #test3")
+	s.nc.ExpectNothing()
+
 	// Test error paths
 	gomock.InOrder(
 		// unknown channel, unknown nick
@@ -231,6 +283,47 @@ func TestJOIN(t *testing.T) {
 	c.h_JOIN(ParseLine(":user2!ident2@host2.com JOIN :#test2"))
 }
 
+// Test JOINs with extended-join and WHOX
+func TestJOINExtended(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.currCaps.Add(extendedJoinCap)
+	c.isupport.parse([]string{"WHOX"})
+	var events []*Line
+	c.HandleFunc(LOGGEDIN, func(_ *Conn, l *Line) { events = append(events, l.Copy()) })
+
+	chan1 := &state.Channel{Name: "#test1"}
+	nick1 := &state.Nick{Nick: "user1"}
+	gomock.InOrder(
+		s.st.EXPECT().GetChannel("#test1").Return(chan1),
+		s.st.EXPECT().GetNick("user1").Return(nil),
+		s.st.EXPECT().NewNick("user1").Return(nick1),
+		s.st.EXPECT().NickInfo("user1", "ident1", "host1.com", "Real Name").Return(nick1),
+		s.st.EXPECT().Associate("#test1", "user1"),
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().NickAccount("user1", "acct1"),
+	)
+	c.h_JOIN(ParseLine(":user1!ident1@host1.com JOIN #test1 acct1 :Real Name"))
+	s.nc.Expect("WHO user1 %tcuhnfar,152")
+	if len(events) != 1 || events[0].Nick != "user1" ||
+		!reflect.DeepEqual(events[0].Args, []string{"user1", "acct1"}) {
+		t.Errorf("Bad LOGGEDIN events: %#v", events)
+	}
+
+	// Users that aren't logged in have an account of "*".
+	nick2 := &state.Nick{Nick: "user2"}
+	gomock.InOrder(
+		s.st.EXPECT().GetChannel("#test1").Return(chan1),
+		s.st.EXPECT().GetNick("user2").Return(nick2),
+		s.st.EXPECT().Associate("#test1", "user2"),
+		s.st.EXPECT().GetNick("user2").Return(nick2),
+	)
+	c.h_JOIN(ParseLine(":user2!ident2@host2.com JOIN #test1 * :Real Name"))
+	if len(events) != 1 {
+		t.Errorf("Unexpected LOGGEDIN event: %#v", events)
+	}
+}
+
 // Test the handler for PART messages
 func TestPART(t *testing.T) {
 	c, s := setUp(t)
@@ -371,11 +464,14 @@ func Test352(t *testing.T) {
 	c, s := setUp(t)
 	defer s.tearDown()
 
-	// Ensure 352 reply calls NickInfo and NickModes
+	// Ensure 352 reply calls NickInfo and NickModes, and NickAway as
+	// user1 is gone
 	gomock.InOrder(
 		s.st.EXPECT().GetNick("user1").Return(&state.Nick{Nick: "user1"}),
 		s.st.EXPECT().Me().Return(c.cfg.Me),
 		s.st.EXPECT().NickInfo("user1", "ident1", "host1.com", "name"),
+		s.st.EXPECT().GetNick("user1").Return(&state.Nick{Nick: "user1"}),
+		s.st.EXPECT().NickAway("user1", true, ""),
 	)
 	c.h_352(ParseLine(":irc.server.org 352 test #test1 ident1 host1.com irc.server.org user1 G :0 name"))
 
@@ -384,8 +480,8 @@ func Test352(t *testing.T) {
 		s.st.EXPECT().GetNick("user1").Return(&state.Nick{Nick: "user1"}),
 		s.st.EXPECT().Me().Return(c.cfg.Me),
 		s.st.EXPECT().NickInfo("user1", "ident1", "host1.com", "name"),
+		s.st.EXPECT().GetNick("user1").Return(&state.Nick{Nick: "user1"}),
 		s.st.EXPECT().NickModes("user1", "+o"),
-		s.st.EXPECT().NickModes("user1", "+i"),
 	)
 	c.h_352(ParseLine(":irc.server.org 352 test #test1 ident1 host1.com irc.server.org user1 H* :0 name"))
 
@@ -394,6 +490,193 @@ func Test352(t *testing.T) {
 	c.h_352(ParseLine(":irc.server.org 352 test #test2 ident2 host2.com irc.server.org user2 G :0 fooo"))
 }
 
+// Test the handlers for ACCOUNT messages and account tags
+func TestACCOUNT(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	var events []*Line
+	save := func(_ *Conn, l *Line) { events = append(events, l.Copy()) }
+	c.HandleFunc(LOGGEDIN, save)
+	c.HandleFunc(LOGGEDOUT, save)
+
+	nick1 := &state.Nick{Nick: "user1"}
+	gomock.InOrder(
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().NickAccount("user1", "acct1"),
+	)
+	c.h_ACCOUNT(ParseLine(":user1!ident1@host1.com ACCOUNT acct1"))
+
+	nick1 = &state.Nick{Nick: "user1", Account: "acct1"}
+	gomock.InOrder(
+		// Nothing happens if the account doesn't change.
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().NickAccount("user1", ""),
+	)
+	c.h_ACCOUNTTAG(ParseLine("@account=acct1 :user1!ident1@host1.com PRIVMSG #test1 :hi"))
+	c.h_ACCOUNT(ParseLine(":user1!ident1@host1.com ACCOUNT *"))
+
+	if len(events) != 2 ||
+		events[0].Cmd != LOGGEDIN || !reflect.DeepEqual(events[0].Args, []string{"user1", "acct1"}) ||
+		events[1].Cmd != LOGGEDOUT || !reflect.DeepEqual(events[1].Args, []string{"user1", "acct1"}) {
+		t.Errorf("Bad account events: %#v", events)
+	}
+
+	// Messages without the tag and unknown nicks are ignored.
+	s.st.EXPECT().GetNick("user2").Return(nil)
+	c.h_ACCOUNTTAG(ParseLine(":user1!ident1@host1.com PRIVMSG #test1 :hi"))
+	c.h_ACCOUNT(ParseLine(":user2!ident2@host2.com ACCOUNT acct2"))
+}
+
+// Test the handler for 330 / RPL_WHOISACCOUNT
+func Test330(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	nick1 := &state.Nick{Nick: "user1"}
+	gomock.InOrder(
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().NickAccount("user1", "acct1"),
+	)
+	c.h_330(ParseLine(":irc.server.org 330 test user1 acct1 :is logged in as"))
+
+	s.st.EXPECT().GetNick("user2").Return(nil)
+	c.h_330(ParseLine(":irc.server.org 330 test user2 acct2 :is logged in as"))
+}
+
+// Test the handler for 354 / RPL_WHOSPCRPL
+func Test354(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	nick1 := &state.Nick{Nick: "user1"}
+	gomock.InOrder(
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().NickAccount("user1", "acct1"),
+		s.st.EXPECT().Me().Return(c.cfg.Me),
+		s.st.EXPECT().NickInfo("user1", "ident1", "host1.com", "Real Name"),
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().NickAway("user1", true, ""),
+		s.st.EXPECT().NickModes("user1", "+o"),
+	)
+	c.h_354(ParseLine(":irc.server.org 354 test 152 #test1 ident1 host1.com user1 G* acct1 :Real Name"))
+
+	// An account of "0" means not logged in, and H means not away, but
+	// says nothing about modes.
+	gomock.InOrder(
+		s.st.EXPECT().GetNick("user2").Return(&state.Nick{Nick: "user2"}),
+		s.st.EXPECT().GetNick("user2").Return(&state.Nick{Nick: "user2"}),
+		s.st.EXPECT().Me().Return(c.cfg.Me),
+		s.st.EXPECT().NickInfo("user2", "ident2", "host2.com", "Real Name"),
+		s.st.EXPECT().GetNick("user2").Return(&state.Nick{Nick: "user2", Away: true}),
+		s.st.EXPECT().NickAway("user2", false, ""),
+	)
+	c.h_354(ParseLine(":irc.server.org 354 test 152 #test1 ident2 host2.com user2 H 0 :Real Name"))
+
+	// Replies to other WHOX queries are ignored.
+	c.h_354(ParseLine(":irc.server.org 354 test 999 #test1 ident1 host1.com user1 G* acct1 :Real Name"))
+	c.h_354(ParseLine(":irc.server.org 354 test user1 acct1"))
+}
+
+// Test the handlers for AWAY messages and away replies
+func TestAWAY(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	var events []*Line
+	c.HandleFunc(AWAYCHANGED, func(_ *Conn, l *Line) { events = append(events, l.Copy()) })
+
+	nick1 := &state.Nick{Nick: "user1"}
+	away1 := &state.Nick{Nick: "user1", Away: true, AwayMsg: "gone"}
+	gomock.InOrder(
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().NickAway("user1", true, "gone"),
+		// Replies that don't change anything don't dispatch events,
+		s.st.EXPECT().GetNick("user1").Return(away1),
+		// and the away message is kept if a new one isn't known.
+		s.st.EXPECT().GetNick("user1").Return(away1),
+		s.st.EXPECT().GetNick("user1").Return(away1),
+		s.st.EXPECT().NickAway("user1", false, ""),
+	)
+	c.h_AWAY(ParseLine(":user1!ident1@host1.com AWAY :gone"))
+	c.h_301(ParseLine(":irc.server.org 301 test user1 :gone"))
+	c.whoFlags("user1", "G")
+	c.h_AWAY(ParseLine(":user1!ident1@host1.com AWAY"))
+
+	if len(events) != 2 || events[0].Nick != "user1" ||
+		!reflect.DeepEqual(events[0].Args, []string{"user1", "gone"}) ||
+		!reflect.DeepEqual(events[1].Args, []string{"user1"}) {
+		t.Errorf("Bad AWAYCHANGED events: %#v", events)
+	}
+
+	// Our own away status is set from 305 and 306.
+	me := c.cfg.Me
+	gomock.InOrder(
+		s.st.EXPECT().Me().Return(me),
+		s.st.EXPECT().GetNick("test").Return(me),
+		s.st.EXPECT().NickAway("test", true, "brb"),
+		s.st.EXPECT().Me().Return(me),
+		s.st.EXPECT().GetNick("test").Return(&state.Nick{Nick: "test", Away: true, AwayMsg: "brb"}),
+		s.st.EXPECT().NickAway("test", false, ""),
+	)
+	c.Away("brb")
+	s.nc.Expect("AWAY :brb")
+	c.h_306(ParseLine(":irc.server.org 306 test :You have been marked as being away"))
+	c.h_305(ParseLine(":irc.server.org 305 test :You are no longer marked as being away"))
+}
+
+// Test the handlers for CHGHOST, SETNAME and 396 / RPL_HOSTHIDDEN
+func TestCHGHOST(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	var events []*Line
+	save := func(_ *Conn, l *Line) { events = append(events, l.Copy()) }
+	c.HandleFunc(HOSTCHANGED, save)
+	c.HandleFunc(NAMECHANGED, save)
+
+	nick1 := &state.Nick{Nick: "user1", Ident: "ident1", Host: "host1.com", Name: "name"}
+	me := &state.Nick{Nick: "test", Ident: "test", Host: "1.2.3.4", Name: "Testing IRC"}
+	gomock.InOrder(
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().NickInfo("user1", "new", "new.host", "name"),
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().NickInfo("user1", "ident1", "host1.com", "New Name"),
+		// Nothing happens if nothing changes.
+		s.st.EXPECT().GetNick("user1").Return(nick1),
+		s.st.EXPECT().Me().Return(me),
+		s.st.EXPECT().GetNick("test").Return(me),
+		s.st.EXPECT().NickInfo("test", "test", "cloak.example", "Testing IRC"),
+		s.st.EXPECT().Me().Return(me),
+		s.st.EXPECT().GetNick("test").Return(me),
+		s.st.EXPECT().NickInfo("test", "~test", "cloak.example", "Testing IRC"),
+	)
+	c.h_CHGHOST(ParseLine(":user1!ident1@host1.com CHGHOST new new.host"))
+	c.h_SETNAME(ParseLine(":user1!ident1@host1.com SETNAME :New Name"))
+	c.h_SETNAME(ParseLine(":user1!ident1@host1.com SETNAME :name"))
+	c.h_396(ParseLine(":irc.server.org 396 test cloak.example :is now your displayed host"))
+	c.h_396(ParseLine(":irc.server.org 396 test ~test@cloak.example :is now your displayed host"))
+
+	want := [][]string{
+		{HOSTCHANGED, "user1", "new", "new.host"},
+		{NAMECHANGED, "user1", "New Name"},
+		{HOSTCHANGED, "test", "test", "cloak.example"},
+		{HOSTCHANGED, "test", "~test", "cloak.example"},
+	}
+	if len(events) != len(want) {
+		t.Fatalf("Expected %d events, got %d: %#v", len(want), len(events), events)
+	}
+	for i, ev := range events {
+		if got := append([]string{ev.Cmd}, ev.Args...); !reflect.DeepEqual(got, want[i]) {
+			t.Errorf("Event %d: expected %q, got %q", i, want[i], got)
+		}
+	}
+
+	// Changes for unknown nicks are ignored.
+	s.st.EXPECT().GetNick("user2").Return(nil)
+	c.h_CHGHOST(ParseLine(":user2!ident2@host2.com CHGHOST new new.host"))
+}
+
 // Test the handler for 353 / RPL_NAMREPLY
 func Test353(t *testing.T) {
 	c, s := setUp(t)
@@ -440,6 +723,35 @@ func Test353(t *testing.T) {
 	c.h_353(ParseLine(":irc.server.org 353 test = #test2 :test ~user3"))
 }
 
+// Test 353 / RPL_NAMREPLY with multi-prefix and userhost-in-names
+func Test353Extended(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.isupport.parse([]string{"PREFIX=(Yov)!@+"})
+
+	s.st.EXPECT().GetChannel("#test1").Return(&state.Channel{Name: "#test1"})
+	gomock.InOrder(
+		// user1 is new, and has several privileges in PREFIX order
+		s.st.EXPECT().GetNick("user1").Return(nil),
+		s.st.EXPECT().NewNick("user1").Return(&state.Nick{Nick: "user1"}),
+		s.st.EXPECT().NickInfo("user1", "ident1", "host1.com", ""),
+		s.st.EXPECT().IsOn("#test1", "user1").Return(nil, false),
+		s.st.EXPECT().Associate("#test1", "user1").Return(&state.ChanPrivs{}),
+		s.st.EXPECT().ChannelModes("#test1", "+Y", "user1"),
+		s.st.EXPECT().ChannelModes("#test1", "+o", "user1"),
+		s.st.EXPECT().ChannelModes("#test1", "+v", "user1"),
+		// user2 is known, but we don't know their host yet
+		s.st.EXPECT().GetNick("user2").Return(&state.Nick{Nick: "user2", Name: "name"}),
+		s.st.EXPECT().NickInfo("user2", "ident2", "host2.com", "name"),
+		s.st.EXPECT().IsOn("#test1", "user2").Return(&state.ChanPrivs{}, true),
+		// user3 is known with a host, which isn't overwritten, and
+		// "%" isn't a prefix on this server
+		s.st.EXPECT().GetNick("%user3").Return(&state.Nick{Nick: "%user3", Host: "host3.com"}),
+		s.st.EXPECT().IsOn("#test1", "%user3").Return(&state.ChanPrivs{}, true),
+	)
+	c.h_353(ParseLine(":irc.server.org 353 test = #test1 :!@+user1!ident1@host1.com user2!ident2@host2.com %user3!ident3@other.com"))
+}
+
 // Test the handler for 671 (unreal specific)
 func Test671(t *testing.T) {
 	c, s := setUp(t)
@@ -465,18 +777,18 @@ func TestCap(t *testing.T) {
 	c.Config().Capabilites = []string{"cap1", "cap2", "cap3", "cap4"}
 
 	c.h_REGISTER(&Line{Cmd: REGISTER})
-	s.nc.Expect("CAP LS")
+	s.nc.Expect("CAP LS 302")
 	s.nc.Expect("NICK test")
 	s.nc.Expect("USER test 12 * :Testing IRC")
 
 	// Ensure that capabilities not supported by the server are not requested
-	s.nc.Send("CAP * LS :cap2 cap4")
-	s.nc.Expect("CAP REQ :cap2 cap4")
+	s.nc.Send("CAP * LS :cap2 cap4 server-time")
+	s.nc.Expect("CAP REQ :cap2 cap4 server-time")
 
-	s.nc.Send("CAP * ACK :cap2 cap4")
+	s.nc.Send("CAP * ACK :cap2 cap4 server-time")
 	s.nc.Expect("CAP END")
 
-	for _, cap := range []string{"cap2", "cap4"} {
+	for _, cap := range []string{"cap2", "cap4", "server-time"} {
 		if !c.SupportsCapability(cap) {
 			t.Fail()
 		}
@@ -497,7 +809,7 @@ func TestCap(t *testing.T) {
 	s.nc.Expect("CAP REQ :-cap4")
 
 	s.nc.Send("CAP * ACK :-cap4")
-	s.nc.Expect("CAP END")
+	s.nc.ExpectNothing()
 
 	if !c.HasCapability("cap2") {
 		t.Fail()
@@ -507,3 +819,110 @@ func TestCap(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestCap302(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	c.Config().EnableCapabilityNegotiation = true
+	c.Config().Capabilites = []string{"cap1"}
+	events := make(chan *Line, 10)
+	c.HandleFunc(CAPENABLED, func(_ *Conn, l *Line) { events <- l.Copy() })
+	c.HandleFunc(CAPDISABLED, func(_ *Conn, l *Line) { events <- l.Copy() })
+	expectEvent := func(cmd string, caps ...string) {
+		t.Helper()
+		select {
+		case l := <-events:
+			if l.Cmd != cmd || !reflect.DeepEqual(l.Args, caps) {
+				t.Errorf("Expected %s %q, got %s %q", cmd, caps, l.Cmd, l.Args)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("Timed out waiting for %s event.", cmd)
+		}
+	}
+
+	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
+	s.nc.Expect("NICK test")
+	s.nc.Expect("USER test 12 * :Testing IRC")
+
+	// Caps are only requested once the server has listed all of them.
+	s.nc.Send("CAP * LS * :cap1 sasl=PLAIN,EXTERNAL unknown")
+	s.nc.ExpectNothing()
+	s.nc.Send("CAP * LS :cap-notify server-time draft/multiline=max-bytes=4096")
+	s.nc.Expect("CAP REQ :cap-notify cap1 draft/multiline server-time")
+
+	if !c.SupportsCapability("sasl") || c.CapabilityValue("sasl") != "PLAIN,EXTERNAL" ||
+		c.CapabilityValue("draft/multiline") != "max-bytes=4096" || c.CapabilityValue("cap1") != "" {
+		t.Errorf("Capability values not stored correctly.")
+	}
+
+	s.nc.Send("CAP * ACK :cap-notify cap1 draft/multiline server-time")
+	s.nc.Expect("CAP END")
+	expectEvent(CAPENABLED, "cap-notify", "cap1", "draft/multiline", "server-time")
+
+	// New caps we want are requested, others are not.
+	s.nc.Send("CAP test NEW :batch unknown2=1")
+	s.nc.Expect("CAP REQ :batch")
+	if !c.SupportsCapability("unknown2") || c.HasCapability("unknown2") {
+		t.Errorf("New capabilities not tracked correctly.")
+	}
+	// Registration is over, so there's no CAP END this time.
+	s.nc.Send("CAP test ACK :batch")
+	s.nc.ExpectNothing()
+	expectEvent(CAPENABLED, "batch")
+
+	// Caps that go away are removed.
+	s.nc.Send("CAP test DEL :batch unknown2")
+	expectEvent(CAPDISABLED, "batch")
+	if c.HasCapability("batch") || c.SupportsCapability("batch") || c.SupportsCapability("unknown2") {
+		t.Errorf("Deleted capabilities not removed.")
+	}
+	s.nc.ExpectNothing()
+
+	// And caps can be disabled explicitly.
+	s.nc.Send("CAP test ACK :-cap1")
+	s.nc.ExpectNothing()
+	expectEvent(CAPDISABLED, "cap1")
+	if c.HasCapability("cap1") || !c.HasCapability("server-time") {
+		t.Errorf("Capability not disabled correctly.")
+	}
+}
+
+func TestReadOnlyHandlers(t *testing.T) {
+	for n := range readOnlyIntHandlers {
+		if _, ok := intHandlers[n]; !ok {
+			t.Errorf("Read-only internal handler %q does not exist.", n)
+		}
+	}
+	for n := range readOnlySTHandlers {
+		if _, ok := stHandlers[n]; !ok {
+			t.Errorf("Read-only state handler %q does not exist.", n)
+		}
+	}
+	// CTCP responders may keep the line, so h_CTCP gets a copy.
+	if readOnlyIntHandlers[CTCP] {
+		t.Errorf("h_CTCP should not be read-only.")
+	}
+}
+
+func TestStateCaps(t *testing.T) {
+	c := SimpleClient("test")
+	// These change JOIN and NAMES replies, so need state tracking.
+	for _, cap := range []string{extendedJoinCap, multiPrefixCap, userhostInNamesCap} {
+		if !slices.Contains(stateCaps, cap) {
+			t.Errorf("%s is not a state cap.", cap)
+		}
+	}
+	for _, cap := range stateCaps {
+		if c.getRequestCapabilities().Has(cap) {
+			t.Errorf("%s requested without state tracking.", cap)
+		}
+	}
+	c.EnableStateTracking()
+	for _, cap := range stateCaps {
+		if !c.getRequestCapabilities().Has(cap) {
+			t.Errorf("%s not requested with state tracking.", cap)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/fluffle/goirc/state"
+)
+
+// ErrBadHostmask is returned when a hostmask can't be parsed.
+var ErrBadHostmask = errors.New("bad hostmask")
+
+// A Hostmask identifies the source of a message as nick!ident@host. As a
+// mask, each part may contain * and ? wildcards, and the host may be an IP
+// address range in CIDR notation, e.g. *!*@192.0.2.0/24.
+type Hostmask struct {
+	Nick, Ident, Host string
+}
+
+// ParseHostmask parses and validates a full nick!ident@host hostmask.
+func ParseHostmask(s string) (Hostmask, error) {
+	nick, rest, ok := strings.Cut(s, "!")
+	if !ok {
+		return Hostmask{}, fmt.Errorf("%w: %q has no '!'", ErrBadHostmask, s)
+	}
+	ident, host, ok := strings.Cut(rest, "@")
+	if !ok {
+		return Hostmask{}, fmt.Errorf("%w: %q has no '@'", ErrBadHostmask, s)
+	}
+	h := Hostmask{Nick: nick, Ident: ident, Host: host}
+	if err := h.Validate(); err != nil {
+		return Hostmask{}, err
+	}
+	return h, nil
+}
+
+// NewMask creates a Hostmask from a possibly partial mask, filling in the
+// missing parts with wildcards. So "nick" becomes "nick!*@*", "nick!ident"
+// becomes "nick!ident@*" and "ident@host" becomes "*!ident@host". It does
+// not validate the mask.
+func NewMask(s string) Hostmask {
+	s = strings.TrimSpace(s)
+	nidx, uidx := strings.Index(s, "!"), strings.Index(s, "@")
+	switch {
+	case nidx == -1 && uidx == -1:
+		return Hostmask{Nick: s, Ident: "*", Host: "*"}
+	case uidx == -1:
+		return Hostmask{Nick: s[:nidx], Ident: s[nidx+1:], Host: "*"}
+	case nidx == -1 || nidx > uidx:
+		return Hostmask{Nick: "*", Ident: s[:uidx], Host: s[uidx+1:]}
+	}
+	return Hostmask{Nick: s[:nidx], Ident: s[nidx+1 : uidx], Host: s[uidx+1:]}
+}
+
+// Hostmask returns the hostmask of the line's source. The Nick and Ident
+// are empty for lines from servers.
+func (line *Line) Hostmask() Hostmask {
+	return Hostmask{Nick: line.Nick, Ident: line.Ident, Host: line.Host}
+}
+
+// String returns the hostmask as nick!ident@host.
+func (h Hostmask) String() string {
+	return h.Nick + "!" + h.Ident + "@" + h.Host
+}
+
+// Validate returns an error if any part of the hostmask is empty or
+// contains characters that are not allowed there.
+func (h Hostmask) Validate() error {
+	for _, p := range []struct{ name, val string }{
+		{"nick", h.Nick}, {"ident", h.Ident}, {"host", h.Host},
+	} {
+		if p.val == "" {
+			return fmt.Errorf("%w: empty %s", ErrBadHostmask, p.name)
+		}
+		if strings.ContainsAny(p.val, " \x00\r\n!@") {
+			return fmt.Errorf("%w: bad character in %s %q", ErrBadHostmask, p.name, p.val)
+		}
+	}
+	return nil
+}
+
+// IsWildcard returns true if the hostmask contains wildcards or a CIDR
+// range, i.e. it can match more than one source.
+func (h Hostmask) IsWildcard() bool {
+	return strings.ContainsAny(h.String(), "*?") || h.cidr() != nil
+}
+
+// cidr returns the IP network that the mask's host matches, if any.
+func (h Hostmask) cidr() *net.IPNet {
+	if !strings.Contains(h.Host, "/") {
+		return nil
+	}
+	_, ipnet, err := net.ParseCIDR(h.Host)
+	if err != nil {
+		return nil
+	}
+	return ipnet
+}
+
+// Match returns true if target matches the mask h, using the default
+// RFC1459 casemapping.
+func (h Hostmask) Match(target Hostmask) bool {
+	return h.MatchCase(target, state.RFC1459)
+}
+
+// MatchCase returns true if target matches the mask h. Nicks, idents and
+// hosts are compared case-insensitively according to cm. If the mask's
+// host is a CIDR range, target matches if its host is an IP address in
+// that range.
+func (h Hostmask) MatchCase(target Hostmask, cm state.CaseMapping) bool {
+	if !wildcardMatch(cm.Fold(h.Nick), cm.Fold(target.Nick)) ||
+		!wildcardMatch(cm.Fold(h.Ident), cm.Fold(target.Ident)) {
+		return false
+	}
+	if ipnet := h.cidr(); ipnet != nil {
+		ip := net.ParseIP(target.Host)
+		return ip != nil && ipnet.Contains(ip)
+	}
+	return wildcardMatch(cm.Fold(h.Host), cm.Fold(target.Host))
+}
+
+// MatchString parses s as a hostmask and returns true if it matches h.
+func (h Hostmask) MatchString(s string) bool {
+	target, err := ParseHostmask(s)
+	return err == nil && h.Match(target)
+}
+
+// MatchLine returns true if the source of line matches h. Lines from
+// servers never match.
+func (h Hostmask) MatchLine(line *Line) bool {
+	return line.Nick != "" && h.Match(line.Hostmask())
+}
+
+// MatchNick returns true if the nick's hostmask matches h.
+func (h Hostmask) MatchNick(n *state.Nick) bool {
+	return n != nil && h.Match(Hostmask{Nick: n.Nick, Ident: n.Ident, Host: n.Host})
+}
+
+// BanMask generates a mask matching h, of one of the types used by mIRC
+// and other clients:
+//
+//	0: *!ident@host
+//	1: *!*ident@host
+//	2: *!*@host
+//	3: *!*ident@*.host
+//	4: *!*@*.host
+//	5: nick!ident@host
+//	6: nick!*ident@host
+//	7: nick!*@host
+//	8: nick!*ident@*.host
+//	9: nick!*@*.host
+//
+// where *ident is the ident with any leading ~ replaced by *, and *.host
+// replaces the most specific part of the host with a wildcard: the first
+// label of a hostname, or the last part of an IP address. Hosts that look
+// like network cloaks, e.g. user/example, are never wildcarded. Types out
+// of range are treated as type 2.
+func (h Hostmask) BanMask(typ int) Hostmask {
+	if typ < 0 || typ > 9 {
+		typ = 2
+	}
+	m := Hostmask{Nick: "*", Ident: h.Ident, Host: h.Host}
+	if typ >= 5 {
+		m.Nick = h.Nick
+	}
+	switch typ % 5 {
+	case 1, 3:
+		m.Ident = "*" + strings.TrimLeft(h.Ident, "~")
+	case 2, 4:
+		m.Ident = "*"
+	}
+	if typ%5 >= 3 {
+		m.Host = wildHost(h.Host)
+	}
+	return m
+}
+
+// wildHost replaces the most specific part of host with a wildcard.
+func wildHost(host string) string {
+	if strings.Contains(host, "/") {
+		return host
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		sep := "."
+		if ip.To4() == nil {
+			sep = ":"
+		}
+		if idx := strings.LastIndex(host, sep); idx != -1 {
+			return host[:idx+1] + "*"
+		}
+		return host
+	}
+	if strings.Count(host, ".") < 2 {
+		// Don't turn example.com into *.com.
+		return host
+	}
+	return "*" + host[strings.Index(host, "."):]
+}
+
+// wildcardMatch returns true if s matches the glob-style pattern, where
+// '*' matches any sequence of characters and '?' matches any single
+// character. Matching is ASCII case-insensitive.
+func wildcardMatch(pattern, s string) bool {
+	pattern, s = strings.ToLower(pattern), strings.ToLower(s)
+	// Classic backtracking glob match; star/match record the position of
+	// the last '*' in pattern and the corresponding position in s.
+	p, i, star, match := 0, 0, -1, 0
+	for i < len(s) {
+		switch {
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == s[i]):
+			p++
+			i++
+		case p < len(pattern) && pattern[p] == '*':
+			star, match = p, i
+			p++
+		case star != -1:
+			p = star + 1
+			match++
+			i = match
+		default:
+			return false
+		}
+	}
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
+	}
+	return p == len(pattern)
+}
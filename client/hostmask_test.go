@@ -0,0 +1,174 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/fluffle/goirc/state"
+)
+
+func TestWildcardMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		match      bool
+	}{
+		{"", "", true},
+		{"*", "", true},
+		{"*", "anything!at@all", true},
+		{"nick!*@*", "nick!ident@host.com", true},
+		{"nick!*@*", "NICK!ident@host.com", true},
+		{"nick!*@*", "nick2!ident@host.com", false},
+		{"*!*@*.example.com", "a!b@c.example.com", true},
+		{"*!*@*.example.com", "a!b@example.com", false},
+		{"n?ck!*@*", "nick!i@h", true},
+		{"n?ck!*@*", "nck!i@h", false},
+		{"*a*b*c", "xaxxbxxc", true},
+		{"*a*b*c", "xaxxbxxcx", false},
+	}
+	for i, test := range tests {
+		if m := wildcardMatch(test.pattern, test.s); m != test.match {
+			t.Errorf("%d: wildcardMatch(%q, %q) = %t, want %t",
+				i, test.pattern, test.s, m, test.match)
+		}
+	}
+}
+
+func TestParseHostmask(t *testing.T) {
+	h, err := ParseHostmask("nick!~ident@host.example.com")
+	if err != nil || h != (Hostmask{"nick", "~ident", "host.example.com"}) {
+		t.Errorf("ParseHostmask returned %#v, %v", h, err)
+	}
+	if h.String() != "nick!~ident@host.example.com" {
+		t.Errorf("String() = %q", h.String())
+	}
+	bad := []string{
+		"", "nick", "nick!ident", "ident@host", "nick@host!ident",
+		"!ident@host", "nick!@host", "nick!ident@", "ni ck!ident@host",
+		"nick!ident@host@host", "nick!id!ent@host",
+	}
+	for _, s := range bad {
+		if h, err := ParseHostmask(s); !errors.Is(err, ErrBadHostmask) {
+			t.Errorf("ParseHostmask(%q) = %#v, %v; want ErrBadHostmask", s, h, err)
+		}
+	}
+}
+
+func TestNewMask(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"nick", "nick!*@*"},
+		{"nick!ident", "nick!ident@*"},
+		{"ident@host", "*!ident@host"},
+		{"*!*@192.0.2.0/24", "*!*@192.0.2.0/24"},
+		{" *!*@host ", "*!*@host"},
+	}
+	for i, test := range tests {
+		if out := NewMask(test.in).String(); out != test.out {
+			t.Errorf("%d: NewMask(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+	}
+}
+
+func TestHostmaskMatch(t *testing.T) {
+	tests := []struct {
+		mask, target string
+		match        bool
+	}{
+		{"*!*@*", "nick!ident@host", true},
+		{"*!*@*.example.com", "nick!ident@a.example.com", true},
+		{"*!*@*.example.com", "nick!ident@example.com", false},
+		{"NICK!*@*", "nick!ident@host", true},
+		// RFC1459 casemapping treats [] as {}.
+		{"n[ck]!*@*", "N{CK}!ident@host", true},
+		{"n?ck!*i*@*", "nick!~ident@host", true},
+		{"*!*@192.0.2.0/24", "nick!ident@192.0.2.55", true},
+		{"*!*@192.0.2.0/24", "nick!ident@192.0.3.55", false},
+		{"*!*@192.0.2.0/24", "nick!ident@host.example.com", false},
+		{"*!*@2001:db8::/32", "nick!ident@2001:db8::1", true},
+		{"*!*@2001:db8::/32", "nick!ident@2001:db9::1", false},
+		// Cloaks aren't CIDR ranges.
+		{"*!*@user/*", "nick!ident@user/nick", true},
+		{"*!*@user/*", "nick!ident@user", false},
+	}
+	for i, test := range tests {
+		if m := NewMask(test.mask).MatchString(test.target); m != test.match {
+			t.Errorf("%d: %q matching %q = %t, want %t", i, test.mask, test.target, m, test.match)
+		}
+	}
+
+	// ASCII casemapping only folds letters.
+	mask, target := NewMask("n[ck]"), Hostmask{"N{CK}", "ident", "host"}
+	if mask.MatchCase(target, state.ASCII) || !mask.MatchCase(target, state.RFC1459) {
+		t.Errorf("MatchCase not casemapping-aware.")
+	}
+
+	l := ParseLine(":nick!ident@192.0.2.1 PRIVMSG #chan :hi")
+	if !NewMask("*!*@192.0.2.0/30").MatchLine(l) || NewMask("other").MatchLine(l) {
+		t.Errorf("MatchLine failed.")
+	}
+	if NewMask("*").MatchLine(ParseLine(":irc.server.org NOTICE * :hi")) {
+		t.Errorf("MatchLine matched server line.")
+	}
+	n := &state.Nick{Nick: "nick", Ident: "ident", Host: "host.example.com"}
+	if !NewMask("*!ident@*.example.com").MatchNick(n) || NewMask("*").MatchNick(nil) {
+		t.Errorf("MatchNick failed.")
+	}
+}
+
+func TestHostmaskIsWildcard(t *testing.T) {
+	tests := []struct {
+		mask string
+		wild bool
+	}{
+		{"nick!ident@host", false},
+		{"nick!ident@host/cloak", false},
+		{"n?ck!ident@host", true},
+		{"nick!*@host", true},
+		{"nick!ident@10.0.0.0/8", true},
+	}
+	for i, test := range tests {
+		if w := NewMask(test.mask).IsWildcard(); w != test.wild {
+			t.Errorf("%d: IsWildcard(%q) = %t", i, test.mask, w)
+		}
+	}
+}
+
+func TestBanMask(t *testing.T) {
+	h := Hostmask{"nick", "~ident", "a.b.example.com"}
+	want := []string{
+		"*!~ident@a.b.example.com",
+		"*!*ident@a.b.example.com",
+		"*!*@a.b.example.com",
+		"*!*ident@*.b.example.com",
+		"*!*@*.b.example.com",
+		"nick!~ident@a.b.example.com",
+		"nick!*ident@a.b.example.com",
+		"nick!*@a.b.example.com",
+		"nick!*ident@*.b.example.com",
+		"nick!*@*.b.example.com",
+	}
+	for typ, w := range want {
+		m := h.BanMask(typ)
+		if m.String() != w {
+			t.Errorf("BanMask(%d) = %q, want %q", typ, m, w)
+		}
+		if !m.Match(h) {
+			t.Errorf("BanMask(%d) doesn't match its source.", typ)
+		}
+	}
+	if m := h.BanMask(42).String(); m != want[2] {
+		t.Errorf("BanMask(42) = %q, want %q", m, want[2])
+	}
+
+	hosts := []struct{ in, out string }{
+		{"192.0.2.1", "192.0.2.*"},
+		{"2001:db8::1", "2001:db8::*"},
+		{"example.com", "example.com"},
+		{"user/nick", "user/nick"},
+	}
+	for _, test := range hosts {
+		h.Host = test.in
+		if m := h.BanMask(4); m.Host != test.out || !m.Match(h) {
+			t.Errorf("BanMask(4) for host %q = %q, want %q", test.in, m.Host, test.out)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package client
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/state"
+)
+
+// The ignore list allows lines from abusive users to be silently dropped
+// before they reach any user-supplied handlers. Ignored lines are still
+// passed to the internal and state tracking handlers, so the state tracker
+// stays consistent with the server's view of the world.
+
+// IgnoreEntry describes a single entry in the ignore list.
+type IgnoreEntry struct {
+	// A nick!ident@host mask which may contain * and ? wildcards.
+	Mask string
+	// The entry is removed from the ignore list after this time.
+	// A zero Expires means the entry never expires.
+	Expires time.Time
+	// Lowercased names of the events that are ignored, e.g. "privmsg".
+	// If empty, all events from matching sources are ignored.
+	Events []string
+
+	mask Hostmask
+}
+
+func (ie *IgnoreEntry) expired(now time.Time) bool {
+	return !ie.Expires.IsZero() && now.After(ie.Expires)
+}
+
+func (ie *IgnoreEntry) matches(src Hostmask, ev string, cm state.CaseMapping) bool {
+	if len(ie.Events) > 0 {
+		found := false
+		for _, e := range ie.Events {
+			if e == ev {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return ie.mask.MatchCase(src, cm)
+}
+
+type ignoreList struct {
+	entries map[string]*IgnoreEntry
+	mu      sync.Mutex
+}
+
+func newIgnoreList() *ignoreList {
+	return &ignoreList{entries: make(map[string]*IgnoreEntry)}
+}
+
+// ignored returns true if the line came from a source that matches an
+// unexpired entry in the ignore list for the line's event. Nicks are
+// compared using the casemapping cm.
+func (il *ignoreList) ignored(line *Line, cm state.CaseMapping) bool {
+	if line.Nick == "" {
+		// Lines from servers or generated internally can't be ignored.
+		return false
+	}
+	src := line.Hostmask()
+	ev := strings.ToLower(line.Cmd)
+	now := time.Now()
+	il.mu.Lock()
+	defer il.mu.Unlock()
+	for mask, ie := range il.entries {
+		if ie.expired(now) {
+			delete(il.entries, mask)
+			continue
+		}
+		if ie.matches(src, ev, cm) {
+			return true
+		}
+	}
+	return false
+}
+
+// normaliseMask fills in missing parts of a partial mask, see NewMask.
+func normaliseMask(mask string) string {
+	return NewMask(mask).String()
+}
+
+// Ignore adds a nick!ident@host mask to the client's ignore list. Lines
+// from matching sources will not be passed to any foreground or background
+// handlers, though the client's internal handlers still see them. Masks may
+// contain * and ? wildcards or a CIDR range as the host, and partial masks
+// such as "nick" or "*@host.com" are expanded to a full nick!ident@host
+// form. See Hostmask for details. If expiry is
+// non-zero the entry is removed after that long. If any events are given,
+// only those events are ignored, e.g.
+//
+//	conn.Ignore("*!*@spammer.com", time.Hour, PRIVMSG, NOTICE, CTCP, ACTION)
+//
+// Adding an existing mask again replaces its expiry and events.
+func (conn *Conn) Ignore(mask string, expiry time.Duration, events ...string) {
+	m := NewMask(mask)
+	ie := &IgnoreEntry{Mask: m.String(), mask: m}
+	if expiry > 0 {
+		ie.Expires = time.Now().Add(expiry)
+	}
+	for _, ev := range events {
+		ie.Events = append(ie.Events, strings.ToLower(ev))
+	}
+	conn.ignores.mu.Lock()
+	defer conn.ignores.mu.Unlock()
+	conn.ignores.entries[ie.Mask] = ie
+}
+
+// Unignore removes a mask from the client's ignore list. It returns false
+// if the mask was not in the list.
+func (conn *Conn) Unignore(mask string) bool {
+	mask = normaliseMask(mask)
+	conn.ignores.mu.Lock()
+	defer conn.ignores.mu.Unlock()
+	_, ok := conn.ignores.entries[mask]
+	delete(conn.ignores.entries, mask)
+	return ok
+}
+
+// IgnoreList returns a copy of the unexpired entries in the client's
+// ignore list, sorted by mask.
+func (conn *Conn) IgnoreList() []IgnoreEntry {
+	now := time.Now()
+	conn.ignores.mu.Lock()
+	defer conn.ignores.mu.Unlock()
+	list := make([]IgnoreEntry, 0, len(conn.ignores.entries))
+	for _, ie := range conn.ignores.entries {
+		if !ie.expired(now) {
+			e := *ie
+			e.Events = append([]string(nil), ie.Events...)
+			list = append(list, e)
+		}
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Mask < list[j].Mask })
+	return list
+}
+
+// Ignored returns true if the line would be dropped by the ignore list.
+func (conn *Conn) Ignored(line *Line) bool {
+	return conn.ignores.ignored(line, conn.CaseMapping())
+}
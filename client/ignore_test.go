@@ -0,0 +1,76 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNormaliseMask(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"nick", "nick!*@*"},
+		{"nick!ident", "nick!ident@*"},
+		{"ident@host", "*!ident@host"},
+		{" *!*@host ", "*!*@host"},
+	}
+	for i, test := range tests {
+		if out := normaliseMask(test.in); out != test.out {
+			t.Errorf("%d: normaliseMask(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+	}
+}
+
+func TestIgnore(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	internal, fg, bg := callCheck(t), callCheck(t), callCheck(t)
+	c.handle(PRIVMSG, internal)
+	c.Handle(PRIVMSG, fg)
+	c.HandleBG(PRIVMSG, bg)
+	c.Ignore("spammer", 0, PRIVMSG)
+	c.Ignore("*!*@*.flood.net", time.Hour)
+
+	// Ignored lines should still reach the internal handlers.
+	go c.dispatch(ParseLine(":spammer!ident@host.com PRIVMSG #chan :buy now"))
+	internal.assertWasCalled("Internal handler not called for ignored line.")
+	fg.assertNotCalled("Foreground handler called for ignored line.")
+	bg.assertNotCalled("Background handler called for ignored line.")
+
+	// The spammer is only ignored for PRIVMSG.
+	if c.Ignored(ParseLine(":spammer!ident@host.com JOIN #chan")) {
+		t.Errorf("JOIN ignored despite being out of scope.")
+	}
+	if !c.Ignored(ParseLine(":any!one@foo.flood.net JOIN #chan")) {
+		t.Errorf("JOIN not ignored for all-events mask.")
+	}
+	if c.Ignored(ParseLine(":irc.flood.net NOTICE * :server notice")) {
+		t.Errorf("Server line ignored.")
+	}
+
+	list := c.IgnoreList()
+	if len(list) != 2 || list[0].Mask != "*!*@*.flood.net" ||
+		list[0].Expires.IsZero() || len(list[0].Events) != 0 ||
+		list[1].Mask != "spammer!*@*" || !list[1].Expires.IsZero() ||
+		len(list[1].Events) != 1 || list[1].Events[0] != "privmsg" {
+		t.Errorf("Unexpected ignore list: %#v", list)
+	}
+
+	// Unignoring should let lines through again.
+	if !c.Unignore("spammer") || c.Unignore("spammer") {
+		t.Errorf("Unignore didn't remove mask exactly once.")
+	}
+	go c.dispatch(ParseLine(":spammer!ident@host.com PRIVMSG #chan :buy now"))
+	internal.assertWasCalled("Internal handler not called after unignore.")
+	fg.assertWasCalled("Foreground handler not called after unignore.")
+	bg.assertWasCalled("Background handler not called after unignore.")
+
+	// Expired entries are removed.
+	c.Ignore("*!*@*.flood.net", time.Nanosecond)
+	<-time.After(time.Millisecond)
+	if c.Ignored(ParseLine(":any!one@foo.flood.net JOIN #chan")) {
+		t.Errorf("Expired ignore entry still matches.")
+	}
+	if len(c.IgnoreList()) != 0 {
+		t.Errorf("Expired ignore entry still listed.")
+	}
+}
@@ -0,0 +1,269 @@
+package client
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fluffle/goirc/state"
+)
+
+// Defaults for ISUPPORT tokens that the server hasn't sent.
+const (
+	// Accept all the RFC channel types unless told otherwise.
+	defaultChanTypes = "#&+!"
+	defaultPrefix    = "(ov)@+"
+	defaultChanModes = "b,k,l,imnpst"
+	defaultNickLen   = 9
+	defaultModes     = 3
+)
+
+// ISupport contains the features advertised by the server in RPL_ISUPPORT
+// (numeric 005) lines. Accessors return sensible defaults for tokens that
+// the server hasn't sent. It is safe for concurrent use, and the methods
+// may also be called on a nil *ISupport, which returns the defaults.
+type ISupport struct {
+	mu     sync.RWMutex
+	tokens map[string]string
+}
+
+func newISupport() *ISupport {
+	return &ISupport{tokens: make(map[string]string)}
+}
+
+// reset forgets all tokens, ready for a new connection.
+func (is *ISupport) reset() {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	is.tokens = make(map[string]string)
+}
+
+// parse adds the tokens from the parameters of an RPL_ISUPPORT line.
+// Tokens prefixed with '-' are removed.
+func (is *ISupport) parse(tokens []string) {
+	is.mu.Lock()
+	defer is.mu.Unlock()
+	for _, tok := range tokens {
+		if strings.HasPrefix(tok, "-") {
+			delete(is.tokens, tok[1:])
+			continue
+		}
+		name, value, _ := strings.Cut(tok, "=")
+		if name != "" {
+			is.tokens[name] = unescapeISupport(value)
+		}
+	}
+}
+
+// unescapeISupport decodes the \xHH escapes allowed in token values.
+func unescapeISupport(s string) string {
+	if !strings.Contains(s, "\\x") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+3 < len(s) && s[i+1] == 'x' {
+			if c, err := strconv.ParseUint(s[i+2:i+4], 16, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// Get returns the raw value of a token, and whether the server sent it.
+// Tokens without a value return "".
+func (is *ISupport) Get(token string) (string, bool) {
+	if is == nil {
+		return "", false
+	}
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	v, ok := is.tokens[token]
+	return v, ok
+}
+
+// Has returns true if the server sent the token.
+func (is *ISupport) Has(token string) bool {
+	_, ok := is.Get(token)
+	return ok
+}
+
+// Tokens returns a copy of all the tokens the server has sent.
+func (is *ISupport) Tokens() map[string]string {
+	if is == nil {
+		return map[string]string{}
+	}
+	is.mu.RLock()
+	defer is.mu.RUnlock()
+	m := make(map[string]string, len(is.tokens))
+	for k, v := range is.tokens {
+		m[k] = v
+	}
+	return m
+}
+
+// String returns the tokens as they would appear in RPL_ISUPPORT.
+func (is *ISupport) String() string {
+	var toks []string
+	for k, v := range is.Tokens() {
+		if v != "" {
+			k += "=" + v
+		}
+		toks = append(toks, k)
+	}
+	sort.Strings(toks)
+	return strings.Join(toks, " ")
+}
+
+// getDefault returns the value of a token, or def if the server didn't
+// send it.
+func (is *ISupport) getDefault(token, def string) string {
+	if v, ok := is.Get(token); ok {
+		return v
+	}
+	return def
+}
+
+// getInt returns the integer value of a token, 0 if it has no value or
+// def if the server didn't send it.
+func (is *ISupport) getInt(token string, def int) int {
+	v, ok := is.Get(token)
+	if !ok {
+		return def
+	}
+	n, _ := strconv.Atoi(v)
+	return n
+}
+
+// getMode returns the mode character for a token such as EXCEPTS, which
+// defaults to def if the token has no value, or 0 if the server didn't
+// send it.
+func (is *ISupport) getMode(token string, def byte) byte {
+	v, ok := is.Get(token)
+	switch {
+	case !ok:
+		return 0
+	case v == "":
+		return def
+	}
+	return v[0]
+}
+
+// Prefix returns the channel modes that give users privileges, and the
+// symbols used to show those privileges in NAMES and WHO replies, in
+// order of rank. The defaults are "ov" and "@+".
+func (is *ISupport) Prefix() (modes, symbols string) {
+	v := is.getDefault("PREFIX", defaultPrefix)
+	if !strings.HasPrefix(v, "(") {
+		return "", ""
+	}
+	modes, symbols, _ = strings.Cut(v[1:], ")")
+	if len(modes) != len(symbols) {
+		return "", ""
+	}
+	return modes, symbols
+}
+
+// ChanModes returns the four types of channel mode listed in CHANMODES:
+// list modes like +b that always take a parameter, modes like +k that
+// always take a parameter, modes like +l that only take a parameter when
+// set, and modes like +n that never take a parameter.
+func (is *ISupport) ChanModes() (list, param, setParam, noParam string) {
+	types := strings.SplitN(is.getDefault("CHANMODES", defaultChanModes), ",", 5)
+	for len(types) < 4 {
+		types = append(types, "")
+	}
+	return types[0], types[1], types[2], types[3]
+}
+
+// ChanTypes returns the characters that channel names can start with.
+// By default, all the RFC channel types "#&+!" are accepted.
+func (is *ISupport) ChanTypes() string {
+	return is.getDefault("CHANTYPES", defaultChanTypes)
+}
+
+// IsChannel returns true if name starts with one of the ChanTypes.
+func (is *ISupport) IsChannel(name string) bool {
+	return name != "" && strings.IndexByte(is.ChanTypes(), name[0]) != -1
+}
+
+// StatusMsg returns the prefixes that can be used to send a message to
+// only the users on a channel with those privileges, e.g. "@#chan".
+func (is *ISupport) StatusMsg() string {
+	return is.getDefault("STATUSMSG", "")
+}
+
+// NickLen returns the maximum length of a nick. The default is 9.
+func (is *ISupport) NickLen() int {
+	return is.getInt("NICKLEN", defaultNickLen)
+}
+
+// TopicLen returns the maximum length of a channel topic, or 0 if there
+// is no limit.
+func (is *ISupport) TopicLen() int {
+	return is.getInt("TOPICLEN", 0)
+}
+
+// Modes returns the maximum number of channel modes with parameters that
+// can be changed by a single MODE command, or 0 if there is no limit.
+// The default is 3.
+func (is *ISupport) Modes() int {
+	return is.getInt("MODES", defaultModes)
+}
+
+// TargMax returns the maximum number of targets for cmd, and whether the
+// server sent a limit for it at all. A maximum of 0 means no limit.
+func (is *ISupport) TargMax(cmd string) (int, bool) {
+	v, _ := is.Get("TARGMAX")
+	for _, lim := range strings.Split(v, ",") {
+		c, n, ok := strings.Cut(lim, ":")
+		if ok && strings.EqualFold(c, cmd) {
+			max, _ := strconv.Atoi(n)
+			return max, true
+		}
+	}
+	return 0, false
+}
+
+// MaxList returns the maximum number of entries in the list for the list
+// mode, e.g. 'b' for bans, or 0 if it is not known.
+func (is *ISupport) MaxList(mode byte) int {
+	v, _ := is.Get("MAXLIST")
+	for _, lim := range strings.Split(v, ",") {
+		modes, n, ok := strings.Cut(lim, ":")
+		if ok && strings.IndexByte(modes, mode) != -1 {
+			max, _ := strconv.Atoi(n)
+			return max
+		}
+	}
+	return 0
+}
+
+// Network returns the name of the IRC network, if the server sent one.
+func (is *ISupport) Network() string {
+	return is.getDefault("NETWORK", "")
+}
+
+// CaseMapping returns the casemapping used to compare nicks and channel
+// names. The default is rfc1459.
+func (is *ISupport) CaseMapping() state.CaseMapping {
+	cm, _ := state.ParseCaseMapping(is.getDefault("CASEMAPPING", ""))
+	return cm
+}
+
+// Excepts returns the channel mode used for ban exceptions, usually 'e',
+// or 0 if the server doesn't support them.
+func (is *ISupport) Excepts() byte {
+	return is.getMode("EXCEPTS", 'e')
+}
+
+// Invex returns the channel mode used for invite exceptions, usually
+// 'I', or 0 if the server doesn't support them.
+func (is *ISupport) Invex() byte {
+	return is.getMode("INVEX", 'I')
+}
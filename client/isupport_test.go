@@ -0,0 +1,131 @@
+package client
+
+import (
+	"testing"
+
+	"github.com/fluffle/goirc/state"
+)
+
+func TestISupportDefaults(t *testing.T) {
+	for _, is := range []*ISupport{nil, newISupport()} {
+		if m, s := is.Prefix(); m != "ov" || s != "@+" {
+			t.Errorf("Default prefix = %q %q", m, s)
+		}
+		if a, b, c, d := is.ChanModes(); a != "b" || b != "k" || c != "l" || d != "imnpst" {
+			t.Errorf("Default chanmodes = %q %q %q %q", a, b, c, d)
+		}
+		if is.ChanTypes() != "#&+!" || is.StatusMsg() != "" {
+			t.Errorf("Default chantypes/statusmsg = %q %q", is.ChanTypes(), is.StatusMsg())
+		}
+		if is.NickLen() != 9 || is.TopicLen() != 0 || is.Modes() != 3 {
+			t.Errorf("Default lengths = %d %d %d", is.NickLen(), is.TopicLen(), is.Modes())
+		}
+		if _, ok := is.TargMax("PRIVMSG"); ok || is.MaxList('b') != 0 {
+			t.Errorf("Default targmax/maxlist not empty.")
+		}
+		if is.Network() != "" || is.CaseMapping() != state.RFC1459 ||
+			is.Excepts() != 0 || is.Invex() != 0 {
+			t.Errorf("Bad defaults for network/casemapping/excepts/invex.")
+		}
+		if len(is.Tokens()) != 0 || is.Has("PREFIX") {
+			t.Errorf("Default ISupport has tokens.")
+		}
+	}
+}
+
+func TestISupportParse(t *testing.T) {
+	is := newISupport()
+	is.parse([]string{
+		"PREFIX=(qaohv)~&@%+", "CHANMODES=beI,kfL,lj,psmntirRcOAQKVCuzNSMT",
+		"CHANTYPES=#", "NICKLEN=30", "TOPICLEN=307", "MODES=12",
+		"TARGMAX=PRIVMSG:4,NOTICE:4,JOIN:,KICK:1", "MAXLIST=bq:60,e:50,I:40",
+		"NETWORK=Example\\x20Net", "CASEMAPPING=ascii", "STATUSMSG=~&@%+",
+		"EXCEPTS", "INVEX=J", "WHOX", "EXTBAN=~,cqnr",
+	})
+
+	if m, s := is.Prefix(); m != "qaohv" || s != "~&@%+" {
+		t.Errorf("Prefix = %q %q", m, s)
+	}
+	if a, b, c, d := is.ChanModes(); a != "beI" || b != "kfL" || c != "lj" || d != "psmntirRcOAQKVCuzNSMT" {
+		t.Errorf("ChanModes = %q %q %q %q", a, b, c, d)
+	}
+	if is.ChanTypes() != "#" || is.StatusMsg() != "~&@%+" {
+		t.Errorf("ChanTypes/StatusMsg = %q %q", is.ChanTypes(), is.StatusMsg())
+	}
+	if is.NickLen() != 30 || is.TopicLen() != 307 || is.Modes() != 12 {
+		t.Errorf("Lengths = %d %d %d", is.NickLen(), is.TopicLen(), is.Modes())
+	}
+	targs := []struct {
+		cmd string
+		max int
+		ok  bool
+	}{{"PRIVMSG", 4, true}, {"kick", 1, true}, {"JOIN", 0, true}, {"PART", 0, false}}
+	for _, test := range targs {
+		if max, ok := is.TargMax(test.cmd); max != test.max || ok != test.ok {
+			t.Errorf("TargMax(%s) = %d, %t", test.cmd, max, ok)
+		}
+	}
+	if is.MaxList('b') != 60 || is.MaxList('q') != 60 || is.MaxList('I') != 40 || is.MaxList('x') != 0 {
+		t.Errorf("MaxList parsed incorrectly.")
+	}
+	if is.Network() != "Example Net" {
+		t.Errorf("Network = %q", is.Network())
+	}
+	if is.CaseMapping() != state.ASCII || is.Excepts() != 'e' || is.Invex() != 'J' {
+		t.Errorf("CaseMapping/Excepts/Invex parsed incorrectly.")
+	}
+	if v, ok := is.Get("EXTBAN"); !ok || v != "~,cqnr" {
+		t.Errorf("Unknown token EXTBAN = %q, %t", v, ok)
+	}
+	if v, ok := is.Get("WHOX"); !ok || v != "" {
+		t.Errorf("Unknown flag WHOX = %q, %t", v, ok)
+	}
+
+	// Tokens can be removed, reverting to the defaults.
+	is.parse([]string{"-CHANTYPES", "-EXCEPTS", "-WHOX", "-NOTSET"})
+	if is.ChanTypes() != "#&+!" || is.Excepts() != 0 || is.Has("WHOX") {
+		t.Errorf("Tokens not removed.")
+	}
+
+	is.reset()
+	if len(is.Tokens()) != 0 {
+		t.Errorf("reset didn't remove tokens.")
+	}
+}
+
+func TestISupportPublic(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	s.st.EXPECT().SetCaseMapping(state.ASCII)
+	c.h_005(ParseLine(":irc.server.org 005 test CHANTYPES=# STATUSMSG=@+ " +
+		"CASEMAPPING=ascii NETWORK=Test :are supported by this server"))
+	if c.ISupport().Network() != "Test" || c.ISupport().ChanTypes() != "#" {
+		t.Errorf("005 not parsed into ISupport.")
+	}
+
+	tests := []struct {
+		in     string
+		public bool
+		target string
+	}{
+		{":nick!u@h PRIVMSG #foo :hi", true, "#foo"},
+		{":nick!u@h PRIVMSG @#foo :hi", true, "@#foo"},
+		{":nick!u@h PRIVMSG &foo :hi", false, "nick"},
+		{":nick!u@h NOTICE +foo :hi", false, "nick"},
+		{":nick!u@h PRIVMSG test :hi", false, "nick"},
+		{":nick!u@h PRIVMSG #foo :\001ACTION waves\001", true, "#foo"},
+		{":nick!u@h PRIVMSG &foo :\001PING 1\001", false, "nick"},
+	}
+	for i, test := range tests {
+		l := c.parse(test.in)
+		if l.Public() != test.public || l.Target() != test.target {
+			t.Errorf("%d: %s: Public() = %t, Target() = %q", i, l.Cmd, l.Public(), l.Target())
+		}
+	}
+
+	// Lines not received from a server accept all channel types.
+	if !ParseLine(":nick!u@h PRIVMSG &foo :hi").Public() {
+		t.Errorf("ParseLine'd line not public.")
+	}
+}
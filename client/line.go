@@ -1,14 +1,42 @@
 package client
 
 import (
+	"errors"
+	"fmt"
 	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"time"
+	"unicode/utf8"
 
 	"github.com/fluffle/goirc/logging"
 )
 
-var tagsReplacer = strings.NewReplacer("\\:", ";", "\\s", " ", "\\r", "\r", "\\n", "\n")
+var tagsReplacer = strings.NewReplacer("\\:", ";", "\\s", " ", "\\r", "\r", "\\n", "\n", "\\\\", "\\")
+
+// tagsEscaper is the reverse of tagsReplacer, used when marshalling tags.
+var tagsEscaper = strings.NewReplacer(";", "\\:", " ", "\\s", "\r", "\\r", "\n", "\\n", "\\", "\\\\")
+
+// The maximum length of an IRC line, excluding tags and the trailing "\r\n".
+const maxLineLen = 510
+
+// Errors returned by Line.Marshal and Conn.Send for lines that cannot be
+// safely sent to an IRC server.
+var (
+	ErrNoCommand   = errors.New("irc: line has no command")
+	ErrBadChar     = errors.New("irc: line contains CR, LF or NUL")
+	ErrBadParam    = errors.New("irc: middle parameter is empty, contains a space or starts with ':'")
+	ErrLineTooLong = errors.New("irc: line is too long")
+	ErrBadTag      = errors.New("irc: tag key is empty or contains invalid characters")
+
+	// Returned by Conn.Send and friends when sending tags.
+	ErrNoMessageTags = errors.New("irc: message-tags capability not negotiated")
+)
+
+// The maximum length of the tag section of a line sent by a client,
+// including the leading '@' and trailing space.
+const maxClientTagsLen = 4094
 
 // We parse an incoming line into this struct. Line.Cmd is used as the trigger
 // name for incoming event handlers and is the IRC verb, the first sequence
@@ -21,15 +49,47 @@ type Line struct {
 	Nick, Ident, Host, Src string
 	Cmd, Raw               string
 	Args                   []string
-	Time                   time.Time
+
+	// Time is when the event happened. For lines received from a server,
+	// this comes from the IRCv3 server-time "time" tag if it is present,
+	// so lines replayed by a bouncer or from history have their original
+	// time. Otherwise it is the same as Received.
+	Time time.Time
+	// Received is the local time the line was received from the server.
+	Received time.Time
+
+	// Self is true for messages sent by this client, either echoed by
+	// the server with echo-message or echoed locally. See
+	// Config.EchoMessages.
+	Self bool
+
+	// Batch contains the completed batch for BATCH events, and the
+	// parts of reassembled multiline messages.
+	Batch *Batch
+
+	// The undecoded tag section of lines parsed with lazy tags.
+	rawTags string
+
+	// Features of the server the line was received from, used to
+	// recognise channel names. Nil for lines created by ParseLine.
+	isupport *ISupport
 }
 
-// Copy returns a deep copy of the Line.
+// Copy returns a deep copy of the Line. Lazily-parsed tags are decoded
+// into the copy's Tags.
 func (l *Line) Copy() *Line {
 	nl := *l
-	nl.Args = make([]string, len(l.Args))
-	copy(nl.Args, l.Args)
-	if l.Tags != nil {
+	// Pooled lines without arguments have an empty Args slice, but
+	// copies keep Args nil for them, as ParseLine does.
+	nl.Args = nil
+	if len(l.Args) > 0 {
+		nl.Args = make([]string, len(l.Args))
+		copy(nl.Args, l.Args)
+	}
+	if l.Tags == nil && l.rawTags != "" {
+		nl.Tags = decodeTags(l.rawTags)
+		nl.rawTags = ""
+	} else if l.Tags != nil {
 		nl.Tags = make(map[string]string)
 		for k, v := range l.Tags {
 			nl.Tags[k] = v
@@ -52,9 +112,8 @@ func (line *Line) Text() string {
 // will be that channel. If the line was sent directly by a user, the target
 // will be that user.
 func (line *Line) Target() string {
-	// TODO(fluffle): Add 005 CHANTYPES parsing for this?
 	switch line.Cmd {
-	case PRIVMSG, NOTICE, ACTION:
+	case PRIVMSG, NOTICE, ACTION, TAGMSG:
 		if !line.Public() {
 			return line.Nick
 		}
@@ -72,16 +131,18 @@ func (line *Line) Target() string {
 
 // Public returns true if the line is the result of an IRC user sending
 // a message to a channel the client has joined instead of directly
-// to the client.
+// to the client. Messages sent to a subset of a channel's users, e.g.
+// "@#chan", are also public.
 //
-// NOTE: This is very permissive, allowing all 4 RFC channel types even if
-// your server doesn't technically support them.
+// NOTE: Channel names are recognised using the CHANTYPES and STATUSMSG
+// sent by the server in RPL_ISUPPORT. Lines that were not received from a
+// server accept all 4 RFC channel types.
 func (line *Line) Public() bool {
+	var target string
 	switch line.Cmd {
-	case PRIVMSG, NOTICE, ACTION:
-		switch line.Args[0][0] {
-		case '#', '&', '+', '!':
-			return true
+	case PRIVMSG, NOTICE, ACTION, TAGMSG:
+		if len(line.Args) > 0 {
+			target = line.Args[0]
 		}
 	case CTCP, CTCPREPLY:
 		// CTCP prepends the CTCP verb to line.Args, thus for the message
@@ -90,12 +151,29 @@ func (line *Line) Public() bool {
 		// TODO(fluffle): Arguably this is broken, and we should have
 		// line.Args containing: []string{"#foo", "BAR", "baz"}
 		// ... OR change conn.Ctcp()'s argument order to be consistent.
-		switch line.Args[1][0] {
-		case '#', '&', '+', '!':
-			return true
+		if len(line.Args) > 1 {
+			target = line.Args[1]
 		}
 	}
-	return false
+	// Channel names can't start with a STATUSMSG prefix.
+	target = strings.TrimLeft(target, line.isupport.StatusMsg())
+	return line.isupport.IsChannel(target)
+}
+
+// setTime sets the line's Received time to now, and its Time from the
+// server-time tag, falling back to now if the tag is missing or invalid.
+func (line *Line) setTime(now time.Time) {
+	line.Received, line.Time = now, now
+	v, ok := line.Tag(serverTimeTag)
+	if !ok {
+		return
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		logging.Warn("irc.recv(): bad server-time tag %q: %v", v, err)
+		return
+	}
+	line.Time = t
 }
 
 // ParseLine creates a Line from an incoming message from the IRC server.
@@ -113,44 +191,72 @@ func (line *Line) Public() bool {
 // http://ircv3.net/specs/core/capability-negotiation-3.1.html
 // http://ircv3.net/specs/core/message-tags-3.2.html
 func ParseLine(s string) *Line {
-	line := &Line{Raw: s}
+	line := &Line{}
+	if !parseLine(line, s, false) {
+		return nil
+	}
+	if len(line.Args) == 0 {
+		// Keep Args nil for lines without arguments, as before.
+		line.Args = nil
+	}
+	return line
+}
+
+// Lines received from the server are parsed into Lines taken from this pool,
+// and returned to it by runLoop once all the foreground handlers are done.
+var linePool = sync.Pool{New: func() interface{} { return new(Line) }}
+
+func getLine() *Line {
+	return linePool.Get().(*Line)
+}
+
+func putLine(line *Line) {
+	// Don't keep the previous line's strings alive while in the pool.
+	clear(line.Args)
+	*line = Line{Args: line.Args[:0]}
+	linePool.Put(line)
+}
 
+// parseLine does the work for ParseLine, reusing line's Args slice if it has
+// enough capacity. If lazyTags is true, tags are not decoded into line.Tags,
+// and are instead decoded on demand by Line.Tag or Line.Copy.
+//
+// Parsing avoids allocation where possible: everything except line.Args and
+// unescaped tag values is a substring of s. The allocation budget is:
+//
+//   - 1 for a new Line, unless one is passed in from linePool;
+//   - 1 for the Args slice, unless line.Args has enough capacity;
+//   - 2 for the Tags map plus 1 per escaped tag key or value, unless
+//     lazyTags is set or the line has no tags.
+//
+// So a pooled Line with lazy tags, as used by recv, usually parses without
+// allocating at all. TestParseLineAllocs keeps us honest.
+func parseLine(line *Line, s string, lazyTags bool) bool {
+	*line = Line{Raw: s, Args: line.Args[:0]}
 	if s == "" {
-		return nil
+		return false
 	}
 
 	if s[0] == '@' {
-		var rawTags string
-		line.Tags = make(map[string]string)
-		if idx := strings.Index(s, " "); idx != -1 {
-			rawTags, s = s[1:idx], s[idx+1:]
-		} else {
-			return nil
+		idx := strings.IndexByte(s, ' ')
+		if idx == -1 {
+			return false
 		}
-
-		// ; is represented as \: in a tag, so it's safe to split on ;
-		for _, tag := range strings.Split(rawTags, ";") {
-			if tag == "" {
-				continue
-			}
-
-			pair := strings.SplitN(tagsReplacer.Replace(tag), "=", 2)
-			if len(pair) < 2 {
-				line.Tags[tag] = ""
-			} else {
-				line.Tags[pair[0]] = pair[1]
-			}
+		line.rawTags, s = s[1:idx], s[idx+1:]
+		if !lazyTags {
+			line.Tags = decodeTags(line.rawTags)
+			line.rawTags = ""
 		}
 	}
 
-	if s[0] == ':' {
+	if s != "" && s[0] == ':' {
 		// remove a source and parse it
-		if idx := strings.Index(s, " "); idx != -1 {
-			line.Src, s = s[1:idx], s[idx+1:]
-		} else {
+		idx := strings.IndexByte(s, ' ')
+		if idx == -1 {
 			// pretty sure we shouldn't get here ...
-			return nil
+			return false
 		}
+		line.Src, s = s[1:idx], s[idx+1:]
 
 		// src can be the hostname of the irc server or a nick!user@host
 		line.Host = line.Src
@@ -163,45 +269,316 @@ func ParseLine(s string) *Line {
 
 	// now we're here, we've parsed a :nick!user@host or :server off
 	// s should contain "cmd args[] :text"
-	args := strings.SplitN(s, " :", 2)
-	if len(args) > 1 {
-		args = append(strings.Fields(args[0]), args[1])
-	} else {
-		args = strings.Fields(args[0])
+	s = strings.TrimLeft(s, " ")
+	if s == "" {
+		// no command, somehow
+		return false
 	}
-	line.Cmd = strings.ToUpper(args[0])
-	if len(args) > 1 {
-		line.Args = args[1:]
+	cmd := s
+	if idx := strings.IndexByte(s, ' '); idx != -1 {
+		cmd, s = s[:idx], s[idx+1:]
+	} else {
+		s = ""
 	}
+	line.Cmd = strings.ToUpper(cmd)
 
+	// Make sure Args has room for every middle argument, the trailing
+	// argument, and the CTCP verb if this turns out to be a CTCP, in a
+	// single allocation.
+	params := s
+	if idx := strings.Index(s, " :"); idx != -1 {
+		params = s[:idx]
+	}
+	if n := strings.Count(params, " ") + 3; cap(line.Args) < n {
+		line.Args = make([]string, 0, n)
+	}
+	for s != "" {
+		if s[0] == ' ' {
+			s = s[1:]
+			continue
+		}
+		if s[0] == ':' {
+			line.Args = append(line.Args, s[1:])
+			break
+		}
+		arg := s
+		if idx := strings.IndexByte(s, ' '); idx != -1 {
+			arg, s = s[:idx], s[idx+1:]
+		} else {
+			s = ""
+		}
+		line.Args = append(line.Args, arg)
+	}
 	// So, I think CTCP and (in particular) CTCP ACTION are better handled as
 	// separate events as opposed to forcing people to have gargantuan
 	// handlers to cope with the possibilities.
-	if (line.Cmd == PRIVMSG || line.Cmd == NOTICE) &&
-		len(line.Args[1]) > 2 &&
-		strings.HasPrefix(line.Args[1], "\001") &&
-		strings.HasSuffix(line.Args[1], "\001") {
-		// WOO, it's a CTCP message
-		t := strings.SplitN(strings.Trim(line.Args[1], "\001"), " ", 2)
-		if len(t) > 1 {
-			// Replace the line with the unwrapped CTCP
-			line.Args[1] = t[1]
-		}
-		if c := strings.ToUpper(t[0]); c == ACTION && line.Cmd == PRIVMSG {
-			// make a CTCP ACTION it's own event a-la PRIVMSG
-			line.Cmd = c
-		} else {
-			// otherwise, dispatch a generic CTCP/CTCPREPLY event that
-			// contains the type of CTCP in line.Args[0]
-			if line.Cmd == PRIVMSG {
-				line.Cmd = CTCP
-			} else {
-				line.Cmd = CTCPREPLY
+	if (line.Cmd == PRIVMSG || line.Cmd == NOTICE) && len(line.Args) > 1 {
+		if payload, ok := singleCTCP(line.Args[1]); ok {
+			// WOO, it's a CTCP message
+			unwrapCTCP(line, payload)
+		}
+	}
+	return true
+}
+
+// decodeTags parses the raw tag section of a line, minus the leading '@'.
+func decodeTags(raw string) map[string]string {
+	tags := make(map[string]string, strings.Count(raw, ";")+1)
+	for raw != "" {
+		var tag string
+		// ; is represented as \: in a tag, so it's safe to split on ;
+		tag, raw, _ = strings.Cut(raw, ";")
+		if tag == "" {
+			continue
+		}
+		k, v, _ := strings.Cut(tag, "=")
+		tags[unescapeTag(k)] = unescapeTag(v)
+	}
+	return tags
+}
+
+// unescapeTag only bothers with tagsReplacer if there's something to replace.
+func unescapeTag(s string) string {
+	if strings.IndexByte(s, '\\') == -1 {
+		return s
+	}
+	return tagsReplacer.Replace(s)
+}
+
+// Tag returns the value of the named tag, and whether it was present. Lines
+// passed to ReadOnly handlers do not have Tags populated, so those handlers
+// must use Tag to access them. It works for all other Lines too.
+func (line *Line) Tag(key string) (string, bool) {
+	if line.Tags != nil || line.rawTags == "" {
+		v, ok := line.Tags[key]
+		return v, ok
+	}
+	var val string
+	found := false
+	for raw := line.rawTags; raw != ""; {
+		var tag string
+		tag, raw, _ = strings.Cut(raw, ";")
+		k, v, _ := strings.Cut(tag, "=")
+		if k == key || unescapeTag(k) == key {
+			// Later values for the same key take precedence.
+			val, found = unescapeTag(v), true
+		}
+	}
+	return val, found
+}
+
+// Marshal serializes the line into the IRC wire format, minus the trailing
+// "\r\n". It is the reverse of ParseLine: tags are escaped, the last
+// argument is sent as a trailing parameter if it needs to be, and CTCP,
+// CTCPREPLY and ACTION lines are converted back to \001-wrapped PRIVMSGs
+// and NOTICEs. Marshal returns an error if the line contains CR, LF or NUL,
+// if any argument other than the last is empty, contains a space or starts
+// with ':', if a tag key is invalid, or if the line is longer than 510
+// bytes excluding tags.
+func (line *Line) Marshal() (string, error) {
+	cmd, args := line.Cmd, line.Args
+	switch cmd {
+	case "":
+		return "", ErrNoCommand
+	case ACTION:
+		if len(args) > 0 {
+			cmd, args = PRIVMSG, []string{args[0], "\001" + ACTION + ctcpArg(args[1:]) + "\001"}
+		}
+	case CTCP, CTCPREPLY:
+		if len(args) > 1 {
+			ctcp := "\001" + strings.ToUpper(args[0]) + ctcpArg(args[2:]) + "\001"
+			cmd, args = PRIVMSG, []string{args[1], ctcp}
+			if line.Cmd == CTCPREPLY {
+				cmd = NOTICE
 			}
-			line.Args = append([]string{c}, line.Args...)
 		}
 	}
-	return line
+
+	var b strings.Builder
+	if line.Src != "" {
+		b.WriteString(":" + line.Src + " ")
+	}
+	b.WriteString(cmd)
+	for i, arg := range args {
+		b.WriteByte(' ')
+		if arg == "" || arg[0] == ':' || strings.Contains(arg, " ") {
+			if i < len(args)-1 {
+				return "", fmt.Errorf("%w: %q", ErrBadParam, arg)
+			}
+			b.WriteByte(':')
+		}
+		b.WriteString(arg)
+	}
+	s := b.String()
+	if strings.ContainsAny(s, "\r\n\000") {
+		return "", ErrBadChar
+	}
+	if len(s) > maxLineLen {
+		return "", fmt.Errorf("%w: %d > %d bytes", ErrLineTooLong, len(s), maxLineLen)
+	}
+	tags, err := line.marshalTags()
+	if err != nil {
+		return "", err
+	}
+	if tags != "" {
+		s = tags + " " + s
+	}
+	return s, nil
+}
+
+// String returns the line as it would be sent to an IRC server, or the
+// raw line if it cannot be marshalled.
+func (line *Line) String() string {
+	if s, err := line.Marshal(); err == nil {
+		return s
+	}
+	return line.Raw
+}
+
+// ctcpArg joins the arguments to a CTCP with a leading space, if necessary.
+func ctcpArg(args []string) string {
+	if a := strings.Join(args, " "); a != "" {
+		return " " + quoteCTCP(a)
+	}
+	return ""
+}
+
+// marshalTags returns the escaped tag section of the line, including the
+// leading '@'. Tags are sorted by key so that the output is predictable.
+func (line *Line) marshalTags() (string, error) {
+	if line.Tags == nil && line.rawTags != "" {
+		// Lazily-parsed tags are still escaped.
+		return "@" + line.rawTags, nil
+	}
+	if len(line.Tags) == 0 {
+		return "", nil
+	}
+	keys := make([]string, 0, len(line.Tags))
+	for k := range line.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	b.WriteByte('@')
+	for i, k := range keys {
+		if k == "" || strings.ContainsAny(k, "=; \r\n\000") {
+			return "", fmt.Errorf("%w: %q", ErrBadTag, k)
+		}
+		if i > 0 {
+			b.WriteByte(';')
+		}
+		b.WriteString(k)
+		if v := line.Tags[k]; v != "" {
+			b.WriteString("=" + tagsEscaper.Replace(v))
+		}
+	}
+	return b.String(), nil
+}
+
+// Errors wrapped in a ParseError by ParseLineStrict, in addition to
+// ErrNoCommand and ErrLineTooLong above.
+var (
+	ErrUnterminatedTags = errors.New("irc: tag section is not terminated by a space")
+	ErrTagsTooLong      = errors.New("irc: tag section is too long")
+	ErrBadPrefix        = errors.New("irc: source prefix is malformed")
+	ErrBadCommand       = errors.New("irc: command is neither a word nor a 3-digit numeric")
+	ErrInvalidUTF8      = errors.New("irc: line is not valid UTF-8")
+)
+
+// The maximum length of the tag section of a line, including the leading
+// '@' and trailing space.
+const maxTagsLen = 8191
+
+// ParseError describes why ParseLineStrict rejected a line.
+type ParseError struct {
+	// The line that failed to parse.
+	Raw string
+	// One of the Err* values above, possibly wrapped with more detail.
+	Err error
+}
+
+func (pe *ParseError) Error() string {
+	return fmt.Sprintf("%v: %q", pe.Err, pe.Raw)
+}
+
+func (pe *ParseError) Unwrap() error {
+	return pe.Err
+}
+
+// ParseLineStrict works like ParseLine, but checks that the line is
+// well-formed first. Rather than returning nil, it returns a *ParseError
+// wrapping one of ErrUnterminatedTags, ErrTagsTooLong, ErrBadPrefix,
+// ErrNoCommand, ErrBadCommand, ErrLineTooLong or ErrInvalidUTF8
+// describing the first problem found, which can be checked with errors.Is.
+func ParseLineStrict(s string) (*Line, error) {
+	if err := validateLine(s); err != nil {
+		return nil, &ParseError{Raw: s, Err: err}
+	}
+	line := ParseLine(s)
+	if line == nil {
+		// Shouldn't get here if validateLine is doing its job.
+		return nil, &ParseError{Raw: s, Err: ErrNoCommand}
+	}
+	return line, nil
+}
+
+func validateLine(s string) error {
+	if !utf8.ValidString(s) {
+		return ErrInvalidUTF8
+	}
+	if strings.HasPrefix(s, "@") {
+		idx := strings.Index(s, " ")
+		if idx == -1 {
+			return ErrUnterminatedTags
+		}
+		if idx+1 > maxTagsLen {
+			return fmt.Errorf("%w: %d > %d bytes", ErrTagsTooLong, idx+1, maxTagsLen)
+		}
+		s = s[idx+1:]
+	}
+	if len(s) > maxLineLen {
+		return fmt.Errorf("%w: %d > %d bytes", ErrLineTooLong, len(s), maxLineLen)
+	}
+	if strings.HasPrefix(s, ":") {
+		idx := strings.Index(s, " ")
+		if idx == -1 {
+			return ErrNoCommand
+		}
+		src := s[1:idx]
+		if src == "" {
+			return ErrBadPrefix
+		}
+		if strings.ContainsAny(src, "!@") {
+			if n, _, h, ok := parseUserHost(src); !ok || n == "" || h == "" {
+				return fmt.Errorf("%w: %q", ErrBadPrefix, src)
+			}
+		}
+		s = s[idx+1:]
+	}
+	cmd := s
+	if idx := strings.Index(s, " "); idx != -1 {
+		cmd = s[:idx]
+	}
+	if cmd == "" {
+		return ErrNoCommand
+	}
+	if !isCommand(cmd) {
+		return fmt.Errorf("%w: %q", ErrBadCommand, cmd)
+	}
+	return nil
+}
+
+// isCommand returns true if cmd is a string of letters or a 3-digit numeric.
+func isCommand(cmd string) bool {
+	if len(cmd) == 3 && strings.Trim(cmd, "0123456789") == "" {
+		return true
+	}
+	for _, c := range cmd {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z') {
+			return false
+		}
+	}
+	return true
 }
 
 func parseUserHost(uh string) (nick, ident, host string, ok bool) {
@@ -1,7 +1,9 @@
 package client
 
 import (
+	"errors"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -212,3 +214,201 @@ func TestParseUserHost(t *testing.T) {
 		}
 	}
 }
+
+func TestLineMarshal(t *testing.T) {
+	tests := []struct {
+		in  *Line
+		out string
+		err error
+	}{
+		{&Line{Cmd: PING}, "PING", nil},
+		{&Line{Cmd: PRIVMSG, Args: []string{"#foo", "bar"}}, "PRIVMSG #foo bar", nil},
+		{&Line{Cmd: PRIVMSG, Args: []string{"#foo", "bar baz"}}, "PRIVMSG #foo :bar baz", nil},
+		{&Line{Cmd: PRIVMSG, Args: []string{"#foo", ":)"}}, "PRIVMSG #foo ::)", nil},
+		{&Line{Cmd: AWAY, Args: []string{""}}, "AWAY :", nil},
+		{&Line{Cmd: MODE, Args: []string{"#foo", "+o", "nick"}}, "MODE #foo +o nick", nil},
+		{&Line{Src: "nick!ident@host", Cmd: QUIT, Args: []string{"bye"}}, ":nick!ident@host QUIT bye", nil},
+		{&Line{Cmd: ACTION, Args: []string{"#foo", "pokes bar"}}, "PRIVMSG #foo :\001ACTION pokes bar\001", nil},
+		{&Line{Cmd: CTCP, Args: []string{"VERSION", "nick"}}, "PRIVMSG nick \001VERSION\001", nil},
+		{&Line{Cmd: CTCPREPLY, Args: []string{"PING", "nick", "123"}}, "NOTICE nick :\001PING 123\001", nil},
+		{
+			&Line{Tags: map[string]string{"b": "x;y z\\", "a": ""}, Cmd: TAGMSG, Args: []string{"#foo"}},
+			"@a;b=x\\:y\\sz\\\\ TAGMSG #foo", nil,
+		},
+		{&Line{}, "", ErrNoCommand},
+		{&Line{Cmd: PRIVMSG, Args: []string{"#foo bar", "baz"}}, "", ErrBadParam},
+		{&Line{Cmd: PRIVMSG, Args: []string{":foo", "baz"}}, "", ErrBadParam},
+		{&Line{Cmd: KICK, Args: []string{"#foo", "", "baz"}}, "", ErrBadParam},
+		{&Line{Cmd: PRIVMSG, Args: []string{"#foo", "bar\r\nQUIT"}}, "", ErrBadChar},
+		{&Line{Cmd: PRIVMSG, Args: []string{"#foo", "bar\000"}}, "", ErrBadChar},
+		{&Line{Tags: map[string]string{"a b": "c"}, Cmd: PING}, "", ErrBadTag},
+		{&Line{Cmd: PRIVMSG, Args: []string{"#foo", strings.Repeat("x", 500)}}, "", ErrLineTooLong},
+	}
+	for i, test := range tests {
+		out, err := test.in.Marshal()
+		if out != test.out || !errors.Is(err, test.err) {
+			t.Errorf("test %d: Marshal() = %q, %v; want %q, %v", i, out, err, test.out, test.err)
+		}
+	}
+
+	// Marshalling a parsed line should be lossless.
+	for _, s := range []string{
+		"@msgid=a\\sb\\\\c;time=2011-10-19T16:40:51.620Z :nick!ident@host.com PRIVMSG #foo :Hello there",
+		":irc.server.org 353 test = #test1 :test @user1 user2",
+		":nick!ident@host.com PRIVMSG #foo :\001ACTION waves\001",
+		":nick!ident@host.com NOTICE nick :\001PING 12345\001",
+	} {
+		if out := ParseLine(s).String(); out != s {
+			t.Errorf("ParseLine(%q).String() = %q", s, out)
+		}
+	}
+}
+
+func TestParseLineStrict(t *testing.T) {
+	tests := []struct {
+		in  string
+		err error
+	}{
+		{":nick!ident@host.com PRIVMSG me :Hello", nil},
+		{"@a=b;c :irc.server.org 001 test :Welcome", nil},
+		{"PING :12345", nil},
+		{"", ErrNoCommand},
+		{"@a=b;c", ErrUnterminatedTags},
+		{"@" + strings.Repeat("a", maxTagsLen) + " PING", ErrTagsTooLong},
+		{":irc.server.org", ErrNoCommand},
+		{":irc.server.org ", ErrNoCommand},
+		{": PRIVMSG me :Hello", ErrBadPrefix},
+		{":nick!ident PRIVMSG me :Hello", ErrBadPrefix},
+		{":!ident@host PRIVMSG me :Hello", ErrBadPrefix},
+		{":irc.server.org 12345 test", ErrBadCommand},
+		{":irc.server.org PRIV_MSG test", ErrBadCommand},
+		{"PRIVMSG #foo :" + strings.Repeat("x", maxLineLen), ErrLineTooLong},
+		{"PRIVMSG #foo :\xff\xfe", ErrInvalidUTF8},
+	}
+	for i, test := range tests {
+		line, err := ParseLineStrict(test.in)
+		if !errors.Is(err, test.err) {
+			t.Errorf("test %d: ParseLineStrict(%q) error = %v, want %v", i, test.in, err, test.err)
+		}
+		if err != nil {
+			var pe *ParseError
+			if line != nil || !errors.As(err, &pe) || pe.Raw != test.in {
+				t.Errorf("test %d: ParseLineStrict(%q) = %#v, %#v", i, test.in, line, err)
+			}
+		} else if !reflect.DeepEqual(line, ParseLine(test.in)) {
+			t.Errorf("test %d: ParseLineStrict(%q) differs from ParseLine", i, test.in)
+		}
+	}
+
+	// ParseLine shouldn't panic on these either.
+	for _, s := range []string{":irc.server.org ", "@a=b ", "PRIVMSG #foo"} {
+		ParseLine(s)
+	}
+}
+
+func TestLineLazyTags(t *testing.T) {
+	s := "@a=b;c;esc=x\\sy;a=d :nick!ident@host.com PRIVMSG #foo :\001ACTION waves\001"
+	l := getLine()
+	defer putLine(l)
+	if !parseLine(l, s, true) {
+		t.Fatalf("parseLine(%q) failed", s)
+	}
+	if l.Tags != nil {
+		t.Errorf("Lazy tags decoded into Tags: %#v", l.Tags)
+	}
+	for _, test := range []struct {
+		key, val string
+		ok       bool
+	}{{"a", "d", true}, {"c", "", true}, {"esc", "x y", true}, {"nope", "", false}} {
+		if v, ok := l.Tag(test.key); v != test.val || ok != test.ok {
+			t.Errorf("Tag(%q) = %q, %t; want %q, %t", test.key, v, ok, test.val, test.ok)
+		}
+	}
+	if c := l.Copy(); !reflect.DeepEqual(c, ParseLine(s)) {
+		t.Errorf("Copy of lazy line differs from ParseLine:\n%#v\n%#v", c, ParseLine(s))
+	}
+	if got, _ := l.Marshal(); got != "@a=b;c;esc=x\\sy;a=d :nick!ident@host.com PRIVMSG #foo :\001ACTION waves\001" {
+		t.Errorf("Lazy line marshalled incorrectly: %q", got)
+	}
+}
+
+// See the allocation budget documented on parseLine.
+func TestParseLineAllocs(t *testing.T) {
+	tests := []struct {
+		in           string
+		lazy, pooled bool
+		allocs       float64
+	}{
+		{":nick!ident@host.com PRIVMSG #foo :Hello there", false, false, 2},
+		{":nick!ident@host.com PRIVMSG #foo :\001PING 12345\001", false, false, 2},
+		{"@time=2011-10-19T16:40:51.620Z;msgid=abc :nick!ident@host.com PRIVMSG #foo :Hello", false, true, 2},
+		{"@time=2011-10-19T16:40:51.620Z;msgid=abc :nick!ident@host.com PRIVMSG #foo :Hello", true, true, 0},
+		{":irc.server.org 353 test = #test1 :test @user1 user2 +voice", true, true, 0},
+		{":nick!ident@host.com PRIVMSG #foo :\001PING 12345\001", true, true, 0},
+	}
+	for i, test := range tests {
+		l := &Line{}
+		allocs := testing.AllocsPerRun(100, func() {
+			if !test.pooled {
+				l = &Line{}
+			}
+			parseLine(l, test.in, test.lazy)
+		})
+		if allocs > test.allocs {
+			t.Errorf("test %d: parseLine(%q) made %.0f allocations, budget is %.0f",
+				i, test.in, allocs, test.allocs)
+		}
+	}
+}
+
+func TestParseLineKeepsArgs(t *testing.T) {
+	// Lines without arguments don't throw away a pooled line's Args.
+	l := &Line{}
+	allocs := testing.AllocsPerRun(100, func() {
+		parseLine(l, ":irc.server.org 353 test = #test1 :test @user1", true)
+		parseLine(l, "CAPEND", true)
+	})
+	if allocs > 0 {
+		t.Errorf("parseLine made %.0f allocations reusing Args", allocs)
+	}
+	if l.Args == nil || len(l.Args) != 0 {
+		t.Errorf("Expected empty Args, got %#v", l.Args)
+	}
+	if c := l.Copy(); c.Args != nil {
+		t.Errorf("Expected nil Args in copy, got %#v", c.Args)
+	}
+	if l := ParseLine("CAPEND"); l.Args != nil {
+		t.Errorf("Expected nil Args from ParseLine, got %#v", l.Args)
+	}
+}
+
+var benchLines = []string{
+	":nick!ident@host.com PRIVMSG #foo :Hello there, how are you?",
+	"@time=2011-10-19T16:40:51.620Z;msgid=abc;account=nick :nick!ident@host.com PRIVMSG #foo :Hello",
+	":irc.server.org 353 test = #test1 :test @user1 user2 +voice %halfop @op &admin ~owner",
+	":nick!ident@host.com PRIVMSG #foo :\001ACTION waves\001",
+}
+
+func BenchmarkParseLine(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		ParseLine(benchLines[i%len(benchLines)])
+	}
+}
+
+func BenchmarkParseLinePooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l := getLine()
+		parseLine(l, benchLines[i%len(benchLines)], true)
+		putLine(l)
+	}
+}
+
+func BenchmarkLineCopy(b *testing.B) {
+	l := ParseLine(benchLines[1])
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		l.Copy()
+	}
+}
@@ -0,0 +1,174 @@
+package client
+
+import (
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+// multilineCap is the IRCv3 capability that allows messages containing
+// newlines, or too long for a single line, to be sent as a batch.
+const multilineCap = "draft/multiline"
+
+// multilineBatch is the type of batches containing multiline messages.
+const multilineBatch = "draft/multiline"
+
+// multilineConcatTag marks a line in a multiline batch that should be
+// joined to the previous line without a newline.
+const multilineConcatTag = "draft/multiline-concat"
+
+// multilineLimits returns the maximum number of bytes and lines in a
+// multiline batch, and whether we can send multiline batches at all.
+// A maximum of 0 lines means no limit.
+func (conn *Conn) multilineLimits() (maxBytes, maxLines int, ok bool) {
+	if !conn.HasCapability(multilineCap) || !conn.HasCapability(batchCap) ||
+		!conn.HasCapability(messageTagsCap) {
+		return 0, 0, false
+	}
+	// e.g. draft/multiline=max-bytes=4096,max-lines=24
+	for _, kv := range strings.Split(conn.supportedCaps.Value(multilineCap), ",") {
+		k, v, _ := strings.Cut(kv, "=")
+		n, _ := strconv.Atoi(v)
+		switch k {
+		case "max-bytes":
+			maxBytes = n
+		case "max-lines":
+			maxLines = n
+		}
+	}
+	// max-bytes is required by the spec, so don't guess.
+	return maxBytes, maxLines, maxBytes > 0
+}
+
+// sendMultiline sends msg to t as one or more multiline batches, if it
+// needs splitting and the server supports it. It returns false if msg
+// should be sent normally instead. The tags apply to the whole message,
+// so are sent with the BATCH lines.
+func (conn *Conn) sendMultiline(cmd, t, msg string, tags map[string]string) bool {
+	if len(msg) <= conn.cfg.SplitLen && !strings.Contains(msg, "\n") {
+		return false
+	}
+	maxBytes, maxLines, ok := conn.multilineLimits()
+	if !ok {
+		return false
+	}
+	splitLen := conn.cfg.SplitLen
+	if splitLen > maxBytes {
+		splitLen = maxBytes
+	}
+
+	// Marshal everything first, so we send nothing if any line is bad.
+	var out []string
+	var batch []*Line
+	var size int
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		ref := "ml" + strconv.FormatUint(atomic.AddUint64(&conn.multilineRef, 1), 36)
+		lines := append([]*Line{{Tags: tags, Cmd: BATCH, Args: []string{"+" + ref, multilineBatch, t}}}, batch...)
+		lines = append(lines, &Line{Cmd: BATCH, Args: []string{"-" + ref}})
+		for _, l := range lines[1 : len(lines)-1] {
+			if l.Tags == nil {
+				l.Tags = map[string]string{}
+			}
+			l.Tags[batchTag] = ref
+		}
+		for _, l := range lines {
+			s, err := conn.marshal(l)
+			if err != nil {
+				return err
+			}
+			out = append(out, s)
+		}
+		batch, size = nil, 0
+		return nil
+	}
+
+	// full returns true if adding n bytes in lines lines to the batch
+	// would exceed the server's limits.
+	full := func(n, lines int) bool {
+		return len(batch) > 0 && (size+n > maxBytes || (maxLines > 0 && len(batch)+lines > maxLines))
+	}
+	for _, text := range strings.Split(msg, "\n") {
+		parts := splitMessage(strings.TrimSuffix(text, "\r"), splitLen, "")
+		// Concatenated parts can't span batches, so start a new batch
+		// rather than split a line between them. Only a line too long
+		// for a batch on its own is split, as it has to be.
+		n := 0
+		if len(batch) > 0 {
+			// The newline joining this line to the previous one.
+			n++
+		}
+		for _, s := range parts {
+			n += len(s)
+		}
+		if full(n, len(parts)) {
+			if err := flush(); err != nil {
+				logging.Warn("irc.sendMultiline(): %v", err)
+				return false
+			}
+		}
+		for i, s := range parts {
+			concat := i > 0
+			n := len(s)
+			if len(batch) > 0 && !concat {
+				n++
+			}
+			if full(n, 1) {
+				if err := flush(); err != nil {
+					logging.Warn("irc.sendMultiline(): %v", err)
+					return false
+				}
+				n = len(s)
+			}
+			l := &Line{Cmd: cmd, Args: []string{t, s}}
+			if concat && len(batch) > 0 {
+				l.Tags = map[string]string{multilineConcatTag: ""}
+			}
+			batch = append(batch, l)
+			size += n
+		}
+	}
+	if err := flush(); err != nil {
+		logging.Warn("irc.sendMultiline(): %v", err)
+		return false
+	}
+	for _, s := range out {
+		conn.out <- s
+	}
+	conn.echoLine(&Line{Tags: tags, Cmd: cmd, Args: []string{t, msg}})
+	return true
+}
+
+// assembleMultiline returns a PRIVMSG or NOTICE containing the message
+// sent as the completed multiline batch b, or nil if b is empty. The
+// message has the tags sent with the BATCH line, and b in Line.Batch.
+func assembleMultiline(b *Batch) *Line {
+	if len(b.Lines) == 0 {
+		return nil
+	}
+	var text strings.Builder
+	for i, l := range b.Lines {
+		if _, concat := l.Tags[multilineConcatTag]; i > 0 && !concat {
+			text.WriteByte('\n')
+		}
+		text.WriteString(l.Text())
+	}
+	msg := b.Lines[0].Copy()
+	if len(b.Params) > 0 {
+		msg.Args = []string{b.Params[0], text.String()}
+	} else {
+		msg.Args[len(msg.Args)-1] = text.String()
+	}
+	msg.Tags = make(map[string]string, len(b.Tags))
+	for k, v := range b.Tags {
+		if k != batchTag {
+			msg.Tags[k] = v
+		}
+	}
+	msg.Batch = b
+	return msg
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSendMultiline(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	c.cfg.SplitLen = 20
+
+	// Without the cap, messages are split as usual.
+	c.Privmsg("#chan", "line one\nline two")
+	s.nc.Expect("PRIVMSG #chan :line one")
+	s.nc.ExpectNothing()
+
+	c.supportedCaps.Add("draft/multiline=max-bytes=40,max-lines=3")
+	c.currCaps.Add(messageTagsCap, batchCap, multilineCap)
+
+	// Short messages are still sent normally.
+	c.Privmsg("#chan", "short")
+	s.nc.Expect("PRIVMSG #chan :short")
+	s.nc.ExpectNothing()
+
+	// Long lines are split with the concat tag, and messages that are
+	// too long for one batch are sent as several, without splitting a
+	// line between batches.
+	c.Notice("#chan", "line one\nthis is a rather long second line\n\nend")
+	s.nc.Expect("BATCH +ml1 draft/multiline #chan")
+	s.nc.Expect("@batch=ml1 NOTICE #chan :line one")
+	s.nc.Expect("BATCH -ml1")
+	s.nc.Expect("BATCH +ml2 draft/multiline #chan")
+	s.nc.Expect("@batch=ml2 NOTICE #chan :this is a rather ")
+	s.nc.Expect("@batch=ml2;draft/multiline-concat NOTICE #chan :long second line")
+	s.nc.Expect("@batch=ml2 NOTICE #chan :")
+	s.nc.Expect("BATCH -ml2")
+	s.nc.Expect("BATCH +ml3 draft/multiline #chan")
+	s.nc.Expect("@batch=ml3 NOTICE #chan end")
+	s.nc.Expect("BATCH -ml3")
+	s.nc.ExpectNothing()
+
+	// Only a line too long for a batch of its own is split between them.
+	c.Privmsg("#chan", "end\nthis is a much, much longer line that can't fit")
+	s.nc.Expect("BATCH +ml4 draft/multiline #chan")
+	s.nc.Expect("@batch=ml4 PRIVMSG #chan end")
+	s.nc.Expect("BATCH -ml4")
+	s.nc.Expect("BATCH +ml5 draft/multiline #chan")
+	s.nc.Expect("@batch=ml5 PRIVMSG #chan :this is a much, ")
+	s.nc.Expect("@batch=ml5;draft/multiline-concat PRIVMSG #chan :much longer line ")
+	s.nc.Expect("BATCH -ml5")
+	s.nc.Expect("BATCH +ml6 draft/multiline #chan")
+	s.nc.Expect("@batch=ml6 PRIVMSG #chan :that can't fit")
+	s.nc.Expect("BATCH -ml6")
+	s.nc.ExpectNothing()
+
+	c.supportedCaps.Add("draft/multiline=max-bytes=4096")
+	if err := c.PrivmsgTags("#chan", "this is a rather long message", map[string]string{"+draft/reply": "id"}); err != nil {
+		t.Errorf("PrivmsgTags failed: %v", err)
+	}
+	s.nc.Expect("@+draft/reply=id BATCH +ml7 draft/multiline #chan")
+	s.nc.Expect("@batch=ml7 PRIVMSG #chan :this is a rather ")
+	s.nc.Expect("@batch=ml7;draft/multiline-concat PRIVMSG #chan :long message")
+	s.nc.Expect("BATCH -ml7")
+	s.nc.ExpectNothing()
+
+	// Without max-bytes, we fall back to splitting.
+	c.supportedCaps.Add("draft/multiline")
+	c.Privmsg("#chan", "line one\nline two")
+	s.nc.Expect("PRIVMSG #chan :line one")
+	s.nc.ExpectNothing()
+}
+
+func TestReceiveMultiline(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	// Parts aren't dispatched by default, even without NewConfig.
+	c.cfg.BatchDispatch = nil
+
+	var batches []*Line
+	var msgs []*Line
+	c.HandleFunc(BATCH, func(_ *Conn, l *Line) { batches = append(batches, l) })
+	c.HandleFunc(PRIVMSG, func(_ *Conn, l *Line) { msgs = append(msgs, l.Copy()) })
+	send := func(lines ...string) {
+		for _, l := range lines {
+			c.dispatch(ParseLine(l))
+		}
+	}
+
+	send(
+		"@msgid=abc :nick!user@host BATCH +ml draft/multiline #chan",
+		"@batch=ml :nick!user@host PRIVMSG #chan :hello",
+		"@batch=ml :nick!user@host PRIVMSG #chan :wor",
+		"@batch=ml;draft/multiline-concat :nick!user@host PRIVMSG #chan :ld",
+		":nick!user@host BATCH -ml",
+	)
+	if len(batches) != 0 || len(msgs) != 1 {
+		t.Fatalf("Multiline batch dispatched incorrectly: %d %d", len(batches), len(msgs))
+	}
+	m := msgs[0]
+	if m.Cmd != PRIVMSG || m.Nick != "nick" ||
+		!reflect.DeepEqual(m.Args, []string{"#chan", "hello\nworld"}) ||
+		!reflect.DeepEqual(m.Tags, map[string]string{"msgid": "abc"}) {
+		t.Errorf("Bad multiline message: %#v", m)
+	}
+	if m.Batch == nil || m.Batch.Type != multilineBatch || len(m.Batch.Lines) != 3 {
+		t.Errorf("Bad multiline batch: %#v", m.Batch)
+	}
+
+	// Multiline messages in chathistory appear as one line in the batch.
+	msgs = nil
+	send(
+		":irc.server.org BATCH +hist chathistory #chan",
+		"@batch=hist :nick!user@host BATCH +ml draft/multiline #chan",
+		"@batch=ml :nick!user@host PRIVMSG #chan :one",
+		"@batch=ml :nick!user@host PRIVMSG #chan :two",
+		":nick!user@host BATCH -ml",
+		"@batch=hist :nick!user@host PRIVMSG #chan :three",
+		":irc.server.org BATCH -hist",
+	)
+	if len(msgs) != 0 || len(batches) != 1 {
+		t.Fatalf("Chathistory batch dispatched incorrectly: %d %d", len(batches), len(msgs))
+	}
+	b := batches[0].Batch
+	if len(b.Lines) != 2 || b.Lines[0].Text() != "one\ntwo" || b.Lines[1].Text() != "three" ||
+		len(b.Batches) != 0 {
+		t.Errorf("Bad chathistory batch: %#v", b)
+	}
+}
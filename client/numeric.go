@@ -0,0 +1,72 @@
+package client
+
+//go:generate go run gen_numerics.go
+
+// A NumericClass describes what kind of reply a numeric is.
+type NumericClass int
+
+const (
+	// NumericReply is a reply to a command.
+	NumericReply NumericClass = iota
+	// NumericError reports that a command failed.
+	NumericError
+	// NumericRegistration is sent while connecting to a server.
+	NumericRegistration
+	// NumericWhois is part of the reply to a WHOIS command.
+	NumericWhois
+)
+
+func (nc NumericClass) String() string {
+	switch nc {
+	case NumericReply:
+		return "reply"
+	case NumericError:
+		return "error"
+	case NumericRegistration:
+		return "registration"
+	case NumericWhois:
+		return "whois"
+	}
+	return "unknown"
+}
+
+// A Numeric describes a numeric reply from an IRC server.
+type Numeric struct {
+	// The three digit numeric, e.g. "001".
+	Code string
+	// The symbolic name of the numeric, e.g. "RPL_WELCOME".
+	Name  string
+	Class NumericClass
+	// The expected arguments, in the notation of the IRC specs, e.g.
+	// "<client> <nick> :No such nick/channel".
+	Args string
+}
+
+// LookupNumeric returns information about a numeric reply, if it is known.
+func LookupNumeric(code string) (Numeric, bool) {
+	n, ok := numerics[code]
+	return n, ok
+}
+
+// isNumeric returns true if cmd is a three digit numeric.
+func isNumeric(cmd string) bool {
+	return len(cmd) == 3 &&
+		cmd[0] >= '0' && cmd[0] <= '9' &&
+		cmd[1] >= '0' && cmd[1] <= '9' &&
+		cmd[2] >= '0' && cmd[2] <= '9'
+}
+
+// NumericName returns the symbolic name of the line's numeric, e.g.
+// "ERR_NICKNAMEINUSE", or "" if the line isn't a known numeric.
+func (line *Line) NumericName() string {
+	return numerics[line.Cmd].Name
+}
+
+// IsError returns true if the line is a numeric error reply. Numerics
+// that aren't known are errors if they are in the range 400-599.
+func (line *Line) IsError() bool {
+	if n, ok := numerics[line.Cmd]; ok {
+		return n.Class == NumericError
+	}
+	return isNumeric(line.Cmd) && line.Cmd[0] >= '4' && line.Cmd[0] <= '5'
+}
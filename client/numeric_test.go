@@ -0,0 +1,45 @@
+package client
+
+import "testing"
+
+func TestNumerics(t *testing.T) {
+	tests := []struct {
+		in      string
+		name    string
+		isError bool
+	}{
+		{":irc.server.org 001 test :Welcome to IRC test!test@host", "RPL_WELCOME", false},
+		{":irc.server.org 433 * test :Nickname is already in use", "ERR_NICKNAMEINUSE", true},
+		{":irc.server.org 904 test :SASL authentication failed", "ERR_SASLFAIL", true},
+		{":irc.server.org 908 test PLAIN :are available SASL mechanisms", "RPL_SASLMECHS", false},
+		{":irc.server.org 499 test :Some unknown error", "", true},
+		{":irc.server.org 299 test :Some unknown reply", "", false},
+		{":nick!user@host PRIVMSG #chan :hello", "", false},
+		{":nick!user@host 4XX #chan :hello", "", false},
+	}
+	for i, test := range tests {
+		l := ParseLine(test.in)
+		if l.NumericName() != test.name || l.IsError() != test.isError {
+			t.Errorf("%d: NumericName() = %q, IsError() = %t", i, l.NumericName(), l.IsError())
+		}
+	}
+
+	n, ok := LookupNumeric(RPL_WHOISUSER)
+	if !ok || n.Code != "311" || n.Name != "RPL_WHOISUSER" || n.Class != NumericWhois ||
+		n.Args != "<client> <nick> <username> <host> * :<realname>" {
+		t.Errorf("LookupNumeric(RPL_WHOISUSER) = %#v, %t", n, ok)
+	}
+	if _, ok := LookupNumeric("999"); ok {
+		t.Errorf("LookupNumeric found unknown numeric.")
+	}
+
+	// Check the generated table is consistent.
+	for code, n := range numerics {
+		if code != n.Code || !isNumeric(code) || n.Name == "" || n.Args == "" {
+			t.Errorf("Bad numerics entry %q: %#v", code, n)
+		}
+		if (n.Class == NumericError) != (n.Name[:4] == "ERR_") {
+			t.Errorf("Numeric %s has class %s", n.Name, n.Class)
+		}
+	}
+}
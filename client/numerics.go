@@ -0,0 +1,288 @@
+// Code generated by gen_numerics.go from numerics.txt; DO NOT EDIT.
+
+package client
+
+// Numeric replies. See numerics.txt for their expected arguments.
+const (
+	RPL_WELCOME           = "001"
+	RPL_YOURHOST          = "002"
+	RPL_CREATED           = "003"
+	RPL_MYINFO            = "004"
+	RPL_ISUPPORT          = "005"
+	RPL_BOUNCE            = "010"
+	RPL_UMODEIS           = "221"
+	RPL_LUSERCLIENT       = "251"
+	RPL_LUSEROP           = "252"
+	RPL_LUSERUNKNOWN      = "253"
+	RPL_LUSERCHANNELS     = "254"
+	RPL_LUSERME           = "255"
+	RPL_ADMINME           = "256"
+	RPL_ADMINLOC1         = "257"
+	RPL_ADMINLOC2         = "258"
+	RPL_ADMINEMAIL        = "259"
+	RPL_TRYAGAIN          = "263"
+	RPL_LOCALUSERS        = "265"
+	RPL_GLOBALUSERS       = "266"
+	RPL_WHOISCERTFP       = "276"
+	RPL_AWAY              = "301"
+	RPL_USERHOST          = "302"
+	RPL_ISON              = "303"
+	RPL_UNAWAY            = "305"
+	RPL_NOWAWAY           = "306"
+	RPL_WHOISREGNICK      = "307"
+	RPL_WHOISUSER         = "311"
+	RPL_WHOISSERVER       = "312"
+	RPL_WHOISOPERATOR     = "313"
+	RPL_WHOWASUSER        = "314"
+	RPL_ENDOFWHO          = "315"
+	RPL_WHOISIDLE         = "317"
+	RPL_ENDOFWHOIS        = "318"
+	RPL_WHOISCHANNELS     = "319"
+	RPL_WHOISSPECIAL      = "320"
+	RPL_LISTSTART         = "321"
+	RPL_LIST              = "322"
+	RPL_LISTEND           = "323"
+	RPL_CHANNELMODEIS     = "324"
+	RPL_CREATIONTIME      = "329"
+	RPL_WHOISACCOUNT      = "330"
+	RPL_NOTOPIC           = "331"
+	RPL_TOPIC             = "332"
+	RPL_TOPICWHOTIME      = "333"
+	RPL_INVITELIST        = "336"
+	RPL_ENDOFINVITELIST   = "337"
+	RPL_WHOISACTUALLY     = "338"
+	RPL_INVITING          = "341"
+	RPL_INVEXLIST         = "346"
+	RPL_ENDOFINVEXLIST    = "347"
+	RPL_EXCEPTLIST        = "348"
+	RPL_ENDOFEXCEPTLIST   = "349"
+	RPL_VERSION           = "351"
+	RPL_WHOREPLY          = "352"
+	RPL_NAMREPLY          = "353"
+	RPL_WHOSPCRPL         = "354"
+	RPL_LINKS             = "364"
+	RPL_ENDOFLINKS        = "365"
+	RPL_ENDOFNAMES        = "366"
+	RPL_BANLIST           = "367"
+	RPL_ENDOFBANLIST      = "368"
+	RPL_ENDOFWHOWAS       = "369"
+	RPL_INFO              = "371"
+	RPL_MOTD              = "372"
+	RPL_ENDOFINFO         = "374"
+	RPL_MOTDSTART         = "375"
+	RPL_ENDOFMOTD         = "376"
+	RPL_WHOISHOST         = "378"
+	RPL_WHOISMODES        = "379"
+	RPL_YOUREOPER         = "381"
+	RPL_REHASHING         = "382"
+	RPL_TIME              = "391"
+	RPL_HOSTHIDDEN        = "396"
+	ERR_UNKNOWNERROR      = "400"
+	ERR_NOSUCHNICK        = "401"
+	ERR_NOSUCHSERVER      = "402"
+	ERR_NOSUCHCHANNEL     = "403"
+	ERR_CANNOTSENDTOCHAN  = "404"
+	ERR_TOOMANYCHANNELS   = "405"
+	ERR_WASNOSUCHNICK     = "406"
+	ERR_TOOMANYTARGETS    = "407"
+	ERR_NOORIGIN          = "409"
+	ERR_INVALIDCAPCMD     = "410"
+	ERR_NORECIPIENT       = "411"
+	ERR_NOTEXTTOSEND      = "412"
+	ERR_INPUTTOOLONG      = "417"
+	ERR_UNKNOWNCOMMAND    = "421"
+	ERR_NOMOTD            = "422"
+	ERR_NONICKNAMEGIVEN   = "431"
+	ERR_ERRONEUSNICKNAME  = "432"
+	ERR_NICKNAMEINUSE     = "433"
+	ERR_NICKCOLLISION     = "436"
+	ERR_UNAVAILRESOURCE   = "437"
+	ERR_USERNOTINCHANNEL  = "441"
+	ERR_NOTONCHANNEL      = "442"
+	ERR_USERONCHANNEL     = "443"
+	ERR_NOTREGISTERED     = "451"
+	ERR_NEEDMOREPARAMS    = "461"
+	ERR_ALREADYREGISTERED = "462"
+	ERR_PASSWDMISMATCH    = "464"
+	ERR_YOUREBANNEDCREEP  = "465"
+	ERR_CHANNELISFULL     = "471"
+	ERR_UNKNOWNMODE       = "472"
+	ERR_INVITEONLYCHAN    = "473"
+	ERR_BANNEDFROMCHAN    = "474"
+	ERR_BADCHANNELKEY     = "475"
+	ERR_BADCHANMASK       = "476"
+	ERR_NEEDREGGEDNICK    = "477"
+	ERR_BANLISTFULL       = "478"
+	ERR_NOPRIVILEGES      = "481"
+	ERR_CHANOPRIVSNEEDED  = "482"
+	ERR_CANTKILLSERVER    = "483"
+	ERR_NOOPERHOST        = "491"
+	ERR_UMODEUNKNOWNFLAG  = "501"
+	ERR_USERSDONTMATCH    = "502"
+	ERR_HELPNOTFOUND      = "524"
+	ERR_INVALIDKEY        = "525"
+	RPL_STARTTLS          = "670"
+	RPL_WHOISSECURE       = "671"
+	ERR_STARTTLS          = "691"
+	ERR_INVALIDMODEPARAM  = "696"
+	RPL_HELPSTART         = "704"
+	RPL_HELPTXT           = "705"
+	RPL_ENDOFHELP         = "706"
+	ERR_NOPRIVS           = "723"
+	RPL_MONONLINE         = "730"
+	RPL_MONOFFLINE        = "731"
+	RPL_MONLIST           = "732"
+	RPL_ENDOFMONLIST      = "733"
+	ERR_MONLISTFULL       = "734"
+	RPL_LOGGEDIN          = "900"
+	RPL_LOGGEDOUT         = "901"
+	ERR_NICKLOCKED        = "902"
+	RPL_SASLSUCCESS       = "903"
+	ERR_SASLFAIL          = "904"
+	ERR_SASLTOOLONG       = "905"
+	ERR_SASLABORTED       = "906"
+	ERR_SASLALREADY       = "907"
+	RPL_SASLMECHS         = "908"
+)
+
+var numerics = map[string]Numeric{
+	RPL_WELCOME:           {RPL_WELCOME, "RPL_WELCOME", NumericRegistration, "<client> :Welcome to the <networkname> Network, <nick>[!<user>@<host>]"},
+	RPL_YOURHOST:          {RPL_YOURHOST, "RPL_YOURHOST", NumericRegistration, "<client> :Your host is <servername>, running version <version>"},
+	RPL_CREATED:           {RPL_CREATED, "RPL_CREATED", NumericRegistration, "<client> :This server was created <datetime>"},
+	RPL_MYINFO:            {RPL_MYINFO, "RPL_MYINFO", NumericRegistration, "<client> <servername> <version> <user modes> <channel modes> [<channel modes with a parameter>]"},
+	RPL_ISUPPORT:          {RPL_ISUPPORT, "RPL_ISUPPORT", NumericRegistration, "<client> <token>[=<value>]{ <token>[=<value>]} :are supported by this server"},
+	RPL_BOUNCE:            {RPL_BOUNCE, "RPL_BOUNCE", NumericReply, "<client> <hostname> <port> :<info>"},
+	RPL_UMODEIS:           {RPL_UMODEIS, "RPL_UMODEIS", NumericReply, "<client> <user modes>"},
+	RPL_LUSERCLIENT:       {RPL_LUSERCLIENT, "RPL_LUSERCLIENT", NumericRegistration, "<client> :There are <u> users and <i> invisible on <s> servers"},
+	RPL_LUSEROP:           {RPL_LUSEROP, "RPL_LUSEROP", NumericRegistration, "<client> <ops> :operator(s) online"},
+	RPL_LUSERUNKNOWN:      {RPL_LUSERUNKNOWN, "RPL_LUSERUNKNOWN", NumericRegistration, "<client> <connections> :unknown connection(s)"},
+	RPL_LUSERCHANNELS:     {RPL_LUSERCHANNELS, "RPL_LUSERCHANNELS", NumericRegistration, "<client> <channels> :channels formed"},
+	RPL_LUSERME:           {RPL_LUSERME, "RPL_LUSERME", NumericRegistration, "<client> :I have <c> clients and <s> servers"},
+	RPL_ADMINME:           {RPL_ADMINME, "RPL_ADMINME", NumericReply, "<client> [<server>] :Administrative info"},
+	RPL_ADMINLOC1:         {RPL_ADMINLOC1, "RPL_ADMINLOC1", NumericReply, "<client> :<info>"},
+	RPL_ADMINLOC2:         {RPL_ADMINLOC2, "RPL_ADMINLOC2", NumericReply, "<client> :<info>"},
+	RPL_ADMINEMAIL:        {RPL_ADMINEMAIL, "RPL_ADMINEMAIL", NumericReply, "<client> :<info>"},
+	RPL_TRYAGAIN:          {RPL_TRYAGAIN, "RPL_TRYAGAIN", NumericReply, "<client> <command> :Please wait a while and try again."},
+	RPL_LOCALUSERS:        {RPL_LOCALUSERS, "RPL_LOCALUSERS", NumericRegistration, "<client> [<u> <m>] :Current local users <u>, max <m>"},
+	RPL_GLOBALUSERS:       {RPL_GLOBALUSERS, "RPL_GLOBALUSERS", NumericRegistration, "<client> [<u> <m>] :Current global users <u>, max <m>"},
+	RPL_WHOISCERTFP:       {RPL_WHOISCERTFP, "RPL_WHOISCERTFP", NumericWhois, "<client> <nick> :has client certificate fingerprint <fingerprint>"},
+	RPL_AWAY:              {RPL_AWAY, "RPL_AWAY", NumericReply, "<client> <nick> :<message>"},
+	RPL_USERHOST:          {RPL_USERHOST, "RPL_USERHOST", NumericReply, "<client> :[<reply>{ <reply>}]"},
+	RPL_ISON:              {RPL_ISON, "RPL_ISON", NumericReply, "<client> :[<nick>{ <nick>}]"},
+	RPL_UNAWAY:            {RPL_UNAWAY, "RPL_UNAWAY", NumericReply, "<client> :You are no longer marked as being away"},
+	RPL_NOWAWAY:           {RPL_NOWAWAY, "RPL_NOWAWAY", NumericReply, "<client> :You have been marked as being away"},
+	RPL_WHOISREGNICK:      {RPL_WHOISREGNICK, "RPL_WHOISREGNICK", NumericWhois, "<client> <nick> :has identified for this nick"},
+	RPL_WHOISUSER:         {RPL_WHOISUSER, "RPL_WHOISUSER", NumericWhois, "<client> <nick> <username> <host> * :<realname>"},
+	RPL_WHOISSERVER:       {RPL_WHOISSERVER, "RPL_WHOISSERVER", NumericWhois, "<client> <nick> <server> :<server info>"},
+	RPL_WHOISOPERATOR:     {RPL_WHOISOPERATOR, "RPL_WHOISOPERATOR", NumericWhois, "<client> <nick> :is an IRC operator"},
+	RPL_WHOWASUSER:        {RPL_WHOWASUSER, "RPL_WHOWASUSER", NumericReply, "<client> <nick> <username> <host> * :<realname>"},
+	RPL_ENDOFWHO:          {RPL_ENDOFWHO, "RPL_ENDOFWHO", NumericReply, "<client> <mask> :End of WHO list"},
+	RPL_WHOISIDLE:         {RPL_WHOISIDLE, "RPL_WHOISIDLE", NumericWhois, "<client> <nick> <secs> <signon> :seconds idle, signon time"},
+	RPL_ENDOFWHOIS:        {RPL_ENDOFWHOIS, "RPL_ENDOFWHOIS", NumericWhois, "<client> <nick> :End of /WHOIS list"},
+	RPL_WHOISCHANNELS:     {RPL_WHOISCHANNELS, "RPL_WHOISCHANNELS", NumericWhois, "<client> <nick> :[<prefix>]<channel>{ [<prefix>]<channel>}"},
+	RPL_WHOISSPECIAL:      {RPL_WHOISSPECIAL, "RPL_WHOISSPECIAL", NumericWhois, "<client> <nick> :<text>"},
+	RPL_LISTSTART:         {RPL_LISTSTART, "RPL_LISTSTART", NumericReply, "<client> Channel :Users  Name"},
+	RPL_LIST:              {RPL_LIST, "RPL_LIST", NumericReply, "<client> <channel> <client count> :<topic>"},
+	RPL_LISTEND:           {RPL_LISTEND, "RPL_LISTEND", NumericReply, "<client> :End of /LIST"},
+	RPL_CHANNELMODEIS:     {RPL_CHANNELMODEIS, "RPL_CHANNELMODEIS", NumericReply, "<client> <channel> <modestring> <mode arguments>..."},
+	RPL_CREATIONTIME:      {RPL_CREATIONTIME, "RPL_CREATIONTIME", NumericReply, "<client> <channel> <creationtime>"},
+	RPL_WHOISACCOUNT:      {RPL_WHOISACCOUNT, "RPL_WHOISACCOUNT", NumericWhois, "<client> <nick> <account> :is logged in as"},
+	RPL_NOTOPIC:           {RPL_NOTOPIC, "RPL_NOTOPIC", NumericReply, "<client> <channel> :No topic is set"},
+	RPL_TOPIC:             {RPL_TOPIC, "RPL_TOPIC", NumericReply, "<client> <channel> :<topic>"},
+	RPL_TOPICWHOTIME:      {RPL_TOPICWHOTIME, "RPL_TOPICWHOTIME", NumericReply, "<client> <channel> <nick> <setat>"},
+	RPL_INVITELIST:        {RPL_INVITELIST, "RPL_INVITELIST", NumericReply, "<client> <channel>"},
+	RPL_ENDOFINVITELIST:   {RPL_ENDOFINVITELIST, "RPL_ENDOFINVITELIST", NumericReply, "<client> :End of /INVITE list"},
+	RPL_WHOISACTUALLY:     {RPL_WHOISACTUALLY, "RPL_WHOISACTUALLY", NumericWhois, "<client> <nick> [<user>@<host>] [<ip>] :Is actually using host"},
+	RPL_INVITING:          {RPL_INVITING, "RPL_INVITING", NumericReply, "<client> <nick> <channel>"},
+	RPL_INVEXLIST:         {RPL_INVEXLIST, "RPL_INVEXLIST", NumericReply, "<client> <channel> <mask>"},
+	RPL_ENDOFINVEXLIST:    {RPL_ENDOFINVEXLIST, "RPL_ENDOFINVEXLIST", NumericReply, "<client> <channel> :End of Channel Invite Exception List"},
+	RPL_EXCEPTLIST:        {RPL_EXCEPTLIST, "RPL_EXCEPTLIST", NumericReply, "<client> <channel> <mask>"},
+	RPL_ENDOFEXCEPTLIST:   {RPL_ENDOFEXCEPTLIST, "RPL_ENDOFEXCEPTLIST", NumericReply, "<client> <channel> :End of channel exception list"},
+	RPL_VERSION:           {RPL_VERSION, "RPL_VERSION", NumericReply, "<client> <version> <server> :<comments>"},
+	RPL_WHOREPLY:          {RPL_WHOREPLY, "RPL_WHOREPLY", NumericReply, "<client> <channel> <username> <host> <server> <nick> <flags> :<hopcount> <realname>"},
+	RPL_NAMREPLY:          {RPL_NAMREPLY, "RPL_NAMREPLY", NumericReply, "<client> <symbol> <channel> :[<prefix>]<nick>{ [<prefix>]<nick>}"},
+	RPL_WHOSPCRPL:         {RPL_WHOSPCRPL, "RPL_WHOSPCRPL", NumericReply, "<client> [<token>] <field>{ <field>}"},
+	RPL_LINKS:             {RPL_LINKS, "RPL_LINKS", NumericReply, "<client> * <server> :<hopcount> <server info>"},
+	RPL_ENDOFLINKS:        {RPL_ENDOFLINKS, "RPL_ENDOFLINKS", NumericReply, "<client> * :End of /LINKS list"},
+	RPL_ENDOFNAMES:        {RPL_ENDOFNAMES, "RPL_ENDOFNAMES", NumericReply, "<client> <channel> :End of /NAMES list"},
+	RPL_BANLIST:           {RPL_BANLIST, "RPL_BANLIST", NumericReply, "<client> <channel> <mask> [<who> <set-ts>]"},
+	RPL_ENDOFBANLIST:      {RPL_ENDOFBANLIST, "RPL_ENDOFBANLIST", NumericReply, "<client> <channel> :End of channel ban list"},
+	RPL_ENDOFWHOWAS:       {RPL_ENDOFWHOWAS, "RPL_ENDOFWHOWAS", NumericReply, "<client> <nick> :End of WHOWAS"},
+	RPL_INFO:              {RPL_INFO, "RPL_INFO", NumericReply, "<client> :<string>"},
+	RPL_MOTD:              {RPL_MOTD, "RPL_MOTD", NumericRegistration, "<client> :<line of the motd>"},
+	RPL_ENDOFINFO:         {RPL_ENDOFINFO, "RPL_ENDOFINFO", NumericReply, "<client> :End of INFO list"},
+	RPL_MOTDSTART:         {RPL_MOTDSTART, "RPL_MOTDSTART", NumericRegistration, "<client> :- <server> Message of the day -"},
+	RPL_ENDOFMOTD:         {RPL_ENDOFMOTD, "RPL_ENDOFMOTD", NumericRegistration, "<client> :End of /MOTD command."},
+	RPL_WHOISHOST:         {RPL_WHOISHOST, "RPL_WHOISHOST", NumericWhois, "<client> <nick> :is connecting from *@<host> <ip>"},
+	RPL_WHOISMODES:        {RPL_WHOISMODES, "RPL_WHOISMODES", NumericWhois, "<client> <nick> :is using modes <modes>"},
+	RPL_YOUREOPER:         {RPL_YOUREOPER, "RPL_YOUREOPER", NumericReply, "<client> :You are now an IRC operator"},
+	RPL_REHASHING:         {RPL_REHASHING, "RPL_REHASHING", NumericReply, "<client> <config file> :Rehashing"},
+	RPL_TIME:              {RPL_TIME, "RPL_TIME", NumericReply, "<client> <server> [<timestamp> [<TS offset>]] :<human-readable time>"},
+	RPL_HOSTHIDDEN:        {RPL_HOSTHIDDEN, "RPL_HOSTHIDDEN", NumericReply, "<client> <host> :is now your displayed host"},
+	ERR_UNKNOWNERROR:      {ERR_UNKNOWNERROR, "ERR_UNKNOWNERROR", NumericError, "<client> <command>{ <subcommand>} :<info>"},
+	ERR_NOSUCHNICK:        {ERR_NOSUCHNICK, "ERR_NOSUCHNICK", NumericError, "<client> <nick> :No such nick/channel"},
+	ERR_NOSUCHSERVER:      {ERR_NOSUCHSERVER, "ERR_NOSUCHSERVER", NumericError, "<client> <server name> :No such server"},
+	ERR_NOSUCHCHANNEL:     {ERR_NOSUCHCHANNEL, "ERR_NOSUCHCHANNEL", NumericError, "<client> <channel> :No such channel"},
+	ERR_CANNOTSENDTOCHAN:  {ERR_CANNOTSENDTOCHAN, "ERR_CANNOTSENDTOCHAN", NumericError, "<client> <channel> :Cannot send to channel"},
+	ERR_TOOMANYCHANNELS:   {ERR_TOOMANYCHANNELS, "ERR_TOOMANYCHANNELS", NumericError, "<client> <channel> :You have joined too many channels"},
+	ERR_WASNOSUCHNICK:     {ERR_WASNOSUCHNICK, "ERR_WASNOSUCHNICK", NumericError, "<client> :There was no such nickname"},
+	ERR_TOOMANYTARGETS:    {ERR_TOOMANYTARGETS, "ERR_TOOMANYTARGETS", NumericError, "<client> <target> :Duplicate recipients. No message delivered"},
+	ERR_NOORIGIN:          {ERR_NOORIGIN, "ERR_NOORIGIN", NumericError, "<client> :No origin specified"},
+	ERR_INVALIDCAPCMD:     {ERR_INVALIDCAPCMD, "ERR_INVALIDCAPCMD", NumericError, "<client> <command> :Invalid CAP command"},
+	ERR_NORECIPIENT:       {ERR_NORECIPIENT, "ERR_NORECIPIENT", NumericError, "<client> :No recipient given (<command>)"},
+	ERR_NOTEXTTOSEND:      {ERR_NOTEXTTOSEND, "ERR_NOTEXTTOSEND", NumericError, "<client> :No text to send"},
+	ERR_INPUTTOOLONG:      {ERR_INPUTTOOLONG, "ERR_INPUTTOOLONG", NumericError, "<client> :Input line was too long"},
+	ERR_UNKNOWNCOMMAND:    {ERR_UNKNOWNCOMMAND, "ERR_UNKNOWNCOMMAND", NumericError, "<client> <command> :Unknown command"},
+	ERR_NOMOTD:            {ERR_NOMOTD, "ERR_NOMOTD", NumericError, "<client> :MOTD File is missing"},
+	ERR_NONICKNAMEGIVEN:   {ERR_NONICKNAMEGIVEN, "ERR_NONICKNAMEGIVEN", NumericError, "<client> :No nickname given"},
+	ERR_ERRONEUSNICKNAME:  {ERR_ERRONEUSNICKNAME, "ERR_ERRONEUSNICKNAME", NumericError, "<client> <nick> :Erroneus nickname"},
+	ERR_NICKNAMEINUSE:     {ERR_NICKNAMEINUSE, "ERR_NICKNAMEINUSE", NumericError, "<client> <nick> :Nickname is already in use"},
+	ERR_NICKCOLLISION:     {ERR_NICKCOLLISION, "ERR_NICKCOLLISION", NumericError, "<client> <nick> :Nickname collision KILL from <user>@<host>"},
+	ERR_UNAVAILRESOURCE:   {ERR_UNAVAILRESOURCE, "ERR_UNAVAILRESOURCE", NumericError, "<client> <nick/channel> :Nick/channel is temporarily unavailable"},
+	ERR_USERNOTINCHANNEL:  {ERR_USERNOTINCHANNEL, "ERR_USERNOTINCHANNEL", NumericError, "<client> <nick> <channel> :They aren't on that channel"},
+	ERR_NOTONCHANNEL:      {ERR_NOTONCHANNEL, "ERR_NOTONCHANNEL", NumericError, "<client> <channel> :You're not on that channel"},
+	ERR_USERONCHANNEL:     {ERR_USERONCHANNEL, "ERR_USERONCHANNEL", NumericError, "<client> <nick> <channel> :is already on channel"},
+	ERR_NOTREGISTERED:     {ERR_NOTREGISTERED, "ERR_NOTREGISTERED", NumericError, "<client> :You have not registered"},
+	ERR_NEEDMOREPARAMS:    {ERR_NEEDMOREPARAMS, "ERR_NEEDMOREPARAMS", NumericError, "<client> <command> :Not enough parameters"},
+	ERR_ALREADYREGISTERED: {ERR_ALREADYREGISTERED, "ERR_ALREADYREGISTERED", NumericError, "<client> :You may not reregister"},
+	ERR_PASSWDMISMATCH:    {ERR_PASSWDMISMATCH, "ERR_PASSWDMISMATCH", NumericError, "<client> :Password incorrect"},
+	ERR_YOUREBANNEDCREEP:  {ERR_YOUREBANNEDCREEP, "ERR_YOUREBANNEDCREEP", NumericError, "<client> :You are banned from this server."},
+	ERR_CHANNELISFULL:     {ERR_CHANNELISFULL, "ERR_CHANNELISFULL", NumericError, "<client> <channel> :Cannot join channel (+l)"},
+	ERR_UNKNOWNMODE:       {ERR_UNKNOWNMODE, "ERR_UNKNOWNMODE", NumericError, "<client> <modechar> :is unknown mode char to me"},
+	ERR_INVITEONLYCHAN:    {ERR_INVITEONLYCHAN, "ERR_INVITEONLYCHAN", NumericError, "<client> <channel> :Cannot join channel (+i)"},
+	ERR_BANNEDFROMCHAN:    {ERR_BANNEDFROMCHAN, "ERR_BANNEDFROMCHAN", NumericError, "<client> <channel> :Cannot join channel (+b)"},
+	ERR_BADCHANNELKEY:     {ERR_BADCHANNELKEY, "ERR_BADCHANNELKEY", NumericError, "<client> <channel> :Cannot join channel (+k)"},
+	ERR_BADCHANMASK:       {ERR_BADCHANMASK, "ERR_BADCHANMASK", NumericError, "<channel> :Bad Channel Mask"},
+	ERR_NEEDREGGEDNICK:    {ERR_NEEDREGGEDNICK, "ERR_NEEDREGGEDNICK", NumericError, "<client> <channel> :Cannot join channel (+r)"},
+	ERR_BANLISTFULL:       {ERR_BANLISTFULL, "ERR_BANLISTFULL", NumericError, "<client> <channel> <modechar> :Channel list is full"},
+	ERR_NOPRIVILEGES:      {ERR_NOPRIVILEGES, "ERR_NOPRIVILEGES", NumericError, "<client> :Permission Denied- You're not an IRC operator"},
+	ERR_CHANOPRIVSNEEDED:  {ERR_CHANOPRIVSNEEDED, "ERR_CHANOPRIVSNEEDED", NumericError, "<client> <channel> :You're not channel operator"},
+	ERR_CANTKILLSERVER:    {ERR_CANTKILLSERVER, "ERR_CANTKILLSERVER", NumericError, "<client> :You cant kill a server!"},
+	ERR_NOOPERHOST:        {ERR_NOOPERHOST, "ERR_NOOPERHOST", NumericError, "<client> :No O-lines for your host"},
+	ERR_UMODEUNKNOWNFLAG:  {ERR_UMODEUNKNOWNFLAG, "ERR_UMODEUNKNOWNFLAG", NumericError, "<client> :Unknown MODE flag"},
+	ERR_USERSDONTMATCH:    {ERR_USERSDONTMATCH, "ERR_USERSDONTMATCH", NumericError, "<client> :Cant change mode for other users"},
+	ERR_HELPNOTFOUND:      {ERR_HELPNOTFOUND, "ERR_HELPNOTFOUND", NumericError, "<client> <subject> :No help available on this topic"},
+	ERR_INVALIDKEY:        {ERR_INVALIDKEY, "ERR_INVALIDKEY", NumericError, "<client> <channel> :Key is not well-formed"},
+	RPL_STARTTLS:          {RPL_STARTTLS, "RPL_STARTTLS", NumericReply, "<client> :STARTTLS successful, proceed with TLS handshake"},
+	RPL_WHOISSECURE:       {RPL_WHOISSECURE, "RPL_WHOISSECURE", NumericWhois, "<client> <nick> :is using a secure connection"},
+	ERR_STARTTLS:          {ERR_STARTTLS, "ERR_STARTTLS", NumericError, "<client> :STARTTLS failed"},
+	ERR_INVALIDMODEPARAM:  {ERR_INVALIDMODEPARAM, "ERR_INVALIDMODEPARAM", NumericError, "<client> <target> <modechar> <parameter> :<description>"},
+	RPL_HELPSTART:         {RPL_HELPSTART, "RPL_HELPSTART", NumericReply, "<client> <subject> :<first line of help section>"},
+	RPL_HELPTXT:           {RPL_HELPTXT, "RPL_HELPTXT", NumericReply, "<client> <subject> :<line of help text>"},
+	RPL_ENDOFHELP:         {RPL_ENDOFHELP, "RPL_ENDOFHELP", NumericReply, "<client> <subject> :<last line of help text>"},
+	ERR_NOPRIVS:           {ERR_NOPRIVS, "ERR_NOPRIVS", NumericError, "<client> <priv> :Insufficient oper privileges."},
+	RPL_MONONLINE:         {RPL_MONONLINE, "RPL_MONONLINE", NumericReply, "<client> :<nick>[!<user>@<host>]{,<nick>[!<user>@<host>]}"},
+	RPL_MONOFFLINE:        {RPL_MONOFFLINE, "RPL_MONOFFLINE", NumericReply, "<client> :<nick>{,<nick>}"},
+	RPL_MONLIST:           {RPL_MONLIST, "RPL_MONLIST", NumericReply, "<client> :<nick>{,<nick>}"},
+	RPL_ENDOFMONLIST:      {RPL_ENDOFMONLIST, "RPL_ENDOFMONLIST", NumericReply, "<client> :End of MONITOR list"},
+	ERR_MONLISTFULL:       {ERR_MONLISTFULL, "ERR_MONLISTFULL", NumericError, "<client> <limit> <nicks> :Monitor list is full."},
+	RPL_LOGGEDIN:          {RPL_LOGGEDIN, "RPL_LOGGEDIN", NumericReply, "<client> <nick>!<user>@<host> <account> :You are now logged in as <account>"},
+	RPL_LOGGEDOUT:         {RPL_LOGGEDOUT, "RPL_LOGGEDOUT", NumericReply, "<client> <nick>!<user>@<host> :You are now logged out"},
+	ERR_NICKLOCKED:        {ERR_NICKLOCKED, "ERR_NICKLOCKED", NumericError, "<client> :You must use a nick assigned to you"},
+	RPL_SASLSUCCESS:       {RPL_SASLSUCCESS, "RPL_SASLSUCCESS", NumericReply, "<client> :SASL authentication successful"},
+	ERR_SASLFAIL:          {ERR_SASLFAIL, "ERR_SASLFAIL", NumericError, "<client> :SASL authentication failed"},
+	ERR_SASLTOOLONG:       {ERR_SASLTOOLONG, "ERR_SASLTOOLONG", NumericError, "<client> :SASL message too long"},
+	ERR_SASLABORTED:       {ERR_SASLABORTED, "ERR_SASLABORTED", NumericError, "<client> :SASL authentication aborted"},
+	ERR_SASLALREADY:       {ERR_SASLALREADY, "ERR_SASLALREADY", NumericError, "<client> :You have already authenticated using SASL"},
+	RPL_SASLMECHS:         {RPL_SASLMECHS, "RPL_SASLMECHS", NumericReply, "<client> <mechanisms> :are available SASL mechanisms"},
+}
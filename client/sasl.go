@@ -0,0 +1,290 @@
+package client
+
+import (
+	"encoding/base64"
+	"strings"
+	"time"
+
+	sasl "github.com/emersion/go-sasl"
+	"github.com/fluffle/goirc/logging"
+)
+
+// saslCap is the IRCv3 capability used for SASL authentication.
+const saslCap = "sasl"
+
+// saslChunkLen is the maximum length of the base64 data sent in a single
+// AUTHENTICATE line. Longer payloads are split into chunks of this size.
+const saslChunkLen = 400
+
+// SASLSUCCESS and SASLFAILURE events are dispatched with the outcome of
+// SASL authentication. For SASLSUCCESS, Line.Args contains the mechanism
+// used and the account we logged in to, which is also available from
+// Conn.Account. For SASLFAILURE, Line.Args contains the reason.
+const (
+	SASLSUCCESS = "SASLSUCCESS"
+	SASLFAILURE = "SASLFAILURE"
+)
+
+// saslState keeps track of SASL authentication while registering. It is
+// only used by handlers, which run one line at a time, so needs no lock.
+type saslState struct {
+	// The mechanisms still to try, and the one being tried.
+	clients []sasl.Client
+	client  sasl.Client
+	mech    string
+	active  bool
+	// The initial response, to send when the server is ready for it.
+	ir []byte
+	// Chunks of the current challenge received so far.
+	buf strings.Builder
+	// The mechanisms the server supports, if it has told us.
+	mechs []string
+}
+
+// allowed returns true if the server supports mech, or hasn't said which
+// mechanisms it supports.
+func (s *saslState) allowed(mech string) bool {
+	if len(s.mechs) == 0 {
+		return true
+	}
+	for _, m := range s.mechs {
+		if strings.EqualFold(m, mech) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseMechs sets the mechanisms the server supports from a comma
+// separated list.
+func (s *saslState) parseMechs(mechs string) {
+	s.mechs = nil
+	if mechs != "" {
+		s.mechs = strings.Split(mechs, ",")
+	}
+}
+
+// saslClients returns the configured SASL mechanisms, in the order they
+// should be tried.
+func (conn *Conn) saslClients() []sasl.Client {
+	var clients []sasl.Client
+	if conn.cfg.Sasl != nil {
+		clients = append(clients, conn.cfg.Sasl)
+	}
+	for _, c := range conn.cfg.SaslMechanisms {
+		if c != nil {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// saslStart starts SASL authentication once the sasl capability has been
+// acked. It returns false if there are no mechanisms to try.
+func (conn *Conn) saslStart() bool {
+	clients := conn.saslClients()
+	if len(clients) == 0 {
+		return false
+	}
+	conn.sasl = saslState{clients: clients}
+	// With CAP LS 302, the server lists the mechanisms it supports.
+	conn.sasl.parseMechs(conn.CapabilityValue(saslCap))
+	if !conn.saslNext() {
+		conn.saslFinish(false, "no supported SASL mechanisms")
+		// saslFinish has already sent CAP END or QUIT.
+		return true
+	}
+	return true
+}
+
+// saslNext starts authenticating with the next mechanism that the server
+// supports. It returns false if there are none left. Each client's Start
+// is called at most once per attempt, as it may have side effects, so
+// the mechanism a client uses isn't known until it is its turn.
+func (conn *Conn) saslNext() bool {
+	s := &conn.sasl
+	for len(s.clients) > 0 {
+		c := s.clients[0]
+		s.clients = s.clients[1:]
+		mech, ir, err := c.Start()
+		if err != nil {
+			logging.Warn("irc.sasl(): failed to start SASL authentication: %v", err)
+			continue
+		}
+		if !s.allowed(mech) {
+			logging.Debug("irc.sasl(): server doesn't support %s", mech)
+			continue
+		}
+		s.client, s.mech, s.ir, s.active = c, mech, ir, true
+		s.buf.Reset()
+		conn.Authenticate(mech)
+		return true
+	}
+	s.client, s.mech, s.active = nil, "", false
+	return false
+}
+
+// saslFinish ends SASL authentication, dispatches the outcome and either
+// ends capability negotiation so registration can complete, or QUITs if
+// authentication failed and is required.
+func (conn *Conn) saslFinish(ok bool, reason string) {
+	s := &conn.sasl
+	ev := &Line{Cmd: SASLSUCCESS, Args: []string{s.mech, conn.Account()}, Time: time.Now()}
+	if !ok {
+		logging.Warn("irc.sasl(): SASL authentication failed: %s", reason)
+		ev = &Line{Cmd: SASLFAILURE, Args: []string{reason}, Time: ev.Time}
+	}
+	*s = saslState{}
+	conn.dispatch(ev)
+	if !ok && conn.cfg.SaslRequired {
+		conn.Quit("SASL authentication failed")
+		return
+	}
+	conn.endNegotiation()
+}
+
+// saslFailed moves on to the next mechanism after the current one fails,
+// or finishes if there are none left.
+func (conn *Conn) saslFailed(reason string) {
+	if !conn.sasl.active {
+		return
+	}
+	logging.Info("irc.sasl(): %s authentication failed: %s", conn.sasl.mech, reason)
+	if !conn.saslNext() {
+		conn.saslFinish(false, reason)
+	}
+}
+
+// saslSend sends a SASL response, base64 encoded and split into chunks.
+// A response that is empty or fills the last chunk exactly is followed
+// by "+".
+func (conn *Conn) saslSend(data []byte) {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) >= saslChunkLen {
+		conn.Authenticate(enc[:saslChunkLen])
+		enc = enc[saslChunkLen:]
+	}
+	if enc == "" {
+		enc = "+"
+	}
+	conn.Authenticate(enc)
+}
+
+// Handler for SASL authentication
+//
+//	AUTHENTICATE <base64 challenge chunk>|+
+func (conn *Conn) h_AUTHENTICATE(line *Line) {
+	s := &conn.sasl
+	if !s.active || len(line.Args) == 0 {
+		return
+	}
+	chunk := line.Args[0]
+	if chunk != "+" {
+		s.buf.WriteString(chunk)
+	}
+	if len(chunk) == saslChunkLen {
+		// There's more to come.
+		return
+	}
+
+	if s.ir != nil {
+		// The server is ready for our initial response.
+		conn.saslSend(s.ir)
+		s.ir = nil
+		s.buf.Reset()
+		return
+	}
+
+	challenge, err := base64.StdEncoding.DecodeString(s.buf.String())
+	s.buf.Reset()
+	if err != nil {
+		logging.Error("irc.sasl(): failed to decode SASL challenge: %v", err)
+		conn.Authenticate("*")
+		return
+	}
+	response, err := s.client.Next(challenge)
+	if err != nil {
+		logging.Error("irc.sasl(): failed to generate response for SASL challenge: %v", err)
+		conn.Authenticate("*")
+		return
+	}
+	conn.saslSend(response)
+}
+
+// Account returns the services account the client is logged in to, as
+// reported by RPL_LOGGEDIN, or "" if it isn't logged in.
+func (conn *Conn) Account() string {
+	acct, _ := conn.account.Load().(string)
+	return acct
+}
+
+// Handler for RPL_LOGGEDIN.
+//
+//	:<server> 900 <me> <nick>!<user>@<host> <account> :You are now logged in as <account>
+func (conn *Conn) h_900(line *Line) {
+	if !line.argslen(2) {
+		return
+	}
+	conn.account.Store(line.Args[2])
+	if conn.st != nil {
+		conn.setAccount(conn.Me().Nick, line.Args[2])
+	}
+}
+
+// Handler for RPL_LOGGEDOUT.
+func (conn *Conn) h_901(line *Line) {
+	conn.account.Store("")
+	if conn.st != nil {
+		conn.setAccount(conn.Me().Nick, "")
+	}
+}
+
+// Handler for ERR_NICKLOCKED.
+func (conn *Conn) h_902(line *Line) {
+	if conn.sasl.active {
+		conn.saslFinish(false, line.Text())
+	}
+}
+
+// Handler for RPL_SASLSUCCESS.
+func (conn *Conn) h_903(line *Line) {
+	if conn.sasl.active {
+		conn.saslFinish(true, "")
+	}
+}
+
+// Handler for ERR_SASLFAIL and ERR_SASLTOOLONG, which try the next
+// mechanism.
+func (conn *Conn) h_904(line *Line) {
+	conn.saslFailed(line.Text())
+}
+
+// Handler for ERR_SASLABORTED.
+func (conn *Conn) h_906(line *Line) {
+	if conn.sasl.active {
+		conn.saslFinish(false, line.Text())
+	}
+}
+
+// Handler for ERR_SASLALREADY.
+func (conn *Conn) h_907(line *Line) {
+	if conn.sasl.active {
+		conn.saslFinish(true, "")
+	}
+}
+
+// Handler for RPL_SASLMECHS, sent with ERR_SASLFAIL when the server
+// doesn't support the mechanism we asked for.
+//
+//	:<server> 908 <me> <mechanisms> :are available SASL mechanisms
+func (conn *Conn) h_908(line *Line) {
+	if !line.argslen(1) || !conn.sasl.active {
+		return
+	}
+	conn.sasl.parseMechs(line.Args[1])
+	// If there's another mechanism to try, the ERR_SASLFAIL that follows
+	// moves on to it. Otherwise, there's no need to wait.
+	if len(conn.sasl.clients) == 0 {
+		conn.saslFinish(false, "SASL mechanism not supported, supported mechanisms are: "+line.Args[1])
+	}
+}
@@ -1,8 +1,14 @@
 package client
 
 import (
-	"github.com/emersion/go-sasl"
+	"encoding/base64"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/emersion/go-sasl"
+	"github.com/fluffle/goirc/state"
 )
 
 func TestSaslPlainSuccessWorkflow(t *testing.T) {
@@ -13,7 +19,7 @@ func TestSaslPlainSuccessWorkflow(t *testing.T) {
 	c.Config().EnableCapabilityNegotiation = true
 
 	c.h_REGISTER(&Line{Cmd: REGISTER})
-	s.nc.Expect("CAP LS")
+	s.nc.Expect("CAP LS 302")
 	s.nc.Expect("NICK test")
 	s.nc.Expect("USER test 12 * :Testing IRC")
 	s.nc.Send("CAP * LS :sasl foobar")
@@ -34,7 +40,7 @@ func TestSaslPlainWrongPassword(t *testing.T) {
 	c.Config().EnableCapabilityNegotiation = true
 
 	c.h_REGISTER(&Line{Cmd: REGISTER})
-	s.nc.Expect("CAP LS")
+	s.nc.Expect("CAP LS 302")
 	s.nc.Expect("NICK test")
 	s.nc.Expect("USER test 12 * :Testing IRC")
 	s.nc.Send("CAP * LS :sasl foobar")
@@ -55,7 +61,7 @@ func TestSaslExternalSuccessWorkflow(t *testing.T) {
 	c.Config().EnableCapabilityNegotiation = true
 
 	c.h_REGISTER(&Line{Cmd: REGISTER})
-	s.nc.Expect("CAP LS")
+	s.nc.Expect("CAP LS 302")
 	s.nc.Expect("NICK test")
 	s.nc.Expect("USER test 12 * :Testing IRC")
 	s.nc.Send("CAP * LS :sasl foobar")
@@ -76,7 +82,7 @@ func TestSaslNoSaslCap(t *testing.T) {
 	c.Config().EnableCapabilityNegotiation = true
 
 	c.h_REGISTER(&Line{Cmd: REGISTER})
-	s.nc.Expect("CAP LS")
+	s.nc.Expect("CAP LS 302")
 	s.nc.Expect("NICK test")
 	s.nc.Expect("USER test 12 * :Testing IRC")
 	s.nc.Send("CAP * LS :foobar")
@@ -91,7 +97,7 @@ func TestSaslUnsupportedMechanism(t *testing.T) {
 	c.Config().EnableCapabilityNegotiation = true
 
 	c.h_REGISTER(&Line{Cmd: REGISTER})
-	s.nc.Expect("CAP LS")
+	s.nc.Expect("CAP LS 302")
 	s.nc.Expect("NICK test")
 	s.nc.Expect("USER test 12 * :Testing IRC")
 	s.nc.Send("CAP * LS :sasl foobar")
@@ -101,3 +107,257 @@ func TestSaslUnsupportedMechanism(t *testing.T) {
 	s.nc.Send("908 test external :are available SASL mechanisms")
 	s.nc.Expect("CAP END")
 }
+
+// saslEvents collects SASLSUCCESS and SASLFAILURE events.
+func saslEvents(t *testing.T, c *Conn) func(cmd string, args ...string) {
+	lines := make(chan *Line, 5)
+	save := func(_ *Conn, l *Line) { lines <- l.Copy() }
+	c.HandleFunc(SASLSUCCESS, save)
+	c.HandleFunc(SASLFAILURE, save)
+	return func(cmd string, args ...string) {
+		t.Helper()
+		select {
+		case l := <-lines:
+			if l.Cmd != cmd || !reflect.DeepEqual(l.Args, args) {
+				t.Errorf("Expected %s %q, got %s %q", cmd, args, l.Cmd, l.Args)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for %s.", cmd)
+		}
+	}
+}
+
+func TestSaslSuccessAccount(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	expect := saslEvents(t, c)
+
+	c.Config().Sasl = sasl.NewPlainClient("", "example", "password")
+	c.Config().EnableCapabilityNegotiation = true
+
+	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
+	s.nc.Expect("NICK test")
+	s.nc.Expect("USER test 12 * :Testing IRC")
+	s.nc.Send("CAP * LS :sasl=PLAIN,EXTERNAL")
+	s.nc.Expect("CAP REQ :sasl")
+	s.nc.Send("CAP * ACK :sasl")
+	s.nc.Expect("AUTHENTICATE PLAIN")
+	s.nc.Send("AUTHENTICATE +")
+	s.nc.Expect("AUTHENTICATE AGV4YW1wbGUAcGFzc3dvcmQ=")
+
+	me := &state.Nick{Nick: "test"}
+	s.st.EXPECT().Me().Return(me).AnyTimes()
+	s.st.EXPECT().GetNick("test").Return(me)
+	s.st.EXPECT().NickAccount("test", "example")
+	s.nc.Send(":irc.server.org 900 test test!test@host example :You are now logged in as example")
+	s.nc.Send(":irc.server.org 903 test :SASL authentication successful")
+	expect(SASLSUCCESS, "PLAIN", "example")
+	s.nc.Expect("CAP END")
+	if acct := c.Account(); acct != "example" {
+		t.Errorf("Account() = %q, want %q", acct, "example")
+	}
+
+	// A late failure after success is ignored.
+	s.nc.Send(":irc.server.org 904 test :SASL authentication failed")
+	s.nc.ExpectNothing()
+
+	// As is sasl being re-enabled after registration.
+	s.nc.Send("CAP test DEL :sasl")
+	s.nc.Send("CAP test NEW :sasl")
+	s.nc.Expect("CAP REQ :sasl")
+	s.nc.Send("CAP test ACK :sasl")
+	s.nc.ExpectNothing()
+}
+
+func TestSaslChunkedResponse(t *testing.T) {
+	for _, n := range []int{291, 320} {
+		c, s := setUp(t)
+		// PLAIN sends "\0example\0password", so a 291 character password
+		// makes a 300 byte response, exactly 400 bytes once encoded.
+		pass := strings.Repeat("x", n)
+		c.Config().Sasl = sasl.NewPlainClient("", "example", pass)
+		c.Config().EnableCapabilityNegotiation = true
+		enc := base64Of("\x00example\x00" + pass)
+
+		c.h_REGISTER(&Line{Cmd: REGISTER})
+		s.nc.Expect("CAP LS 302")
+		s.nc.Expect("NICK test")
+		s.nc.Expect("USER test 12 * :Testing IRC")
+		s.nc.Send("CAP * LS :sasl")
+		s.nc.Expect("CAP REQ :sasl")
+		s.nc.Send("CAP * ACK :sasl")
+		s.nc.Expect("AUTHENTICATE PLAIN")
+		s.nc.Send("AUTHENTICATE +")
+		s.nc.Expect("AUTHENTICATE " + enc[:400])
+		if len(enc) == 400 {
+			s.nc.Expect("AUTHENTICATE +")
+		} else {
+			s.nc.Expect("AUTHENTICATE " + enc[400:])
+		}
+		s.nc.Send(":irc.server.org 903 test :SASL authentication successful")
+		s.nc.Expect("CAP END")
+		s.tearDown()
+	}
+}
+
+func TestSaslChunkedChallenge(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+
+	challenge := strings.Repeat("y", 300)
+	client := &recordingClient{}
+	c.Config().Sasl = client
+	c.Config().EnableCapabilityNegotiation = true
+
+	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
+	s.nc.Expect("NICK test")
+	s.nc.Expect("USER test 12 * :Testing IRC")
+	s.nc.Send("CAP * LS :sasl")
+	s.nc.Expect("CAP REQ :sasl")
+	s.nc.Send("CAP * ACK :sasl")
+	s.nc.Expect("AUTHENTICATE TEST")
+	enc := base64Of(challenge)
+	s.nc.Send("AUTHENTICATE " + enc)
+	s.nc.ExpectNothing()
+	s.nc.Send("AUTHENTICATE +")
+	s.nc.Expect("AUTHENTICATE b2s=")
+	if len(client.challenges) != 1 || client.challenges[0] != challenge {
+		t.Errorf("Challenge not reassembled: %q", client.challenges)
+	}
+	s.nc.Send(":irc.server.org 903 test :SASL authentication successful")
+	s.nc.Expect("CAP END")
+}
+
+func TestSaslMechanismFallback(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	expect := saslEvents(t, c)
+
+	c.Config().Sasl = sasl.NewExternalClient("")
+	c.Config().SaslMechanisms = []sasl.Client{
+		NewScramSHA256Client("example", "password"),
+		sasl.NewPlainClient("", "example", "password"),
+	}
+	c.Config().EnableCapabilityNegotiation = true
+
+	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
+	s.nc.Expect("NICK test")
+	s.nc.Expect("USER test 12 * :Testing IRC")
+	s.nc.Send("CAP * LS :sasl")
+	s.nc.Expect("CAP REQ :sasl")
+	s.nc.Send("CAP * ACK :sasl")
+	s.nc.Expect("AUTHENTICATE EXTERNAL")
+	s.nc.Send(":irc.server.org 904 test :SASL authentication failed")
+	s.nc.Expect("AUTHENTICATE SCRAM-SHA-256")
+	// The server only supports PLAIN, so SCRAM-SHA-256 is skipped.
+	s.nc.Send(":irc.server.org 908 test PLAIN :are available SASL mechanisms")
+	s.nc.Send(":irc.server.org 904 test :SASL authentication failed")
+	s.nc.Expect("AUTHENTICATE PLAIN")
+	s.nc.Send("AUTHENTICATE +")
+	s.nc.Expect("AUTHENTICATE AGV4YW1wbGUAcGFzc3dvcmQ=")
+	s.nc.Send(":irc.server.org 904 test :SASL authentication failed")
+	expect(SASLFAILURE, "SASL authentication failed")
+	s.nc.Expect("CAP END")
+}
+
+func TestSaslMechanismsFromCapLS(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	expect := saslEvents(t, c)
+
+	c.Config().Sasl = sasl.NewExternalClient("")
+	c.Config().EnableCapabilityNegotiation = true
+
+	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
+	s.nc.Expect("NICK test")
+	s.nc.Expect("USER test 12 * :Testing IRC")
+	s.nc.Send("CAP * LS :sasl=PLAIN,SCRAM-SHA-256")
+	s.nc.Expect("CAP REQ :sasl")
+	s.nc.Send("CAP * ACK :sasl")
+	expect(SASLFAILURE, "no supported SASL mechanisms")
+	s.nc.Expect("CAP END")
+}
+
+func TestSaslRequired(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	expect := saslEvents(t, c)
+
+	c.Config().Sasl = sasl.NewPlainClient("", "example", "password")
+	c.Config().SaslRequired = true
+	c.Config().EnableCapabilityNegotiation = true
+
+	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
+	s.nc.Expect("NICK test")
+	s.nc.Expect("USER test 12 * :Testing IRC")
+	s.nc.Send("CAP * LS :sasl")
+	s.nc.Expect("CAP REQ :sasl")
+	s.nc.Send("CAP * ACK :sasl")
+	s.nc.Expect("AUTHENTICATE PLAIN")
+	s.nc.Send("AUTHENTICATE +")
+	s.nc.Expect("AUTHENTICATE AGV4YW1wbGUAcGFzc3dvcmQ=")
+	s.nc.Send(":irc.server.org 902 test :You must use a nick assigned to you")
+	expect(SASLFAILURE, "You must use a nick assigned to you")
+	s.nc.Expect("QUIT :SASL authentication failed")
+}
+
+func TestSaslStartOnce(t *testing.T) {
+	c, s := setUp(t)
+	defer s.tearDown()
+	expect := saslEvents(t, c)
+
+	clients := []*recordingClient{{mech: "ONE"}, {mech: "TWO"}, {mech: "THREE"}}
+	c.Config().SaslMechanisms = []sasl.Client{clients[0], clients[1], clients[2]}
+	c.Config().EnableCapabilityNegotiation = true
+
+	c.h_REGISTER(&Line{Cmd: REGISTER})
+	s.nc.Expect("CAP LS 302")
+	s.nc.Expect("NICK test")
+	s.nc.Expect("USER test 12 * :Testing IRC")
+	s.nc.Send("CAP * LS :sasl")
+	s.nc.Expect("CAP REQ :sasl")
+	s.nc.Send("CAP * ACK :sasl")
+	s.nc.Expect("AUTHENTICATE ONE")
+	s.nc.Send(":irc.server.org 908 test THREE :are available SASL mechanisms")
+	s.nc.ExpectNothing()
+	s.nc.Send(":irc.server.org 904 test :SASL authentication failed")
+	s.nc.Expect("AUTHENTICATE THREE")
+	s.nc.Send(":irc.server.org 904 test :SASL authentication failed")
+	expect(SASLFAILURE, "SASL authentication failed")
+	s.nc.Expect("CAP END")
+	for i, client := range clients {
+		if client.starts != 1 {
+			t.Errorf("Client %d started %d times.", i, client.starts)
+		}
+	}
+}
+
+// recordingClient is a SASL mechanism that records the challenges it is
+// sent and replies "ok" to them.
+type recordingClient struct {
+	mech       string
+	starts     int
+	challenges []string
+}
+
+func (c *recordingClient) Start() (string, []byte, error) {
+	c.starts++
+	if c.mech == "" {
+		return "TEST", nil, nil
+	}
+	return c.mech, nil, nil
+}
+
+func (c *recordingClient) Next(challenge []byte) ([]byte, error) {
+	c.challenges = append(c.challenges, string(challenge))
+	return []byte("ok"), nil
+}
+
+func base64Of(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
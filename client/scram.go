@@ -0,0 +1,178 @@
+package client
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	sasl "github.com/emersion/go-sasl"
+)
+
+// scramSHA256 is the name of the SCRAM-SHA-256 SASL mechanism.
+const scramSHA256 = "SCRAM-SHA-256"
+
+// scramMaxIter is the largest iteration count we accept from the server,
+// as a huge one would stall the handler computing the proof. RFC 7677
+// recommends at least 4096.
+const scramMaxIter = 100000
+
+// scramClient implements the client side of SCRAM-SHA-256, as described
+// in RFC 5802 and RFC 7677. Channel binding is not supported.
+type scramClient struct {
+	username, password string
+	// Generates the client nonce; replaceable for testing.
+	nonce func() (string, error)
+
+	step        int
+	clientNonce string
+	clientFirst string
+	serverSig   []byte
+}
+
+// NewScramSHA256Client returns a sasl.Client that authenticates as
+// username using the SCRAM-SHA-256 mechanism, which doesn't send the
+// password to the server. Pass it in Config.Sasl or Config.SaslMechanisms.
+//
+// The password is used as is, without SASLprep normalisation, so non-ASCII
+// passwords must already be in the form the server expects.
+func NewScramSHA256Client(username, password string) sasl.Client {
+	return &scramClient{username: username, password: password, nonce: scramNonce}
+}
+
+// scramNonce returns a random printable nonce.
+func scramNonce() (string, error) {
+	b := make([]byte, 18)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawStdEncoding.EncodeToString(b), nil
+}
+
+// scramEscape escapes a username for use in a SCRAM message.
+var scramEscape = strings.NewReplacer("=", "=3D", ",", "=2C")
+
+func (c *scramClient) Start() (string, []byte, error) {
+	nonce, err := c.nonce()
+	if err != nil {
+		return "", nil, err
+	}
+	c.step = 0
+	c.clientNonce = nonce
+	c.clientFirst = "n=" + scramEscape.Replace(c.username) + ",r=" + nonce
+	c.serverSig = nil
+	// No channel binding and no authzid.
+	return scramSHA256, []byte("n,," + c.clientFirst), nil
+}
+
+func (c *scramClient) Next(challenge []byte) ([]byte, error) {
+	c.step++
+	switch c.step {
+	case 1:
+		return c.clientFinal(string(challenge))
+	case 2:
+		return nil, c.verify(string(challenge))
+	}
+	return nil, errors.New("scram: unexpected server challenge")
+}
+
+// scramAttrs parses a comma separated list of key=value attributes.
+func scramAttrs(msg string) (map[byte]string, error) {
+	attrs := make(map[byte]string)
+	for _, attr := range strings.Split(msg, ",") {
+		if len(attr) < 2 || attr[1] != '=' {
+			return nil, fmt.Errorf("scram: malformed attribute %q", attr)
+		}
+		attrs[attr[0]] = attr[2:]
+	}
+	return attrs, nil
+}
+
+// clientFinal computes the client's proof from the server-first-message.
+func (c *scramClient) clientFinal(serverFirst string) ([]byte, error) {
+	attrs, err := scramAttrs(serverFirst)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := attrs['e']; ok {
+		return nil, fmt.Errorf("scram: server error: %s", e)
+	}
+	nonce := attrs['r']
+	if !strings.HasPrefix(nonce, c.clientNonce) || len(nonce) == len(c.clientNonce) {
+		return nil, errors.New("scram: invalid server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(attrs['s'])
+	if err != nil || len(salt) == 0 {
+		return nil, errors.New("scram: invalid salt")
+	}
+	iter, err := strconv.Atoi(attrs['i'])
+	if err != nil || iter < 1 {
+		return nil, errors.New("scram: invalid iteration count")
+	}
+	if iter > scramMaxIter {
+		return nil, fmt.Errorf("scram: iteration count %d is too large", iter)
+	}
+
+	// "biws" is the base64 encoding of the GS2 header "n,,".
+	withoutProof := "c=biws,r=" + nonce
+	authMsg := []byte(c.clientFirst + "," + serverFirst + "," + withoutProof)
+
+	salted := pbkdf2SHA256([]byte(c.password), salt, iter)
+	clientKey := hmacSHA256(salted, []byte("Client Key"))
+	storedKey := sha256.Sum256(clientKey)
+	clientSig := hmacSHA256(storedKey[:], authMsg)
+	proof := make([]byte, len(clientKey))
+	for i := range clientKey {
+		proof[i] = clientKey[i] ^ clientSig[i]
+	}
+	serverKey := hmacSHA256(salted, []byte("Server Key"))
+	c.serverSig = hmacSHA256(serverKey, authMsg)
+
+	return []byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)), nil
+}
+
+// verify checks the server's signature in the server-final-message.
+func (c *scramClient) verify(serverFinal string) error {
+	attrs, err := scramAttrs(serverFinal)
+	if err != nil {
+		return err
+	}
+	if e, ok := attrs['e']; ok {
+		return fmt.Errorf("scram: server error: %s", e)
+	}
+	sig, err := base64.StdEncoding.DecodeString(attrs['v'])
+	if err != nil || !hmac.Equal(sig, c.serverSig) {
+		return errors.New("scram: invalid server signature")
+	}
+	return nil
+}
+
+func hmacSHA256(key, data []byte) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// pbkdf2SHA256 implements PBKDF2 with HMAC-SHA-256, producing a single
+// block of output, which is all SCRAM-SHA-256 needs.
+func pbkdf2SHA256(password, salt []byte, iter int) []byte {
+	prf := hmac.New(sha256.New, password)
+	prf.Write(salt)
+	prf.Write([]byte{0, 0, 0, 1})
+	u := prf.Sum(nil)
+	out := bytes.Clone(u)
+	for i := 1; i < iter; i++ {
+		prf.Reset()
+		prf.Write(u)
+		u = prf.Sum(u[:0])
+		for j := range out {
+			out[j] ^= u[j]
+		}
+	}
+	return out
+}
@@ -0,0 +1,58 @@
+package client
+
+import (
+	"testing"
+)
+
+func TestScramSHA256(t *testing.T) {
+	// Test vector from RFC 7677, section 3.
+	c := NewScramSHA256Client("user", "pencil").(*scramClient)
+	c.nonce = func() (string, error) { return "rOprNGfwEbeRWgbNEkqO", nil }
+
+	mech, ir, err := c.Start()
+	if mech != "SCRAM-SHA-256" || string(ir) != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" || err != nil {
+		t.Fatalf("Start() = %q, %q, %v", mech, ir, err)
+	}
+	resp, err := c.Next([]byte("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0," +
+		"s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096"))
+	exp := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0," +
+		"p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="
+	if string(resp) != exp || err != nil {
+		t.Fatalf("Next(server-first) = %q, %v\nwant %q", resp, err, exp)
+	}
+	resp, err = c.Next([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="))
+	if len(resp) != 0 || err != nil {
+		t.Errorf("Next(server-final) = %q, %v", resp, err)
+	}
+}
+
+func TestScramSHA256Errors(t *testing.T) {
+	c := NewScramSHA256Client("us=er,", "pencil").(*scramClient)
+	c.nonce = func() (string, error) { return "abc", nil }
+
+	if _, ir, _ := c.Start(); string(ir) != "n,,n=us=3Der=2C,r=abc" {
+		t.Errorf("Username not escaped: %q", ir)
+	}
+	for _, sf := range []string{
+		"e=unknown-user",
+		"r=xyzdef,s=c2FsdA==,i=4096", // nonce doesn't start with ours
+		"r=abc,s=c2FsdA==,i=4096",    // nonce not extended by server
+		"r=abcdef,s=c2FsdA==,i=0",
+		"r=abcdef,s=c2FsdA==,i=2000000000",
+		"r=abcdef,i=4096",
+		"garbage",
+	} {
+		c.Start()
+		if _, err := c.Next([]byte(sf)); err == nil {
+			t.Errorf("Next(%q) didn't fail", sf)
+		}
+	}
+
+	c.Start()
+	if _, err := c.Next([]byte("r=abcdef,s=c2FsdA==,i=1")); err != nil {
+		t.Fatalf("Next(server-first) failed: %v", err)
+	}
+	if _, err := c.Next([]byte("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4=")); err == nil {
+		t.Errorf("Bad server signature accepted")
+	}
+}
@@ -10,24 +10,90 @@ import (
 )
 
 var stHandlers = map[string]HandlerFunc{
-	"JOIN":  (*Conn).h_JOIN,
-	"KICK":  (*Conn).h_KICK,
-	"MODE":  (*Conn).h_MODE,
-	"NICK":  (*Conn).h_STNICK,
-	"PART":  (*Conn).h_PART,
-	"QUIT":  (*Conn).h_QUIT,
-	"TOPIC": (*Conn).h_TOPIC,
-	"311":   (*Conn).h_311,
-	"324":   (*Conn).h_324,
-	"332":   (*Conn).h_332,
-	"352":   (*Conn).h_352,
-	"353":   (*Conn).h_353,
-	"671":   (*Conn).h_671,
+	"ACCOUNT":         (*Conn).h_ACCOUNT,
+	"AWAY":            (*Conn).h_AWAY,
+	"CHGHOST":         (*Conn).h_CHGHOST,
+	"JOIN":            (*Conn).h_JOIN,
+	"KICK":            (*Conn).h_KICK,
+	"MODE":            (*Conn).h_MODE,
+	"NICK":            (*Conn).h_STNICK,
+	"PART":            (*Conn).h_PART,
+	"QUIT":            (*Conn).h_QUIT,
+	"SETNAME":         (*Conn).h_SETNAME,
+	"TOPIC":           (*Conn).h_TOPIC,
+	RPL_AWAY:          (*Conn).h_301,
+	RPL_UNAWAY:        (*Conn).h_305,
+	RPL_NOWAWAY:       (*Conn).h_306,
+	RPL_WHOISUSER:     (*Conn).h_311,
+	RPL_CHANNELMODEIS: (*Conn).h_324,
+	RPL_WHOISACCOUNT:  (*Conn).h_330,
+	RPL_TOPIC:         (*Conn).h_332,
+	RPL_WHOREPLY:      (*Conn).h_352,
+	RPL_NAMREPLY:      (*Conn).h_353,
+	RPL_WHOSPCRPL:     (*Conn).h_354,
+	RPL_HOSTHIDDEN:    (*Conn).h_396,
+	RPL_WHOISSECURE:   (*Conn).h_671,
+	// Messages from other users may carry their account in a tag.
+	"PRIVMSG": (*Conn).h_ACCOUNTTAG,
+	"NOTICE":  (*Conn).h_ACCOUNTTAG,
+	"TAGMSG":  (*Conn).h_ACCOUNTTAG,
+	"ACTION":  (*Conn).h_ACCOUNTTAG,
+}
+
+// stateCaps are the IRCv3 capabilities requested only when state tracking
+// is enabled, because they change the arguments of lines that handlers
+// may already rely on. They stay enabled if state tracking is disabled
+// later, and are not requested if it is enabled after connecting.
+var stateCaps = []string{extendedJoinCap, multiPrefixCap, userhostInNamesCap}
+
+// The IRCv3 capabilities that add information to NAMES replies. They are
+// only requested with state tracking enabled; see stateCaps.
+// multi-prefix lists all of a nick's privileges on a channel, e.g.
+// "@+nick", and userhost-in-names adds their ident and host, e.g.
+// "nick!user@host".
+const (
+	multiPrefixCap     = "multi-prefix"
+	userhostInNamesCap = "userhost-in-names"
+)
+
+// The privileges used to parse NAMES replies if the server hasn't sent
+// PREFIX in RPL_ISUPPORT, which cover the ones most servers use.
+const (
+	legacyPrefixModes   = "qaohv"
+	legacyPrefixSymbols = "~&@%+"
+)
+
+// whoxToken identifies replies to our WHOX queries, and whoxFields are
+// the fields we ask for: token, channel, user, host, nick, flags, account
+// and real name, which are returned in that order.
+const (
+	whoxToken  = "152"
+	whoxFields = "%tcuhnfar"
+)
+
+// readOnlySTHandlers lists the state tracking handlers that have been
+// checked to read tags only with Line.Tag and not to keep the line or
+// its Args, so they can be passed pooled lines without copying. Check
+// any new handler before adding it here.
+var readOnlySTHandlers = map[string]bool{
+	"ACCOUNT": true, "AWAY": true, "CHGHOST": true, "JOIN": true,
+	"KICK": true, "MODE": true, "NICK": true, "PART": true, "QUIT": true,
+	"SETNAME": true, "TOPIC": true, RPL_AWAY: true, RPL_UNAWAY: true,
+	RPL_NOWAWAY: true, RPL_WHOISUSER: true, RPL_CHANNELMODEIS: true,
+	RPL_WHOISACCOUNT: true, RPL_TOPIC: true, RPL_WHOREPLY: true,
+	RPL_NAMREPLY: true, RPL_WHOSPCRPL: true, RPL_HOSTHIDDEN: true,
+	RPL_WHOISSECURE: true, "PRIVMSG": true, "NOTICE": true, "TAGMSG": true,
+	"ACTION": true,
 }
 
 func (conn *Conn) addSTHandlers() {
 	for n, h := range stHandlers {
-		conn.stRemovers = append(conn.stRemovers, conn.handle(n, h))
+		var hd Handler = h
+		if readOnlySTHandlers[n] {
+			// Checked not to keep the line; see readOnlySTHandlers.
+			hd = ReadOnly(h)
+		}
+		conn.stRemovers = append(conn.stRemovers, conn.handle(n, hd))
 	}
 }
 
@@ -62,18 +128,43 @@ func (conn *Conn) h_JOIN(line *Line) {
 		// topic in 332 on join, so we just need to get the modes
 		conn.Mode(line.Args[0])
 		// sending a WHO for the channel is MUCH more efficient than
-		// triggering a WHOIS on every nick from the 353 handler
-		conn.Who(line.Args[0])
+		// triggering a WHOIS on every nick from the 353 handler, and
+		// not needed at all if NAMES includes idents and hosts
+		if !conn.HasCapability(userhostInNamesCap) {
+			conn.who(line.Args[0])
+		}
 	}
+	// extended-join adds the account and real name to JOINs
+	//   :nick!user@host JOIN <channel> <account> :<real name>
+	extended := conn.HasCapability(extendedJoinCap) && line.argslen(2)
 	if nk == nil {
 		// this is the first we've seen of this nick
+		name := ""
+		if extended {
+			name = line.Args[2]
+		}
 		conn.st.NewNick(line.Nick)
-		conn.st.NickInfo(line.Nick, line.Ident, line.Host, "")
+		conn.st.NickInfo(line.Nick, line.Ident, line.Host, name)
 		// since we don't know much about this nick, ask server for info
-		conn.Who(line.Nick)
+		conn.who(line.Nick)
 	}
 	// this takes care of both nick and channel linking \o/
 	conn.st.Associate(line.Args[0], line.Nick)
+	if extended {
+		conn.setAccount(line.Nick, line.Args[1])
+	} else {
+		conn.h_ACCOUNTTAG(line)
+	}
+}
+
+// who sends a WHO for target, using WHOX to get accounts if the server
+// supports it.
+func (conn *Conn) who(target string) {
+	if conn.isupport.Has("WHOX") {
+		conn.Raw(WHO + " " + target + " " + whoxFields + "," + whoxToken)
+	} else {
+		conn.Who(target)
+	}
 }
 
 // Handle PARTs from channels to maintain state
@@ -192,59 +283,107 @@ func (conn *Conn) h_352(line *Line) {
 	if !line.argslen(6) {
 		return
 	}
-	if idx := strings.Index(line.Args[6], "*"); idx != -1 {
-		conn.st.NickModes(nk.Nick, "+o")
+	conn.whoFlags(nk.Nick, line.Args[6])
+}
+
+// Handle 354 WHOX reply to our WHO query
+//
+//	:<server> 354 <me> 152 <channel> <user> <host> <nick> <flags> <account> :<real name>
+func (conn *Conn) h_354(line *Line) {
+	if !line.argslen(8) || line.Args[1] != whoxToken {
+		return
 	}
-	if idx := strings.Index(line.Args[6], "B"); idx != -1 {
-		conn.st.NickModes(nk.Nick, "+B")
+	nk := conn.st.GetNick(line.Args[5])
+	if nk == nil {
+		logging.Warn("irc.354(): received WHOX reply for unknown nick %s",
+			line.Args[5])
+		return
+	}
+	// The account is "0" if the nick isn't logged in.
+	account := line.Args[7]
+	if account == "0" {
+		account = ""
+	}
+	conn.setAccount(nk.Nick, account)
+	if conn.Me().Equals(nk) {
+		return
+	}
+	conn.st.NickInfo(nk.Nick, line.Args[3], line.Args[4], line.Args[8])
+	conn.whoFlags(nk.Nick, line.Args[6])
+}
+
+// whoFlags updates a nick's modes and away status from the flags in a
+// WHO reply, which start with H if the nick is here or G if it is gone.
+// H and G only say whether the nick is away, not what its modes are.
+func (conn *Conn) whoFlags(nick, flags string) {
+	switch {
+	case strings.HasPrefix(flags, "H"):
+		conn.setAway(nick, false, "")
+	case strings.HasPrefix(flags, "G"):
+		conn.setAway(nick, true, "")
+	}
+	if idx := strings.Index(flags, "*"); idx != -1 {
+		conn.st.NickModes(nick, "+o")
 	}
-	if idx := strings.Index(line.Args[6], "H"); idx != -1 {
-		conn.st.NickModes(nk.Nick, "+i")
+	if idx := strings.Index(flags, "B"); idx != -1 {
+		conn.st.NickModes(nick, "+B")
 	}
 }
 
 // Handle 353 names reply
+//
+//	:<server> 353 <me> = <channel> :[<prefixes>]<nick>[!<user>@<host>] ...
 func (conn *Conn) h_353(line *Line) {
 	if !line.argslen(2) {
 		return
 	}
-	if ch := conn.st.GetChannel(line.Args[2]); ch != nil {
-		nicks := strings.Split(line.Args[len(line.Args)-1], " ")
-		for _, nick := range nicks {
-			// UnrealIRCd's coders are lazy and leave a trailing space
-			if nick == "" {
-				continue
+	ch := conn.st.GetChannel(line.Args[2])
+	if ch == nil {
+		logging.Warn("irc.353(): received NAMES list for unknown channel %s",
+			line.Args[2])
+		return
+	}
+	modes, symbols := legacyPrefixModes, legacyPrefixSymbols
+	if conn.isupport.Has("PREFIX") {
+		modes, symbols = conn.isupport.Prefix()
+	}
+	for _, name := range strings.Split(line.Args[len(line.Args)-1], " ") {
+		// UnrealIRCd's coders are lazy and leave a trailing space
+		if name == "" {
+			continue
+		}
+		// With multi-prefix, there may be more than one prefix.
+		var privs []byte
+		for name != "" {
+			idx := strings.IndexByte(symbols, name[0])
+			if idx == -1 {
+				break
 			}
-			switch c := nick[0]; c {
-			case '~', '&', '@', '%', '+':
-				nick = nick[1:]
-				fallthrough
-			default:
-				if conn.st.GetNick(nick) == nil {
-					// we don't know this nick yet!
-					conn.st.NewNick(nick)
-				}
-				if _, ok := conn.st.IsOn(ch.Name, nick); !ok {
-					// This nick isn't associated with this channel yet!
-					conn.st.Associate(ch.Name, nick)
-				}
-				switch c {
-				case '~':
-					conn.st.ChannelModes(ch.Name, "+q", nick)
-				case '&':
-					conn.st.ChannelModes(ch.Name, "+a", nick)
-				case '@':
-					conn.st.ChannelModes(ch.Name, "+o", nick)
-				case '%':
-					conn.st.ChannelModes(ch.Name, "+h", nick)
-				case '+':
-					conn.st.ChannelModes(ch.Name, "+v", nick)
-				}
+			privs = append(privs, modes[idx])
+			name = name[1:]
+		}
+		// With userhost-in-names, the nick is followed by a userhost.
+		nick, userhost, _ := strings.Cut(name, "!")
+		ident, host, _ := strings.Cut(userhost, "@")
+		if nick == "" {
+			continue
+		}
+		if nk := conn.st.GetNick(nick); nk == nil {
+			// we don't know this nick yet!
+			conn.st.NewNick(nick)
+			if host != "" {
+				conn.st.NickInfo(nick, ident, host, "")
 			}
+		} else if host != "" && nk.Host == "" {
+			conn.st.NickInfo(nick, ident, host, nk.Name)
+		}
+		if _, ok := conn.st.IsOn(ch.Name, nick); !ok {
+			// This nick isn't associated with this channel yet!
+			conn.st.Associate(ch.Name, nick)
+		}
+		for _, m := range privs {
+			conn.st.ChannelModes(ch.Name, "+"+string(m), nick)
 		}
-	} else {
-		logging.Warn("irc.353(): received NAMES list for unknown channel %s",
-			line.Args[2])
 	}
 }
 
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"strings"
+	"time"
+
+	"github.com/fluffle/goirc/logging"
+	"github.com/fluffle/goirc/state"
+)
+
+// The IRCv3 capabilities that tell us when users on our channels change
+// their ident and host with CHGHOST, or their real name with SETNAME.
+const (
+	chghostCap = "chghost"
+	setnameCap = "setname"
+)
+
+// HOSTCHANGED and NAMECHANGED events are dispatched when the state tracker
+// learns that a nick's ident and host or its real name have changed, e.g.
+// because a cloak was applied. Line.Nick is the nick, and Line.Args
+// contains the nick followed by the new ident and host, or the new name.
+const (
+	HOSTCHANGED = "HOSTCHANGED"
+	NAMECHANGED = "NAMECHANGED"
+)
+
+// updateNickInfo applies update to the tracked info for a nick, and
+// dispatches events for anything that changed.
+func (conn *Conn) updateNickInfo(nick string, update func(nk *state.Nick)) {
+	old := conn.st.GetNick(nick)
+	if old == nil {
+		logging.Warn("irc.updateNickInfo(): info change for unknown nick %s", nick)
+		return
+	}
+	nk := *old
+	update(&nk)
+	hostChanged := nk.Ident != old.Ident || nk.Host != old.Host
+	nameChanged := nk.Name != old.Name
+	if !hostChanged && !nameChanged {
+		return
+	}
+	conn.st.NickInfo(nk.Nick, nk.Ident, nk.Host, nk.Name)
+	now := time.Now()
+	if hostChanged {
+		conn.dispatch(&Line{Cmd: HOSTCHANGED, Nick: nk.Nick,
+			Args: []string{nk.Nick, nk.Ident, nk.Host}, Time: now})
+	}
+	if nameChanged {
+		conn.dispatch(&Line{Cmd: NAMECHANGED, Nick: nk.Nick,
+			Args: []string{nk.Nick, nk.Name}, Time: now})
+	}
+}
+
+// Handle CHGHOST messages from chghost
+//
+//	:nick!user@host CHGHOST <new user> <new host>
+func (conn *Conn) h_CHGHOST(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
+	conn.updateNickInfo(line.Nick, func(nk *state.Nick) {
+		nk.Ident, nk.Host = line.Args[0], line.Args[1]
+	})
+}
+
+// Handle SETNAME messages from setname
+//
+//	:nick!user@host SETNAME :<real name>
+func (conn *Conn) h_SETNAME(line *Line) {
+	if len(line.Args) == 0 {
+		return
+	}
+	conn.updateNickInfo(line.Nick, func(nk *state.Nick) {
+		nk.Name = line.Text()
+	})
+}
+
+// Handle 396 reply, our displayed host has changed
+//
+//	:<server> 396 <me> [<user>@]<host> :is now your displayed host
+func (conn *Conn) h_396(line *Line) {
+	if !line.argslen(1) {
+		return
+	}
+	conn.updateNickInfo(conn.Me().Nick, func(nk *state.Nick) {
+		if ident, host, ok := strings.Cut(line.Args[1], "@"); ok {
+			nk.Ident, nk.Host = ident, host
+		} else {
+			nk.Host = line.Args[1]
+		}
+	})
+}
@@ -0,0 +1,109 @@
+package dcc
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+)
+
+// A Chat is an established DCC CHAT session. DCC CHAT is line-oriented:
+// Read and Write pass data through unchanged, while ReadLine and WriteLine
+// deal with the line endings.
+type Chat struct {
+	// The offer that started the chat.
+	Offer *Offer
+
+	nc net.Conn
+	r  *bufio.Reader
+}
+
+func newChat(nc net.Conn, o *Offer) *Chat {
+	return &Chat{Offer: o, nc: nc, r: bufio.NewReader(nc)}
+}
+
+// Read implements io.Reader.
+func (c *Chat) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
+
+// Write implements io.Writer.
+func (c *Chat) Write(p []byte) (int, error) {
+	return c.nc.Write(p)
+}
+
+// Close implements io.Closer, ending the chat.
+func (c *Chat) Close() error {
+	return c.nc.Close()
+}
+
+// ReadLine reads a line from the chat, without the trailing "\r\n" or "\n".
+// If the chat ends without a final line ending, the partial line is
+// returned along with io.EOF.
+func (c *Chat) ReadLine() (string, error) {
+	s, err := c.r.ReadString('\n')
+	return strings.TrimRight(s, "\r\n"), err
+}
+
+// WriteLine writes s to the chat, followed by a "\n".
+func (c *Chat) WriteLine(s string) error {
+	_, err := c.nc.Write([]byte(s + "\n"))
+	return err
+}
+
+// RemoteAddr returns the address of the other end of the chat.
+func (c *Chat) RemoteAddr() net.Addr {
+	return c.nc.RemoteAddr()
+}
+
+// OfferChat offers a DCC CHAT to nick, and waits for them to accept it.
+func (m *Manager) OfferChat(ctx context.Context, nick string) (*Chat, error) {
+	o := &Offer{Type: CHAT, Nick: nick, Name: "chat", Size: -1}
+	if m.cfg.Passive {
+		o.IP, o.Token = m.cfg.PublicIP, token()
+		replies, done := m.expect(nick, 0, o.Token)
+		defer done()
+		m.offer(nick, o)
+		r, err := m.wait(ctx, replies, CHAT)
+		if err != nil {
+			return nil, err
+		}
+		nc, err := m.dial(ctx, r)
+		if err != nil {
+			return nil, err
+		}
+		return newChat(nc, o), nil
+	}
+	ln, ip, err := m.listen()
+	if err != nil {
+		return nil, err
+	}
+	o.IP, o.Port = ip, port(ln)
+	m.offer(nick, o)
+	nc, err := m.accept(ctx, ln)
+	if err != nil {
+		return nil, err
+	}
+	return newChat(nc, o), nil
+}
+
+// AcceptChat accepts a DCC CHAT offer.
+func (m *Manager) AcceptChat(ctx context.Context, o *Offer) (*Chat, error) {
+	if !o.Passive() {
+		nc, err := m.dial(ctx, o)
+		if err != nil {
+			return nil, err
+		}
+		return newChat(nc, o), nil
+	}
+	ln, ip, err := m.listen()
+	if err != nil {
+		return nil, err
+	}
+	m.offer(o.Nick, &Offer{Type: CHAT, Name: o.Name, IP: ip, Port: port(ln), Size: -1, Token: o.Token})
+	nc, err := m.accept(ctx, ln)
+	if err != nil {
+		return nil, err
+	}
+	return newChat(nc, o), nil
+}
@@ -0,0 +1,314 @@
+// Package dcc implements DCC (Direct Client-to-Client) CHAT and file
+// transfers on top of the CTCP support in the client package.
+//
+// A Manager receives DCC offers from a client.Conn and sends offers using
+// Conn.Ctcp. Setting one up looks something like this:
+//
+//	cfg := dcc.NewConfig()
+//	cfg.PublicIP = net.ParseIP("203.0.113.1")
+//	cfg.Allow = []string{"*!*@trusted.example.com"}
+//	cfg.OnOffer = func(m *dcc.Manager, o *dcc.Offer) {
+//		if o.Type != dcc.SEND {
+//			return
+//		}
+//		f, err := os.Create(filepath.Join(dir, o.SafeName()))
+//		if err != nil {
+//			return
+//		}
+//		defer f.Close()
+//		t, _ := m.Receive(context.Background(), o, f, 0)
+//		t.Wait()
+//	}
+//	m := dcc.ForConn(conn, cfg)
+//	defer m.Close()
+//
+// Both active and passive (reverse) DCC are supported: if the Manager is
+// configured to be Passive, it asks the peer to listen for connections,
+// and it will listen for connections when it accepts a passive offer.
+package dcc
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+	"github.com/fluffle/goirc/logging"
+)
+
+var (
+	// ErrNoIP is returned when there is no IP address to advertise in an
+	// offer. Set Config.PublicIP, or listen on a specific address.
+	ErrNoIP = errors.New("dcc: no public IP address to advertise")
+	// ErrTooLarge is returned when a file exceeds Config.MaxSize.
+	ErrTooLarge = errors.New("dcc: file too large")
+	// ErrBadResume is returned when a resume request is not valid.
+	ErrBadResume = errors.New("dcc: bad resume position")
+)
+
+// A Sender sends CTCP messages. It is implemented by *client.Conn.
+type Sender interface {
+	Ctcp(t, ctcp string, arg ...string)
+}
+
+// Config contains options for a Manager. It is recommended that NewConfig
+// is used to create this struct rather than instantiating one directly.
+type Config struct {
+	// Address to listen on for incoming DCC connections. Defaults to ":0",
+	// i.e. a random port on all interfaces.
+	ListenAddr string
+
+	// IP address to advertise in offers. If nil, the IP that the Manager
+	// is listening on is used, which only works if ListenAddr contains a
+	// specific IP address, e.g. "127.0.0.1:0".
+	PublicIP net.IP
+
+	// Make passive offers, which ask the peer to listen for connections.
+	// This is useful if the client is behind a NAT or firewall.
+	Passive bool
+
+	// How long to wait for a peer to connect or reply to an offer, or
+	// acknowledge the end of a file transfer. Defaults to 2m.
+	Timeout time.Duration
+
+	// SEND offers for files larger than MaxSize bytes are dropped, and
+	// transfers are aborted if they exceed it. 0 means no limit.
+	MaxSize int64
+
+	// If not empty, offers are dropped unless their source matches one
+	// of these masks. See client.Hostmask for the mask syntax.
+	Allow []string
+
+	// Called in a new goroutine for each CHAT and SEND offer that passes
+	// the Allow and MaxSize checks. Use AcceptChat or Receive to accept
+	// the offer, or ignore it to reject it.
+	OnOffer func(m *Manager, o *Offer)
+
+	// Called every time data is sent or received during a file transfer.
+	// It should return quickly, as the transfer waits for it.
+	Progress func(t *Transfer)
+}
+
+// NewConfig creates a Config struct containing sensible defaults.
+func NewConfig() *Config {
+	return &Config{
+		ListenAddr: ":0",
+		Timeout:    2 * time.Minute,
+	}
+}
+
+// A Manager handles DCC offers for a single IRC connection.
+type Manager struct {
+	s   Sender
+	cfg *Config
+
+	// Remover for the CTCP handler added by ForConn.
+	remover client.Remover
+
+	// Replies we're waiting for, keyed by pendingKey.
+	mu      sync.Mutex
+	pending map[string]chan *Offer
+}
+
+// New creates a Manager that sends offers with s. Incoming DCC requests
+// must be passed to the Manager's Handle method, which implements
+// client.Handler. If cfg is nil, NewConfig is used.
+func New(s Sender, cfg *Config) *Manager {
+	if cfg == nil {
+		cfg = NewConfig()
+	}
+	return &Manager{
+		s:       s,
+		cfg:     cfg,
+		pending: make(map[string]chan *Offer),
+	}
+}
+
+// ForConn creates a Manager for conn, and adds a handler for incoming
+// DCC requests to it. Call Close to remove the handler.
+func ForConn(conn *client.Conn, cfg *Config) *Manager {
+	m := New(conn, cfg)
+	m.remover = conn.Handle(client.CTCP, m)
+	return m
+}
+
+// Close stops the Manager handling incoming DCC requests. It doesn't
+// affect chats or transfers that are already in progress.
+func (m *Manager) Close() {
+	if m.remover != nil {
+		m.remover.Remove()
+		m.remover = nil
+	}
+}
+
+// Config returns a pointer to the Config struct used by the Manager.
+func (m *Manager) Config() *Config {
+	return m.cfg
+}
+
+// Handle implements client.Handler, processing CTCP DCC requests.
+func (m *Manager) Handle(_ *client.Conn, line *client.Line) {
+	if line.Cmd != client.CTCP || len(line.Args) < 3 ||
+		!strings.EqualFold(line.Args[0], verb) {
+		return
+	}
+	o, err := ParseOffer(line)
+	if err != nil {
+		logging.Warn("dcc: %s from %s: %v", line.Args[2], line.Nick, err)
+		return
+	}
+	switch o.Type {
+	case RESUME, ACCEPT:
+		if !m.reply(o) {
+			logging.Warn("dcc: unexpected %s from %s", o.Type, o.Source())
+		}
+		return
+	}
+	if o.Token != "" && !o.Passive() && m.reply(o) {
+		// A reply to one of our passive offers.
+		return
+	}
+	if !m.allowed(o) {
+		logging.Info("dcc: dropping %s offer from %s", o.Type, o.Source())
+		return
+	}
+	if o.Type == SEND && m.cfg.MaxSize > 0 && o.Size > m.cfg.MaxSize {
+		logging.Info("dcc: dropping %d byte SEND from %s", o.Size, o.Source())
+		return
+	}
+	if m.cfg.OnOffer != nil {
+		go m.cfg.OnOffer(m, o)
+	}
+}
+
+// allowed returns true if the source of the offer matches Config.Allow.
+func (m *Manager) allowed(o *Offer) bool {
+	if len(m.cfg.Allow) == 0 {
+		return true
+	}
+	src := client.Hostmask{Nick: o.Nick, Ident: o.Ident, Host: o.Host}
+	for _, mask := range m.cfg.Allow {
+		if client.NewMask(mask).Match(src) {
+			return true
+		}
+	}
+	return false
+}
+
+// pendingKey identifies the replies to an offer. Replies to passive offers
+// are identified by their token, and RESUME and ACCEPT requests by port.
+func pendingKey(nick string, port int, token string) string {
+	if token == "" {
+		token = strconv.Itoa(port)
+	}
+	return strings.ToLower(nick) + " " + token
+}
+
+// expect registers interest in replies from nick to an offer. The returned
+// function must be called when no more replies are expected.
+func (m *Manager) expect(nick string, port int, token string) (<-chan *Offer, func()) {
+	key := pendingKey(nick, port, token)
+	ch := make(chan *Offer, 4)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.pending[key] = ch
+	return ch, func() {
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		if m.pending[key] == ch {
+			delete(m.pending, key)
+		}
+	}
+}
+
+// reply passes o to whoever is expecting it, returning false if nobody is.
+func (m *Manager) reply(o *Offer) bool {
+	m.mu.Lock()
+	ch, ok := m.pending[pendingKey(o.Nick, o.Port, o.Token)]
+	m.mu.Unlock()
+	if ok {
+		select {
+		case ch <- o:
+		default:
+			logging.Warn("dcc: dropping %s from %s, too many replies", o.Type, o.Source())
+		}
+	}
+	return ok
+}
+
+// wait waits for a reply of type typ on ch.
+func (m *Manager) wait(ctx context.Context, ch <-chan *Offer, typ string) (*Offer, error) {
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+	for {
+		select {
+		case o := <-ch:
+			if o.Type == typ {
+				return o, nil
+			}
+			logging.Warn("dcc: unexpected %s from %s", o.Type, o.Source())
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// offer sends o to nick.
+func (m *Manager) offer(nick string, o *Offer) {
+	m.s.Ctcp(nick, verb, o.String())
+}
+
+// token generates a token for a passive offer.
+func token() string {
+	return strconv.FormatUint(uint64(rand.Uint32()), 10)
+}
+
+// listen starts listening for a DCC connection, and returns the listener
+// and the IP address to advertise.
+func (m *Manager) listen() (net.Listener, net.IP, error) {
+	ln, err := net.Listen("tcp", m.cfg.ListenAddr)
+	if err != nil {
+		return nil, nil, err
+	}
+	ip := m.cfg.PublicIP
+	if ip == nil {
+		ip = ln.Addr().(*net.TCPAddr).IP
+		if ip.IsUnspecified() {
+			ln.Close()
+			return nil, nil, ErrNoIP
+		}
+	}
+	return ln, ip, nil
+}
+
+// port returns the port a listener is listening on.
+func port(ln net.Listener) int {
+	return ln.Addr().(*net.TCPAddr).Port
+}
+
+// accept waits for a single connection to ln, then closes it.
+func (m *Manager) accept(ctx context.Context, ln net.Listener) (net.Conn, error) {
+	defer ln.Close()
+	ctx, cancel := context.WithTimeout(ctx, m.cfg.Timeout)
+	defer cancel()
+	go func() {
+		<-ctx.Done()
+		ln.Close()
+	}()
+	nc, err := ln.Accept()
+	if err != nil && ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return nc, err
+}
+
+// dial connects to the address in o.
+func (m *Manager) dial(ctx context.Context, o *Offer) (net.Conn, error) {
+	d := net.Dialer{Timeout: m.cfg.Timeout}
+	return d.DialContext(ctx, "tcp", o.Addr())
+}
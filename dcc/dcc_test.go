@@ -0,0 +1,247 @@
+package dcc
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fluffle/goirc/client"
+)
+
+// wire delivers CTCPs sent by one Manager to another, as if via a server.
+type wire struct {
+	from string
+	to   *Manager
+}
+
+func (w *wire) Ctcp(t, ctcp string, arg ...string) {
+	w.to.Handle(nil, client.ParseLine(fmt.Sprintf(":%s!ident@host.example.com PRIVMSG %s :\001%s %s\001",
+		w.from, t, ctcp, strings.Join(arg, " "))))
+}
+
+// setUp creates Managers for nicks "a" and "b" connected by wires, that
+// listen on loopback. Offers received by b are sent to the returned channel.
+func setUp(t *testing.T, passive bool) (a, b *Manager, offers chan *Offer) {
+	cfg := func() *Config {
+		cfg := NewConfig()
+		cfg.ListenAddr = "127.0.0.1:0"
+		cfg.Timeout = time.Second
+		cfg.Passive = passive
+		return cfg
+	}
+	wa, wb := &wire{from: "a"}, &wire{from: "b"}
+	a, b = New(wa, cfg()), New(wb, cfg())
+	wa.to, wb.to = b, a
+	offers = make(chan *Offer, 1)
+	b.cfg.OnOffer = func(m *Manager, o *Offer) {
+		if m != b {
+			t.Errorf("OnOffer called with wrong Manager.")
+		}
+		offers <- o
+	}
+	return a, b, offers
+}
+
+func getOffer(t *testing.T, offers chan *Offer) *Offer {
+	t.Helper()
+	select {
+	case o := <-offers:
+		return o
+	case <-time.After(time.Second):
+		t.Fatalf("No offer received.")
+	}
+	return nil
+}
+
+func TestChat(t *testing.T) {
+	for _, passive := range []bool{false, true} {
+		t.Run(fmt.Sprintf("passive=%t", passive), func(t *testing.T) {
+			a, b, offers := setUp(t, passive)
+			ctx := context.Background()
+
+			var ca *Chat
+			var err error
+			offered := make(chan struct{})
+			go func() {
+				defer close(offered)
+				ca, err = a.OfferChat(ctx, "b")
+			}()
+			o := getOffer(t, offers)
+			if o.Type != CHAT || o.Nick != "a" || o.Passive() != passive {
+				t.Errorf("Unexpected offer: %#v", o)
+			}
+			cb, berr := b.AcceptChat(ctx, o)
+			<-offered
+			if err != nil || berr != nil {
+				t.Fatalf("Chat not established: %v, %v", err, berr)
+			}
+			defer ca.Close()
+			defer cb.Close()
+
+			if err := ca.WriteLine("hello"); err != nil {
+				t.Errorf("WriteLine returned %v", err)
+			}
+			cb.Write([]byte("hi there\r\n"))
+			if s, err := cb.ReadLine(); s != "hello" || err != nil {
+				t.Errorf("ReadLine = %q, %v", s, err)
+			}
+			if s, err := ca.ReadLine(); s != "hi there" || err != nil {
+				t.Errorf("ReadLine = %q, %v", s, err)
+			}
+		})
+	}
+}
+
+func TestChatTimeout(t *testing.T) {
+	a, _, _ := setUp(t, false)
+	a.cfg.Timeout = 10 * time.Millisecond
+	if _, err := a.OfferChat(context.Background(), "b"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("OfferChat didn't time out: %v", err)
+	}
+}
+
+// buffer is an in-memory io.WriterAt.
+type buffer struct {
+	mu sync.Mutex
+	b  []byte
+}
+
+func (b *buffer) WriteAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if end := int(off) + len(p); end > len(b.b) {
+		b.b = append(b.b, make([]byte, end-len(b.b))...)
+	}
+	return copy(b.b[off:], p), nil
+}
+
+func (b *buffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.b
+}
+
+func testData(n int) []byte {
+	data := make([]byte, n)
+	for i := range data {
+		data[i] = byte(rand.Uint32())
+	}
+	return data
+}
+
+func TestSend(t *testing.T) {
+	for _, passive := range []bool{false, true} {
+		for _, resume := range []int64{0, 100000} {
+			t.Run(fmt.Sprintf("passive=%t/resume=%d", passive, resume), func(t *testing.T) {
+				a, b, offers := setUp(t, passive)
+				ctx := context.Background()
+				data := testData(300000)
+				var progress atomic.Int32
+				b.cfg.Progress = func(*Transfer) { progress.Add(1) }
+
+				ta, err := a.Send(ctx, "b", "some file.bin", bytes.NewReader(data), int64(len(data)))
+				if err != nil {
+					t.Fatalf("Send returned %v", err)
+				}
+				o := getOffer(t, offers)
+				if o.Type != SEND || o.Name != "some file.bin" || o.Size != int64(len(data)) {
+					t.Errorf("Unexpected offer: %#v", o)
+				}
+				w := &buffer{}
+				w.WriteAt(data[:resume], 0)
+				tb, err := b.Receive(ctx, o, w, resume)
+				if err != nil {
+					t.Fatalf("Receive returned %v", err)
+				}
+				if err := tb.Wait(); err != nil {
+					t.Errorf("Receive failed: %v", err)
+				}
+				if err := ta.Wait(); err != nil {
+					t.Errorf("Send failed: %v", err)
+				}
+				if !bytes.Equal(w.Bytes(), data) {
+					t.Errorf("Received data doesn't match.")
+				}
+				if ta.Position() != int64(len(data)) || tb.Position() != int64(len(data)) {
+					t.Errorf("Bad final positions: %d, %d", ta.Position(), tb.Position())
+				}
+				if ta.Offer.Position != resume {
+					t.Errorf("Sender resumed from %d, want %d", ta.Offer.Position, resume)
+				}
+				if progress.Load() == 0 {
+					t.Errorf("Progress not reported.")
+				}
+			})
+		}
+	}
+}
+
+func TestSendCancel(t *testing.T) {
+	a, _, offers := setUp(t, false)
+	ta, err := a.Send(context.Background(), "b", "file", bytes.NewReader(nil), 0)
+	if err != nil {
+		t.Fatalf("Send returned %v", err)
+	}
+	getOffer(t, offers)
+	ta.Cancel()
+	select {
+	case <-ta.Done():
+	case <-time.After(time.Second):
+		t.Fatalf("Cancelled transfer didn't finish.")
+	}
+	if err := ta.Wait(); !errors.Is(err, context.Canceled) {
+		t.Errorf("Cancelled transfer returned %v", err)
+	}
+}
+
+func TestOfferChecks(t *testing.T) {
+	a, b, offers := setUp(t, false)
+	ctx := context.Background()
+	b.cfg.Allow = []string{"*!*@TRUSTED.example.com"}
+	b.cfg.MaxSize = 10
+
+	// a isn't allowed.
+	a.Send(ctx, "b", "file", bytes.NewReader(nil), 0)
+	b.cfg.Allow = append(b.cfg.Allow, "a!ident@*.example.com")
+	// a is allowed, but the file is too big.
+	a.Send(ctx, "b", "file", bytes.NewReader(make([]byte, 11)), 11)
+	select {
+	case o := <-offers:
+		t.Errorf("Unexpected offer received: %#v", o)
+	case <-time.After(10 * time.Millisecond):
+	}
+	// Both fine.
+	a.Send(ctx, "b", "file", bytes.NewReader(make([]byte, 10)), 10)
+	o := getOffer(t, offers)
+
+	// Receive checks the size too.
+	o.Size = 11
+	if _, err := b.Receive(ctx, o, &buffer{}, 0); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Receive accepted oversized file: %v", err)
+	}
+	o.Size = 10
+	if _, err := b.Receive(ctx, o, &buffer{}, 11); !errors.Is(err, ErrBadResume) {
+		t.Errorf("Receive accepted bad resume position: %v", err)
+	}
+	// Files of unknown size are cut off at MaxSize.
+	b.cfg.MaxSize = 20
+	ta, _ := a.Send(ctx, "b", "file", bytes.NewReader(make([]byte, 20)), 20)
+	o = getOffer(t, offers)
+	o.Size = -1
+	b.cfg.MaxSize = 10
+	tb, err := b.Receive(ctx, o, &buffer{}, 0)
+	if err != nil {
+		t.Fatalf("Receive returned %v", err)
+	}
+	if err := tb.Wait(); !errors.Is(err, ErrTooLarge) {
+		t.Errorf("Receive didn't enforce MaxSize: %v", err)
+	}
+	ta.Wait()
+}
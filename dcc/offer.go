@@ -0,0 +1,231 @@
+package dcc
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/fluffle/goirc/client"
+)
+
+// The DCC request types understood by this package.
+const (
+	CHAT   = "CHAT"
+	SEND   = "SEND"
+	RESUME = "RESUME"
+	ACCEPT = "ACCEPT"
+)
+
+// verb is the CTCP verb used for all DCC requests.
+const verb = "DCC"
+
+// ErrBadOffer is returned when a DCC request can't be parsed.
+var ErrBadOffer = errors.New("dcc: malformed offer")
+
+// An Offer is a parsed DCC request. CHAT and SEND requests offer a
+// connection to the recipient, while RESUME and ACCEPT negotiate resuming
+// a previously interrupted SEND.
+//
+// When Port is 0 the offer is passive (also known as reverse DCC): the
+// sender can't accept connections, so asks the recipient to listen
+// instead, and to reply with a copy of the offer containing the same
+// Token and the address the recipient is listening on.
+type Offer struct {
+	// One of CHAT, SEND, RESUME or ACCEPT.
+	Type string
+	// The source of the offer. These are empty for offers we create.
+	Nick, Ident, Host string
+	// The filename for SEND, RESUME and ACCEPT, or the chat protocol,
+	// usually "chat", for CHAT.
+	Name string
+	// The address to connect to. RESUME and ACCEPT carry only a Port,
+	// which identifies the SEND being resumed.
+	IP   net.IP
+	Port int
+	// The size of the file for SEND, or -1 if it wasn't given.
+	Size int64
+	// The position in the file to resume from, for RESUME and ACCEPT.
+	Position int64
+	// Identifies the offer that a reply to a passive offer is for.
+	Token string
+}
+
+// ParseOffer parses a CTCP DCC line received from a server.
+func ParseOffer(line *client.Line) (*Offer, error) {
+	if line.Cmd != client.CTCP || len(line.Args) < 3 ||
+		!strings.EqualFold(line.Args[0], verb) {
+		return nil, fmt.Errorf("%w: not a DCC request", ErrBadOffer)
+	}
+	o, err := Parse(line.Args[2])
+	if err != nil {
+		return nil, err
+	}
+	o.Nick, o.Ident, o.Host = line.Nick, line.Ident, line.Host
+	return o, nil
+}
+
+// Parse parses the argument of a CTCP DCC request, e.g.
+//
+//	SEND "some file.txt" 3232235777 4321 1048576
+func Parse(arg string) (*Offer, error) {
+	f := fields(arg)
+	if len(f) < 4 {
+		return nil, fmt.Errorf("%w: %q", ErrBadOffer, arg)
+	}
+	o := &Offer{Type: strings.ToUpper(f[0]), Name: f[1], Size: -1}
+	var err error
+	switch o.Type {
+	case CHAT, SEND:
+		// CHAT <protocol> <ip> <port> [<token>]
+		// SEND <filename> <ip> <port> [<size> [<token>]]
+		if o.IP, err = parseIP(f[2]); err != nil {
+			return nil, err
+		}
+		if o.Port, err = parsePort(f[3]); err != nil {
+			return nil, err
+		}
+		rest := f[4:]
+		if o.Type == SEND && len(rest) > 0 {
+			if o.Size, err = strconv.ParseInt(rest[0], 10, 64); err != nil || o.Size < 0 {
+				return nil, fmt.Errorf("%w: bad size %q", ErrBadOffer, rest[0])
+			}
+			rest = rest[1:]
+		}
+		if len(rest) > 0 {
+			o.Token = rest[0]
+		}
+	case RESUME, ACCEPT:
+		// RESUME <filename> <port> <position> [<token>]
+		if o.Port, err = parsePort(f[2]); err != nil {
+			return nil, err
+		}
+		if o.Position, err = strconv.ParseInt(f[3], 10, 64); err != nil || o.Position < 0 {
+			return nil, fmt.Errorf("%w: bad position %q", ErrBadOffer, f[3])
+		}
+		if len(f) > 4 {
+			o.Token = f[4]
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown type %q", ErrBadOffer, f[0])
+	}
+	if o.Port == 0 && o.Token == "" {
+		return nil, fmt.Errorf("%w: passive offer without token", ErrBadOffer)
+	}
+	return o, nil
+}
+
+// String returns the offer formatted as the argument of a CTCP DCC request.
+func (o *Offer) String() string {
+	name := o.Name
+	if strings.ContainsAny(name, " \"") || name == "" {
+		name = strconv.Quote(name)
+	}
+	f := []string{o.Type, name}
+	switch o.Type {
+	case CHAT, SEND:
+		f = append(f, formatIP(o.IP), strconv.Itoa(o.Port))
+		if o.Type == SEND && (o.Size >= 0 || o.Token != "") {
+			f = append(f, strconv.FormatInt(max(o.Size, 0), 10))
+		}
+	default:
+		f = append(f, strconv.Itoa(o.Port), strconv.FormatInt(o.Position, 10))
+	}
+	if o.Token != "" {
+		f = append(f, o.Token)
+	}
+	return strings.Join(f, " ")
+}
+
+// Passive returns true if the offer asks the recipient to listen for a
+// connection, rather than connect to the sender.
+func (o *Offer) Passive() bool {
+	return o.Port == 0
+}
+
+// Addr returns the host:port address to connect to for the offer.
+func (o *Offer) Addr() string {
+	return net.JoinHostPort(o.IP.String(), strconv.Itoa(o.Port))
+}
+
+// Source returns the nick!ident@host the offer came from.
+func (o *Offer) Source() string {
+	return o.Nick + "!" + o.Ident + "@" + o.Host
+}
+
+// SafeName returns the offered filename with any directory components
+// removed, so it can be used to name a local file without being tricked
+// into writing outside a download directory. It returns "" if nothing
+// usable remains.
+func (o *Offer) SafeName() string {
+	name := path.Base(strings.ReplaceAll(o.Name, "\\", "/"))
+	name = strings.TrimLeft(name, ".")
+	if strings.ContainsAny(name, "/\000") {
+		return ""
+	}
+	return name
+}
+
+// fields splits a DCC request into space-separated fields, allowing the
+// filename to be wrapped in double quotes if it contains spaces.
+func fields(s string) []string {
+	var f []string
+	for s = strings.TrimLeft(s, " "); s != ""; s = strings.TrimLeft(s, " ") {
+		if s[0] == '"' {
+			if end := strings.IndexByte(s[1:], '"'); end != -1 {
+				quoted := s[:end+2]
+				if q, err := strconv.Unquote(quoted); err == nil {
+					f = append(f, q)
+				} else {
+					f = append(f, quoted[1:len(quoted)-1])
+				}
+				s = s[end+2:]
+				continue
+			}
+		}
+		field := s
+		if idx := strings.IndexByte(s, ' '); idx != -1 {
+			field, s = s[:idx], s[idx:]
+		} else {
+			s = ""
+		}
+		f = append(f, field)
+	}
+	return f
+}
+
+// parseIP parses an IP address, which for IPv4 is traditionally sent as
+// a 32-bit unsigned integer, but may also be a dotted quad or IPv6 literal.
+func parseIP(s string) (net.IP, error) {
+	if n, err := strconv.ParseUint(s, 10, 32); err == nil {
+		ip := make(net.IP, 4)
+		binary.BigEndian.PutUint32(ip, uint32(n))
+		return ip, nil
+	}
+	if ip := net.ParseIP(s); ip != nil {
+		return ip, nil
+	}
+	return nil, fmt.Errorf("%w: bad IP %q", ErrBadOffer, s)
+}
+
+// formatIP is the inverse of parseIP.
+func formatIP(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return strconv.FormatUint(uint64(binary.BigEndian.Uint32(ip4)), 10)
+	}
+	if ip == nil {
+		return "0"
+	}
+	return ip.String()
+}
+
+func parsePort(s string) (int, error) {
+	port, err := strconv.ParseUint(s, 10, 16)
+	if err != nil {
+		return 0, fmt.Errorf("%w: bad port %q", ErrBadOffer, s)
+	}
+	return int(port), nil
+}
@@ -0,0 +1,99 @@
+package dcc
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+
+	"github.com/fluffle/goirc/client"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		in    string
+		offer *Offer
+		out   string
+	}{
+		{"CHAT chat 2130706433 1234", &Offer{Type: CHAT, Name: "chat",
+			IP: net.IPv4(127, 0, 0, 1).To4(), Port: 1234, Size: -1}, ""},
+		{"chat chat 2130706433 0 42", &Offer{Type: CHAT, Name: "chat",
+			IP: net.IPv4(127, 0, 0, 1).To4(), Size: -1, Token: "42"},
+			"CHAT chat 2130706433 0 42"},
+		{"SEND file.txt 3232235777 4321 1048576", &Offer{Type: SEND, Name: "file.txt",
+			IP: net.IPv4(192, 168, 1, 1).To4(), Port: 4321, Size: 1048576}, ""},
+		{"SEND file.txt 3232235777 4321", &Offer{Type: SEND, Name: "file.txt",
+			IP: net.IPv4(192, 168, 1, 1).To4(), Port: 4321, Size: -1}, ""},
+		{`SEND "some file.txt" ::1 4321 10 abc`, &Offer{Type: SEND, Name: "some file.txt",
+			IP: net.ParseIP("::1"), Port: 4321, Size: 10, Token: "abc"}, ""},
+		{`SEND "some file.txt" 10.0.0.1 0 10 abc`, &Offer{Type: SEND, Name: "some file.txt",
+			IP: net.ParseIP("10.0.0.1"), Size: 10, Token: "abc"},
+			`SEND "some file.txt" 167772161 0 10 abc`},
+		{"RESUME file.txt 4321 512", &Offer{Type: RESUME, Name: "file.txt",
+			Port: 4321, Size: -1, Position: 512}, ""},
+		{"ACCEPT file.txt 0 512 abc", &Offer{Type: ACCEPT, Name: "file.txt",
+			Size: -1, Position: 512, Token: "abc"}, ""},
+	}
+	for i, test := range tests {
+		o, err := Parse(test.in)
+		if err != nil {
+			t.Errorf("%d: Parse(%q) returned error: %v", i, test.in, err)
+			continue
+		}
+		if !reflect.DeepEqual(o, test.offer) {
+			t.Errorf("%d: Parse(%q) = %#v, want %#v", i, test.in, o, test.offer)
+		}
+		if test.out == "" {
+			test.out = test.in
+		}
+		if s := o.String(); s != test.out {
+			t.Errorf("%d: String() = %q, want %q", i, s, test.out)
+		}
+	}
+
+	bad := []string{
+		"",
+		"SEND file.txt 1234",
+		"FOO bar 1 2",
+		"SEND file.txt notanip 4321 10",
+		"SEND file.txt 2130706433 65536 10",
+		"SEND file.txt 2130706433 4321 -1",
+		"SEND file.txt 2130706433 0 10",
+		"RESUME file.txt 4321 lots",
+	}
+	for _, s := range bad {
+		if o, err := Parse(s); !errors.Is(err, ErrBadOffer) {
+			t.Errorf("Parse(%q) = %#v, %v; want ErrBadOffer", s, o, err)
+		}
+	}
+}
+
+func TestParseOffer(t *testing.T) {
+	l := client.ParseLine(":nick!ident@host PRIVMSG me :\001DCC SEND file 2130706433 1234 5\001")
+	o, err := ParseOffer(l)
+	if err != nil || o.Type != SEND || o.Source() != "nick!ident@host" {
+		t.Errorf("ParseOffer returned %#v, %v", o, err)
+	}
+	l = client.ParseLine(":nick!ident@host PRIVMSG me :\001VERSION\001")
+	if _, err := ParseOffer(l); !errors.Is(err, ErrBadOffer) {
+		t.Errorf("ParseOffer didn't reject non-DCC CTCP: %v", err)
+	}
+}
+
+func TestSafeName(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"file.txt", "file.txt"},
+		{"../../etc/passwd", "passwd"},
+		{`..\..\windows\system.ini`, "system.ini"},
+		{"/", ""},
+		{"..", ""},
+		{".hidden", "hidden"},
+		{"", ""},
+	}
+	for i, test := range tests {
+		o := &Offer{Name: test.in}
+		if out := o.SafeName(); out != test.out {
+			t.Errorf("%d: SafeName(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package dcc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/fluffle/goirc/logging"
+)
+
+// DCC SEND transfers the file over a TCP connection from the sender to the
+// receiver. The receiver acknowledges data as it arrives by sending the
+// total number of bytes received so far as a 32-bit big-endian integer.
+// The sender closes the connection once the whole file is acknowledged.
+
+// transferBufSize is the size of the buffer used for file transfers.
+const transferBufSize = 32 * 1024
+
+// A Transfer is a DCC SEND file transfer, in either direction.
+type Transfer struct {
+	// The offer for the file being transferred. When resuming, Position
+	// is the point the transfer was resumed from.
+	Offer *Offer
+
+	pos      atomic.Int64
+	err      error
+	done     chan struct{}
+	cancel   context.CancelFunc
+	progress func(*Transfer)
+}
+
+func (m *Manager) newTransfer(ctx context.Context, o *Offer) (*Transfer, context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	return &Transfer{
+		Offer:    o,
+		done:     make(chan struct{}),
+		cancel:   cancel,
+		progress: m.cfg.Progress,
+	}, ctx
+}
+
+// Position returns the number of bytes of the file that have been
+// transferred, including any skipped by resuming the transfer.
+func (t *Transfer) Position() int64 {
+	return t.pos.Load()
+}
+
+// Size returns the size of the file, or -1 if it isn't known.
+func (t *Transfer) Size() int64 {
+	return t.Offer.Size
+}
+
+// Done returns a channel that is closed when the transfer has finished.
+func (t *Transfer) Done() <-chan struct{} {
+	return t.done
+}
+
+// Wait waits for the transfer to finish, and returns any error.
+func (t *Transfer) Wait() error {
+	<-t.done
+	return t.err
+}
+
+// Cancel aborts the transfer.
+func (t *Transfer) Cancel() {
+	t.cancel()
+}
+
+// run runs f in a new goroutine, finishing the transfer when it returns.
+func (t *Transfer) run(ctx context.Context, f func(ctx context.Context) error) {
+	go func() {
+		defer close(t.done)
+		defer t.cancel()
+		t.err = f(ctx)
+		if t.err != nil {
+			logging.Warn("dcc: transfer of %q failed: %v", t.Offer.Name, t.err)
+		}
+	}()
+}
+
+// advance records that n more bytes have been transferred.
+func (t *Transfer) advance(n int) int64 {
+	pos := t.pos.Add(int64(n))
+	if t.progress != nil {
+		t.progress(t)
+	}
+	return pos
+}
+
+// Send offers the file to nick with DCC SEND, and returns once the offer
+// has been sent. The file is read from r, which must contain size bytes.
+// The transfer happens in the background; use Wait to wait for it to
+// finish. If the recipient asks to resume the transfer with DCC RESUME,
+// the request is accepted and the transfer starts from where they ask.
+func (m *Manager) Send(ctx context.Context, nick, name string, r io.ReaderAt, size int64) (*Transfer, error) {
+	if size < 0 {
+		return nil, fmt.Errorf("dcc: bad size %d", size)
+	}
+	o := &Offer{Type: SEND, Nick: nick, Name: name, Size: size}
+	var ln net.Listener
+	if m.cfg.Passive {
+		o.IP, o.Token = m.cfg.PublicIP, token()
+	} else {
+		var err error
+		if ln, o.IP, err = m.listen(); err != nil {
+			return nil, err
+		}
+		o.Port = port(ln)
+	}
+	replies, done := m.expect(nick, o.Port, o.Token)
+	t, ctx := m.newTransfer(ctx, o)
+	m.offer(nick, o)
+
+	t.run(ctx, func(ctx context.Context) error {
+		defer done()
+		var conns chan net.Conn
+		var errs chan error
+		if ln != nil {
+			conns, errs = make(chan net.Conn, 1), make(chan error, 1)
+			go func() {
+				if nc, err := m.accept(ctx, ln); err != nil {
+					errs <- err
+				} else {
+					conns <- nc
+				}
+			}()
+		}
+		timeout := time.NewTimer(m.cfg.Timeout)
+		defer timeout.Stop()
+		for {
+			select {
+			case nc := <-conns:
+				return m.sendData(ctx, t, nc, r)
+			case err := <-errs:
+				return err
+			case reply := <-replies:
+				switch {
+				case reply.Type == RESUME:
+					if reply.Position > size {
+						return fmt.Errorf("%w: %d > %d", ErrBadResume, reply.Position, size)
+					}
+					t.pos.Store(reply.Position)
+					o.Position = reply.Position
+					m.offer(nick, &Offer{Type: ACCEPT, Name: reply.Name,
+						Port: reply.Port, Position: reply.Position, Token: reply.Token})
+				case reply.Type == SEND && ln == nil:
+					nc, err := m.dial(ctx, reply)
+					if err != nil {
+						return err
+					}
+					return m.sendData(ctx, t, nc, r)
+				default:
+					logging.Warn("dcc: unexpected %s from %s", reply.Type, reply.Source())
+				}
+			case <-timeout.C:
+				return context.DeadlineExceeded
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+	})
+	return t, nil
+}
+
+// Receive accepts a DCC SEND offer, writing the file to w. If resume is
+// greater than zero, Receive first asks the sender to resume the transfer
+// from that position with DCC RESUME. The transfer happens in the
+// background; use Wait to wait for it to finish.
+func (m *Manager) Receive(ctx context.Context, o *Offer, w io.WriterAt, resume int64) (*Transfer, error) {
+	if o.Type != SEND {
+		return nil, fmt.Errorf("%w: can't receive %s", ErrBadOffer, o.Type)
+	}
+	if m.cfg.MaxSize > 0 && o.Size > m.cfg.MaxSize {
+		return nil, fmt.Errorf("%w: %d bytes", ErrTooLarge, o.Size)
+	}
+	if resume < 0 || (o.Size >= 0 && resume > o.Size) {
+		return nil, fmt.Errorf("%w: %d", ErrBadResume, resume)
+	}
+	t, ctx := m.newTransfer(ctx, o)
+	t.run(ctx, func(ctx context.Context) error {
+		if resume > 0 {
+			replies, done := m.expect(o.Nick, o.Port, o.Token)
+			defer done()
+			m.offer(o.Nick, &Offer{Type: RESUME, Name: o.Name,
+				Port: o.Port, Position: resume, Token: o.Token})
+			accept, err := m.wait(ctx, replies, ACCEPT)
+			if err != nil {
+				return err
+			}
+			if accept.Position > resume {
+				return fmt.Errorf("%w: asked for %d, got %d", ErrBadResume, resume, accept.Position)
+			}
+			t.pos.Store(accept.Position)
+		}
+		var nc net.Conn
+		var err error
+		if o.Passive() {
+			var ln net.Listener
+			var ip net.IP
+			if ln, ip, err = m.listen(); err != nil {
+				return err
+			}
+			m.offer(o.Nick, &Offer{Type: SEND, Name: o.Name, IP: ip,
+				Port: port(ln), Size: o.Size, Token: o.Token})
+			nc, err = m.accept(ctx, ln)
+		} else {
+			nc, err = m.dial(ctx, o)
+		}
+		if err != nil {
+			return err
+		}
+		return m.recvData(ctx, t, nc, w)
+	})
+	return t, nil
+}
+
+// closeOnCancel closes nc when ctx is done, to interrupt blocked I/O.
+func closeOnCancel(ctx context.Context, nc net.Conn) func() {
+	stop := context.AfterFunc(ctx, func() { nc.Close() })
+	return func() {
+		stop()
+		nc.Close()
+	}
+}
+
+// sendData sends the file to the receiver, then waits for them to
+// acknowledge receiving all of it.
+func (m *Manager) sendData(ctx context.Context, t *Transfer, nc net.Conn, r io.ReaderAt) error {
+	defer closeOnCancel(ctx, nc)()
+	size := t.Size()
+	// Acks are only 32 bits, so we can only compare the low bits.
+	acked := make(chan error, 1)
+	go func() {
+		var ack [4]byte
+		for {
+			if _, err := io.ReadFull(nc, ack[:]); err != nil {
+				acked <- err
+				return
+			}
+			if binary.BigEndian.Uint32(ack[:]) == uint32(size) {
+				acked <- nil
+				return
+			}
+		}
+	}()
+
+	pos := t.Position()
+	buf := make([]byte, transferBufSize)
+	sr := io.NewSectionReader(r, pos, size-pos)
+	for {
+		n, err := sr.Read(buf)
+		if n > 0 {
+			if _, werr := nc.Write(buf[:n]); werr != nil {
+				return checkCtx(ctx, werr)
+			}
+			t.advance(n)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+	}
+
+	timeout := time.NewTimer(m.cfg.Timeout)
+	defer timeout.Stop()
+	select {
+	case err := <-acked:
+		if err == io.EOF {
+			// Some clients close the connection without a final ack.
+			err = nil
+		}
+		return checkCtx(ctx, err)
+	case <-timeout.C:
+		return errors.New("dcc: timed out waiting for acknowledgement")
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// recvData receives the file from the sender, acknowledging data as it
+// arrives, until the whole file is received or the sender disconnects.
+func (m *Manager) recvData(ctx context.Context, t *Transfer, nc net.Conn, w io.WriterAt) error {
+	defer closeOnCancel(ctx, nc)()
+	size := t.Size()
+	pos := t.Position()
+	buf := make([]byte, transferBufSize)
+	var ack [4]byte
+	for size < 0 || pos < size {
+		n, err := nc.Read(buf)
+		if n > 0 {
+			if m.cfg.MaxSize > 0 && pos+int64(n) > m.cfg.MaxSize {
+				return fmt.Errorf("%w: more than %d bytes", ErrTooLarge, m.cfg.MaxSize)
+			}
+			if size >= 0 && pos+int64(n) > size {
+				return fmt.Errorf("dcc: received more than %d bytes", size)
+			}
+			if _, werr := w.WriteAt(buf[:n], pos); werr != nil {
+				return werr
+			}
+			pos = t.advance(n)
+			binary.BigEndian.PutUint32(ack[:], uint32(pos))
+			if _, werr := nc.Write(ack[:]); werr != nil {
+				return checkCtx(ctx, werr)
+			}
+		}
+		if err == io.EOF {
+			if size >= 0 && pos < size {
+				return io.ErrUnexpectedEOF
+			}
+			return nil
+		} else if err != nil {
+			return checkCtx(ctx, err)
+		}
+	}
+	return nil
+}
+
+// checkCtx returns ctx's error in preference to err, since closing the
+// connection when ctx is done makes I/O fail with a less useful error.
+func checkCtx(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
@@ -0,0 +1,123 @@
+package format
+
+import (
+	"fmt"
+	"strings"
+)
+
+// A Builder builds formatted messages. Each styled piece of text is
+// self-contained: its formatting is reset at the end, so pieces can be
+// combined freely. The zero value is ready to use. Methods return the
+// Builder so that calls can be chained.
+type Builder struct {
+	b strings.Builder
+}
+
+// Text appends unformatted text.
+func (b *Builder) Text(s string) *Builder {
+	b.b.WriteString(s)
+	return b
+}
+
+// Bold appends bold text.
+func (b *Builder) Bold(s string) *Builder {
+	return b.Style(Style{Bold: true, Fg: NoColour, Bg: NoColour}, s)
+}
+
+// Italic appends italic text.
+func (b *Builder) Italic(s string) *Builder {
+	return b.Style(Style{Italic: true, Fg: NoColour, Bg: NoColour}, s)
+}
+
+// Underline appends underlined text.
+func (b *Builder) Underline(s string) *Builder {
+	return b.Style(Style{Underline: true, Fg: NoColour, Bg: NoColour}, s)
+}
+
+// Strikethrough appends struck-through text.
+func (b *Builder) Strikethrough(s string) *Builder {
+	return b.Style(Style{Strikethrough: true, Fg: NoColour, Bg: NoColour}, s)
+}
+
+// Monospace appends monospaced text.
+func (b *Builder) Monospace(s string) *Builder {
+	return b.Style(Style{Monospace: true, Fg: NoColour, Bg: NoColour}, s)
+}
+
+// Colour appends text in the given foreground and background colours,
+// either of which may be NoColour.
+func (b *Builder) Colour(fg, bg Colour, s string) *Builder {
+	return b.Style(Style{Fg: fg, Bg: bg}, s)
+}
+
+// Style appends text with the given style.
+func (b *Builder) Style(st Style, s string) *Builder {
+	if st.IsPlain() || s == "" {
+		return b.Text(s)
+	}
+	for _, f := range []struct {
+		on   bool
+		code string
+	}{
+		{st.Bold, CodeBold},
+		{st.Italic, CodeItalic},
+		{st.Underline, CodeUnderline},
+		{st.Strikethrough, CodeStrikethrough},
+		{st.Monospace, CodeMonospace},
+		{st.Reverse, CodeReverse},
+	} {
+		if f.on {
+			b.b.WriteString(f.code)
+		}
+	}
+	if st.Fg != NoColour || st.Bg != NoColour {
+		b.colours(st.Fg, st.Bg)
+		if st.Bg == NoColour && s[0] == ',' {
+			// Stop the comma being parsed as part of the colour code.
+			b.b.WriteString(CodeBold + CodeBold)
+		}
+	}
+	b.b.WriteString(s)
+	b.b.WriteString(CodeReset)
+	return b
+}
+
+// colours writes the code for the given colours. Palette colours are
+// always written as two digits, so that following digits aren't parsed
+// as part of the colour.
+func (b *Builder) colours(fg, bg Colour) {
+	switch {
+	case fg == NoColour && bg.IsRGB():
+		// CodeHexColour needs a foreground colour.
+		bg = bg.nearest()
+		fallthrough
+	case !fg.IsRGB() && !bg.IsRGB():
+		if fg == NoColour {
+			fg = numPalette
+		}
+		fmt.Fprintf(&b.b, "%s%02d", CodeColour, int32(fg))
+		if bg != NoColour {
+			fmt.Fprintf(&b.b, ",%02d", int32(bg))
+		}
+	default:
+		b.b.WriteString(CodeHexColour + fg.Hex())
+		if bg != NoColour {
+			b.b.WriteString("," + bg.Hex())
+		}
+	}
+}
+
+// Len returns the length in bytes of the message built so far.
+func (b *Builder) Len() int {
+	return b.b.Len()
+}
+
+// Reset discards the message built so far.
+func (b *Builder) Reset() {
+	b.b.Reset()
+}
+
+// String returns the message built so far.
+func (b *Builder) String() string {
+	return b.b.String()
+}
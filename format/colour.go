@@ -0,0 +1,114 @@
+package format
+
+import "fmt"
+
+// A Colour is either one of the 99 colours in the mIRC palette, set with
+// the Colour code, or an RGB colour set with the HexColour code.
+type Colour int32
+
+// NoColour means the default colour should be used.
+const NoColour Colour = -1
+
+// The first 16 colours of the mIRC palette have names. Colours 16 to 98
+// are less widely supported; see https://modern.ircdocs.horse/formatting.
+const (
+	White Colour = iota
+	Black
+	Blue
+	Green
+	Red
+	Brown
+	Magenta
+	Orange
+	Yellow
+	LightGreen
+	Cyan
+	LightCyan
+	LightBlue
+	Pink
+	Grey
+	LightGrey
+)
+
+// rgbFlag marks a Colour as an RGB colour rather than a palette index.
+const rgbFlag = 1 << 24
+
+// numPalette is the number of colours in the palette.
+const numPalette = 99
+
+// palette contains the RGB values of the mIRC palette colours.
+var palette = [numPalette]uint32{
+	0xffffff, 0x000000, 0x00007f, 0x009300, 0xff0000, 0x7f0000, 0x9c009c, 0xfc7f00,
+	0xffff00, 0x00fc00, 0x009393, 0x00ffff, 0x0000fc, 0xff00ff, 0x7f7f7f, 0xd2d2d2,
+	0x470000, 0x472100, 0x474700, 0x324700, 0x004700, 0x00472c, 0x004747, 0x002747,
+	0x000047, 0x2e0047, 0x470047, 0x47002a, 0x740000, 0x743a00, 0x747400, 0x517400,
+	0x007400, 0x007449, 0x007474, 0x004074, 0x000074, 0x4b0074, 0x740074, 0x740045,
+	0xb50000, 0xb56300, 0xb5b500, 0x7db500, 0x00b500, 0x00b571, 0x00b5b5, 0x0063b5,
+	0x0000b5, 0x7500b5, 0xb500b5, 0xb5006b, 0xff0000, 0xff8c00, 0xffff00, 0xb2ff00,
+	0x00ff00, 0x00ffa0, 0x00ffff, 0x008cff, 0x0000ff, 0xa500ff, 0xff00ff, 0xff0098,
+	0xff5959, 0xffb459, 0xffff71, 0xcfff60, 0x6fff6f, 0x65ffc9, 0x6dffff, 0x59b4ff,
+	0x5959ff, 0xc459ff, 0xff66ff, 0xff59bc, 0xff9c9c, 0xffd39c, 0xffff9c, 0xe2ff9c,
+	0x9cff9c, 0x9cffdb, 0x9cffff, 0x9cd3ff, 0x9c9cff, 0xdc9cff, 0xff9cff, 0xff94d3,
+	0x000000, 0x131313, 0x282828, 0x363636, 0x4d4d4d, 0x656565, 0x818181, 0x9f9f9f,
+	0xbcbcbc, 0xe2e2e2, 0xffffff,
+}
+
+// RGB returns an RGB Colour.
+func RGB(r, g, b uint8) Colour {
+	return Colour(rgbFlag | uint32(r)<<16 | uint32(g)<<8 | uint32(b))
+}
+
+// IsRGB returns true if c is an RGB colour rather than a palette colour.
+func (c Colour) IsRGB() bool {
+	return c != NoColour && c&rgbFlag != 0
+}
+
+// RGB returns the red, green and blue components of c. Palette colours
+// are converted to RGB. NoColour returns black.
+func (c Colour) RGB() (r, g, b uint8) {
+	v := c.rgb()
+	return uint8(v >> 16), uint8(v >> 8), uint8(v)
+}
+
+func (c Colour) rgb() uint32 {
+	switch {
+	case c.IsRGB():
+		return uint32(c) &^ rgbFlag
+	case c >= 0 && c < numPalette:
+		return palette[c]
+	}
+	return 0
+}
+
+// Hex returns c as six hex digits, e.g. "FF0000" for Red.
+func (c Colour) Hex() string {
+	return fmt.Sprintf("%06X", c.rgb())
+}
+
+// String returns the palette number or hex RGB value of c.
+func (c Colour) String() string {
+	switch {
+	case c == NoColour:
+		return "none"
+	case c.IsRGB():
+		return "#" + c.Hex()
+	}
+	return fmt.Sprintf("%d", int32(c))
+}
+
+// nearest returns the palette colour closest to c.
+func (c Colour) nearest() Colour {
+	if !c.IsRGB() {
+		return c
+	}
+	r, g, b := c.RGB()
+	best, bestDist := Black, -1
+	for i := range palette {
+		pr, pg, pb := Colour(i).RGB()
+		dr, dg, db := int(r)-int(pr), int(g)-int(pg), int(b)-int(pb)
+		if d := dr*dr + dg*dg + db*db; bestDist == -1 || d < bestDist {
+			best, bestDist = Colour(i), d
+		}
+	}
+	return best
+}
@@ -0,0 +1,113 @@
+package format
+
+import (
+	"fmt"
+	"html"
+	"strings"
+)
+
+// ansiColours maps the first 16 palette colours to ANSI foreground colour
+// codes. Other colours are converted to 24-bit ANSI colour.
+var ansiColours = [16]int{97, 30, 34, 32, 91, 31, 35, 33, 93, 92, 36, 96, 94, 95, 90, 37}
+
+// ANSI converts formatted text to use ANSI terminal escape sequences.
+// Monospace has no ANSI equivalent, and is ignored.
+func ANSI(s string) string {
+	var b strings.Builder
+	for _, span := range Parse(s) {
+		var sgr []string
+		for _, f := range []struct {
+			on   bool
+			code string
+		}{
+			{span.Bold, "1"},
+			{span.Italic, "3"},
+			{span.Underline, "4"},
+			{span.Reverse, "7"},
+			{span.Strikethrough, "9"},
+		} {
+			if f.on {
+				sgr = append(sgr, f.code)
+			}
+		}
+		if span.Fg != NoColour {
+			sgr = append(sgr, ansiColour(span.Fg, 0))
+		}
+		if span.Bg != NoColour {
+			sgr = append(sgr, ansiColour(span.Bg, 10))
+		}
+		if len(sgr) == 0 {
+			b.WriteString(span.Text)
+			continue
+		}
+		b.WriteString("\x1b[" + strings.Join(sgr, ";") + "m")
+		b.WriteString(span.Text)
+		b.WriteString("\x1b[0m")
+	}
+	return b.String()
+}
+
+// ansiColour returns the SGR parameter for c. Background colour codes
+// are offset from foreground codes by 10.
+func ansiColour(c Colour, offset int) string {
+	if !c.IsRGB() && c < 16 {
+		return fmt.Sprint(ansiColours[c] + offset)
+	}
+	r, g, b := c.RGB()
+	return fmt.Sprintf("%d;2;%d;%d;%d", 38+offset, r, g, b)
+}
+
+// HTML converts formatted text to HTML, escaping it and wrapping styled
+// text in <span> elements with inline CSS. Reversed text has its colours
+// swapped, assuming black text on a white background if colours aren't
+// set.
+func HTML(s string) string {
+	var b strings.Builder
+	for _, span := range Parse(s) {
+		var css []string
+		if span.Bold {
+			css = append(css, "font-weight:bold")
+		}
+		if span.Italic {
+			css = append(css, "font-style:italic")
+		}
+		var deco []string
+		if span.Underline {
+			deco = append(deco, "underline")
+		}
+		if span.Strikethrough {
+			deco = append(deco, "line-through")
+		}
+		if len(deco) > 0 {
+			css = append(css, "text-decoration:"+strings.Join(deco, " "))
+		}
+		if span.Monospace {
+			css = append(css, "font-family:monospace")
+		}
+		fg, bg := span.Fg, span.Bg
+		if span.Reverse {
+			if fg == NoColour {
+				fg = Black
+			}
+			if bg == NoColour {
+				bg = White
+			}
+			fg, bg = bg, fg
+		}
+		if fg != NoColour {
+			css = append(css, "color:#"+strings.ToLower(fg.Hex()))
+		}
+		if bg != NoColour {
+			css = append(css, "background-color:#"+strings.ToLower(bg.Hex()))
+		}
+		text := html.EscapeString(span.Text)
+		if len(css) == 0 {
+			b.WriteString(text)
+			continue
+		}
+		b.WriteString(`<span style="` + strings.Join(css, ";") + `">`)
+		b.WriteString(text)
+		b.WriteString("</span>")
+	}
+	return b.String()
+}
@@ -0,0 +1,172 @@
+// Package format handles the mIRC formatting codes used to add bold,
+// italics, colours and so on to IRC messages. It can parse formatted text
+// into styled spans, strip formatting, build formatted messages, and
+// convert formatted text to ANSI terminal escapes or HTML.
+//
+// For example, to match bot commands regardless of formatting:
+//
+//	if strings.HasPrefix(format.Strip(line.Text()), "!help") { ... }
+//
+// and to send a formatted message:
+//
+//	var b format.Builder
+//	b.Bold("Warning:").Text(" the build is ").Colour(format.Red, format.NoColour, "broken")
+//	conn.Privmsg("#chan", b.String())
+package format
+
+import (
+	"strconv"
+	"strings"
+)
+
+// The formatting codes understood by this package. CodeBold, CodeItalic,
+// CodeUnderline, CodeStrikethrough, CodeMonospace and CodeReverse toggle
+// their style. CodeColour is followed by up to two digits of foreground
+// colour, optionally followed by a comma and up to two digits of background
+// colour; without any digits it resets the colours. CodeHexColour works the same way
+// with six hex digits of RGB colour. CodeReset turns off all formatting.
+const (
+	CodeBold          = "\x02"
+	CodeColour        = "\x03"
+	CodeHexColour     = "\x04"
+	CodeReset         = "\x0f"
+	CodeMonospace     = "\x11"
+	CodeReverse       = "\x16"
+	CodeItalic        = "\x1d"
+	CodeStrikethrough = "\x1e"
+	CodeUnderline     = "\x1f"
+)
+
+// codes contains all the formatting codes, for strings.IndexAny.
+const codes = CodeBold + CodeColour + CodeHexColour + CodeReset + CodeMonospace +
+	CodeReverse + CodeItalic + CodeStrikethrough + CodeUnderline
+
+// Style describes the formatting applied to some text.
+type Style struct {
+	Bold, Italic, Underline, Strikethrough, Monospace, Reverse bool
+	// Foreground and background colours, or NoColour.
+	Fg, Bg Colour
+}
+
+// Plain is the Style of unformatted text.
+var Plain = Style{Fg: NoColour, Bg: NoColour}
+
+// IsPlain returns true if the style has no formatting.
+func (s Style) IsPlain() bool {
+	return s == Plain
+}
+
+// A Span is a run of text with the same Style.
+type Span struct {
+	Style
+	Text string
+}
+
+// Parse parses formatted text into spans of text with the same style.
+// Empty spans are omitted, so Parse returns nil for text that is empty
+// or consists only of formatting codes.
+func Parse(s string) []Span {
+	var spans []Span
+	style := Plain
+	emit := func(text string) {
+		if text == "" {
+			return
+		}
+		if n := len(spans); n > 0 && spans[n-1].Style == style {
+			spans[n-1].Text += text
+			return
+		}
+		spans = append(spans, Span{Style: style, Text: text})
+	}
+	for s != "" {
+		i := strings.IndexAny(s, codes)
+		if i == -1 {
+			emit(s)
+			break
+		}
+		emit(s[:i])
+		c := s[i]
+		s = s[i+1:]
+		switch c {
+		case CodeBold[0]:
+			style.Bold = !style.Bold
+		case CodeItalic[0]:
+			style.Italic = !style.Italic
+		case CodeUnderline[0]:
+			style.Underline = !style.Underline
+		case CodeStrikethrough[0]:
+			style.Strikethrough = !style.Strikethrough
+		case CodeMonospace[0]:
+			style.Monospace = !style.Monospace
+		case CodeReverse[0]:
+			style.Reverse = !style.Reverse
+		case CodeReset[0]:
+			style = Plain
+		case CodeColour[0]:
+			s = parseColours(s, &style, 2, parsePalette)
+		case CodeHexColour[0]:
+			s = parseColours(s, &style, 6, parseHex)
+		}
+	}
+	return spans
+}
+
+// parseColours parses the parameters of a colour code from the start of
+// s into style, and returns the rest of s. Each colour is up to n bytes
+// long and is parsed by parse, which returns the colour and its length.
+func parseColours(s string, style *Style, n int, parse func(string, int) (Colour, int)) string {
+	fg, l := parse(s, n)
+	if l == 0 {
+		style.Fg, style.Bg = NoColour, NoColour
+		return s
+	}
+	style.Fg, s = fg, s[l:]
+	if len(s) > 1 && s[0] == ',' {
+		if bg, l := parse(s[1:], n); l > 0 {
+			style.Bg, s = bg, s[l+1:]
+		}
+	}
+	return s
+}
+
+// parsePalette parses up to n digits of a palette colour.
+func parsePalette(s string, n int) (Colour, int) {
+	l := 0
+	for l < n && l < len(s) && s[l] >= '0' && s[l] <= '9' {
+		l++
+	}
+	if l == 0 {
+		return NoColour, 0
+	}
+	c, _ := strconv.Atoi(s[:l])
+	if c >= numPalette {
+		// 99 means "default colour"; anything else is out of range.
+		return NoColour, l
+	}
+	return Colour(c), l
+}
+
+// parseHex parses exactly n hex digits of RGB colour.
+func parseHex(s string, n int) (Colour, int) {
+	if len(s) < n {
+		return NoColour, 0
+	}
+	rgb, err := strconv.ParseUint(s[:n], 16, 32)
+	if err != nil {
+		return NoColour, 0
+	}
+	return Colour(rgbFlag | rgb), n
+}
+
+// Strip removes all formatting codes from s.
+func Strip(s string) string {
+	if strings.IndexAny(s, codes) == -1 {
+		return s
+	}
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, span := range Parse(s) {
+		b.WriteString(span.Text)
+	}
+	return b.String()
+}
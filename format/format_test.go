@@ -0,0 +1,154 @@
+package format
+
+import (
+	"reflect"
+	"testing"
+)
+
+func style(f func(*Style)) Style {
+	s := Plain
+	f(&s)
+	return s
+}
+
+func TestParse(t *testing.T) {
+	bold := style(func(s *Style) { s.Bold = true })
+	red := style(func(s *Style) { s.Fg = Red })
+	tests := []struct {
+		in    string
+		spans []Span
+	}{
+		{"", nil},
+		{"\x02\x0f", nil},
+		{"plain", []Span{{Plain, "plain"}}},
+		{"a \x02bold\x02 b", []Span{{Plain, "a "}, {bold, "bold"}, {Plain, " b"}}},
+		// Toggling twice is a no-op, so spans are merged.
+		{"a\x02\x02b", []Span{{Plain, "ab"}}},
+		{"\x02\x1d\x1f\x1e\x11\x16all\x0fnone", []Span{
+			{style(func(s *Style) {
+				s.Bold, s.Italic, s.Underline = true, true, true
+				s.Strikethrough, s.Monospace, s.Reverse = true, true, true
+			}), "all"},
+			{Plain, "none"},
+		}},
+		{"\x034red\x03plain", []Span{{red, "red"}, {Plain, "plain"}}},
+		{"\x03041234", []Span{{red, "1234"}}},
+		{"\x034,12on blue", []Span{{style(func(s *Style) { s.Fg, s.Bg = Red, LightBlue }), "on blue"}}},
+		// A comma only introduces a background colour if followed by a digit.
+		{"\x034,text", []Span{{red, ",text"}}},
+		// Changing foreground colour keeps the background.
+		{"\x031,8a\x034b", []Span{
+			{style(func(s *Style) { s.Fg, s.Bg = Black, Yellow }), "a"},
+			{style(func(s *Style) { s.Fg, s.Bg = Red, Yellow }), "b"},
+		}},
+		{"\x0399default", []Span{{Plain, "default"}}},
+		{"\x0452,a", []Span{{Plain, "52,a"}}},
+		{"\x04FF8000orange\x04,", []Span{
+			{style(func(s *Style) { s.Fg = RGB(0xff, 0x80, 0) }), "orange"},
+			{Plain, ","},
+		}},
+		{"\x04ff8000,000000x", []Span{
+			{style(func(s *Style) { s.Fg, s.Bg = RGB(0xff, 0x80, 0), RGB(0, 0, 0) }), "x"},
+		}},
+	}
+	for i, test := range tests {
+		if spans := Parse(test.in); !reflect.DeepEqual(spans, test.spans) {
+			t.Errorf("%d: Parse(%q) =\n\t%+v\nwant\n\t%+v", i, test.in, spans, test.spans)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"", ""},
+		{"!help me", "!help me"},
+		{"\x02!help\x02 \x0304,01me\x03", "!help me"},
+		{"\x0312345", "345"},
+		{"\x04abcdefx\x04", "x"},
+	}
+	for i, test := range tests {
+		if out := Strip(test.in); out != test.out {
+			t.Errorf("%d: Strip(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+	}
+}
+
+func TestColour(t *testing.T) {
+	if r, g, b := Red.RGB(); r != 0xff || g != 0 || b != 0 {
+		t.Errorf("Red.RGB() = %d, %d, %d", r, g, b)
+	}
+	c := RGB(1, 2, 3)
+	if !c.IsRGB() || Red.IsRGB() || NoColour.IsRGB() {
+		t.Errorf("IsRGB is confused.")
+	}
+	if c.Hex() != "010203" || c.String() != "#010203" || Red.String() != "4" || NoColour.String() != "none" {
+		t.Errorf("Bad string conversion: %s %s %s %s", c.Hex(), c, Red, NoColour)
+	}
+	if n := RGB(0xfe, 0, 0).nearest(); n != Red {
+		t.Errorf("Nearest colour to almost-red was %s", n)
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	var b Builder
+	b.Text("plain ").Bold("bold").Text(" ").Italic("it").Underline("u").
+		Strikethrough("s").Monospace("m").Colour(Red, NoColour, "1st").
+		Colour(Red, NoColour, ",").Colour(NoColour, Blue, "bg").
+		Colour(RGB(1, 2, 3), Red, "hex").Colour(NoColour, RGB(0xfe, 0, 0), "near").
+		Style(Style{Bold: true, Reverse: true, Fg: White, Bg: Black}, "rev").
+		Bold("").Style(Plain, " end")
+	want := "plain \x02bold\x0f \x1dit\x0f\x1fu\x0f\x1es\x0f\x11m\x0f\x03041st\x0f" +
+		"\x0304\x02\x02,\x0f\x0399,02bg\x0f\x04010203,FF0000hex\x0f\x0399,04near\x0f" +
+		"\x02\x16\x0300,01rev\x0f end"
+	if s := b.String(); s != want {
+		t.Errorf("Builder built\n\t%q\nwant\n\t%q", s, want)
+	}
+	if b.Len() != len(want) {
+		t.Errorf("Len() = %d, want %d", b.Len(), len(want))
+	}
+	// What the builder builds, Parse parses.
+	spans := Parse(b.String())
+	if len(spans) != 13 || spans[0].Text != "plain " || spans[7].Text != "1st," ||
+		spans[7].Fg != Red || spans[8].Bg != Blue || spans[9].Fg != RGB(1, 2, 3) ||
+		spans[10].Bg != Red || !spans[11].Reverse || spans[12].Text != " end" {
+		t.Errorf("Built message parsed unexpectedly: %+v", spans)
+	}
+	b.Reset()
+	if b.String() != "" {
+		t.Errorf("Reset didn't.")
+	}
+}
+
+func TestANSI(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"plain", "plain"},
+		{"\x02bold\x02 \x1d\x1fiu", "\x1b[1mbold\x1b[0m \x1b[3;4miu\x1b[0m"},
+		{"\x034,12red on blue", "\x1b[91;104mred on blue\x1b[0m"},
+		{"\x0352\x16x", "\x1b[7;38;2;255;0;0mx\x1b[0m"},
+		{"\x04102030,405060x\x1e\x11y", "\x1b[38;2;16;32;48;48;2;64;80;96mx\x1b[0m" +
+			"\x1b[9;38;2;16;32;48;48;2;64;80;96my\x1b[0m"},
+	}
+	for i, test := range tests {
+		if out := ANSI(test.in); out != test.out {
+			t.Errorf("%d: ANSI(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+	}
+}
+
+func TestHTML(t *testing.T) {
+	tests := []struct{ in, out string }{
+		{"<plain & simple>", "&lt;plain &amp; simple&gt;"},
+		{"\x02b\x1di\x1f\x1eu\x11m", `<span style="font-weight:bold">b</span>` +
+			`<span style="font-weight:bold;font-style:italic">i</span>` +
+			`<span style="font-weight:bold;font-style:italic;text-decoration:underline line-through">u</span>` +
+			`<span style="font-weight:bold;font-style:italic;text-decoration:underline line-through;font-family:monospace">m</span>`},
+		{"\x034,12x\x16y\x03z", `<span style="color:#ff0000;background-color:#0000fc">x</span>` +
+			`<span style="color:#0000fc;background-color:#ff0000">y</span>` +
+			`<span style="color:#ffffff;background-color:#000000">z</span>`},
+	}
+	for i, test := range tests {
+		if out := HTML(test.in); out != test.out {
+			t.Errorf("%d: HTML(%q) = %q, want %q", i, test.in, out, test.out)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package state
+
+import "strings"
+
+// A CaseMapping defines which characters in nicks and channel names are
+// considered to be equivalent when compared case-insensitively. IRC
+// servers advertise their casemapping in RPL_ISUPPORT. The zero value is
+// RFC1459, which is the default when a server doesn't say otherwise.
+type CaseMapping int
+
+const (
+	// RFC1459 treats A-Z as a-z, and the characters []\~ as {}|^,
+	// since they were upper and lower case in Scandinavian character
+	// sets at the time RFC 1459 was written.
+	RFC1459 CaseMapping = iota
+	// ASCII only treats A-Z as a-z.
+	ASCII
+	// RFC1459Strict is like RFC1459, but without ~ and ^.
+	RFC1459Strict
+	// RFC7613 allows UTF-8 nicks and folds them using Unicode case
+	// mapping. Unlike a full RFC 7613 implementation, it does not apply
+	// width mapping or Unicode normalisation.
+	RFC7613
+)
+
+var caseMappingNames = map[CaseMapping]string{
+	RFC1459:       "rfc1459",
+	ASCII:         "ascii",
+	RFC1459Strict: "rfc1459-strict",
+	RFC7613:       "rfc7613",
+}
+
+// ParseCaseMapping returns the CaseMapping with the given name, as found
+// in the CASEMAPPING token of RPL_ISUPPORT.
+func ParseCaseMapping(name string) (CaseMapping, bool) {
+	for cm, n := range caseMappingNames {
+		if n == name {
+			return cm, true
+		}
+	}
+	return RFC1459, false
+}
+
+// String returns the name of the casemapping.
+func (cm CaseMapping) String() string {
+	if n, ok := caseMappingNames[cm]; ok {
+		return n
+	}
+	return "unknown"
+}
+
+// lower returns the lower case equivalent of c, if it has one.
+func (cm CaseMapping) lower(c byte) byte {
+	switch {
+	case c >= 'A' && c <= 'Z':
+		return c + 'a' - 'A'
+	case cm == ASCII:
+	case c == '[' || c == ']' || c == '\\':
+		// [ ] \ are 0x5b-0x5d, and { } | are 0x7b-0x7d.
+		return c + 0x20
+	case c == '~' && cm == RFC1459:
+		return '^'
+	}
+	return c
+}
+
+// Fold returns s with every character replaced by its lower case
+// equivalent, so that two strings are equal according to the casemapping
+// if their folded forms are identical.
+func (cm CaseMapping) Fold(s string) string {
+	if cm == RFC7613 {
+		return strings.ToLower(s)
+	}
+	for i := 0; i < len(s); i++ {
+		if cm.lower(s[i]) != s[i] {
+			b := []byte(s)
+			for j := i; j < len(b); j++ {
+				b[j] = cm.lower(b[j])
+			}
+			return string(b)
+		}
+	}
+	return s
+}
+
+// Equal returns true if a and b are equal according to the casemapping.
+func (cm CaseMapping) Equal(a, b string) bool {
+	if cm == RFC7613 {
+		return strings.ToLower(a) == strings.ToLower(b)
+	}
+	if len(a) != len(b) {
+		return false
+	}
+	for i := 0; i < len(a); i++ {
+		if cm.lower(a[i]) != cm.lower(b[i]) {
+			return false
+		}
+	}
+	return true
+}
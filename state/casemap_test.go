@@ -0,0 +1,32 @@
+package state
+
+import "testing"
+
+func TestCaseMapping(t *testing.T) {
+	tests := []struct {
+		cm       CaseMapping
+		in, fold string
+	}{
+		{RFC1459, "Nick[A]\\~", "nick{a}|^"},
+		{RFC1459Strict, "Nick[A]\\~", "nick{a}|~"},
+		{ASCII, "Nick[A]\\~", "nick[a]\\~"},
+		{RFC1459, "already{folded}", "already{folded}"},
+		{RFC7613, "Nick[A]ÉÅ", "nick[a]éå"},
+	}
+	for i, test := range tests {
+		if f := test.cm.Fold(test.in); f != test.fold {
+			t.Errorf("%d: %s.Fold(%q) = %q, want %q", i, test.cm, test.in, f, test.fold)
+		}
+		if !test.cm.Equal(test.in, test.fold) || test.cm.Equal(test.in, test.fold+"x") {
+			t.Errorf("%d: %s.Equal is broken", i, test.cm)
+		}
+	}
+	for _, name := range []string{"rfc1459", "ascii", "rfc1459-strict", "rfc7613"} {
+		if cm, ok := ParseCaseMapping(name); !ok || cm.String() != name {
+			t.Errorf("ParseCaseMapping(%q) = %s, %t", name, cm, ok)
+		}
+	}
+	if cm, ok := ParseCaseMapping("bogus"); ok || cm != RFC1459 {
+		t.Errorf("ParseCaseMapping(bogus) = %s, %t", cm, ok)
+	}
+}
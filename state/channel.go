@@ -21,6 +21,8 @@ type channel struct {
 	modes       *ChanMode
 	lookup      map[string]*nick
 	nicks       map[*nick]*ChanPrivs
+	// Casemapping used to fold the keys of lookup.
+	cm CaseMapping
 }
 
 // A struct representing the modes of an IRC Channel
@@ -137,7 +139,7 @@ func (ch *channel) isOn(nk *nick) (*ChanPrivs, bool) {
 func (ch *channel) addNick(nk *nick, cp *ChanPrivs) {
 	if _, ok := ch.nicks[nk]; !ok {
 		ch.nicks[nk] = cp
-		ch.lookup[nk.nick] = nk
+		ch.lookup[ch.cm.Fold(nk.nick)] = nk
 	} else {
 		logging.Warn("Channel.addNick(): %s already on %s.", nk.nick, ch.name)
 	}
@@ -147,12 +149,21 @@ func (ch *channel) addNick(nk *nick, cp *ChanPrivs) {
 func (ch *channel) delNick(nk *nick) {
 	if _, ok := ch.nicks[nk]; ok {
 		delete(ch.nicks, nk)
-		delete(ch.lookup, nk.nick)
+		delete(ch.lookup, ch.cm.Fold(nk.nick))
 	} else {
 		logging.Warn("Channel.delNick(): %s not on %s.", nk.nick, ch.name)
 	}
 }
 
+// Rebuilds the lookup map with a new casemapping.
+func (ch *channel) setCaseMapping(cm CaseMapping) {
+	ch.cm = cm
+	ch.lookup = make(map[string]*nick, len(ch.nicks))
+	for nk := range ch.nicks {
+		ch.lookup[cm.Fold(nk.nick)] = nk
+	}
+}
+
 // Parses mode strings for a channel.
 func (ch *channel) parseModes(modes string, modeargs ...string) {
 	var modeop bool // true => add mode, false => remove mode
@@ -206,7 +217,7 @@ func (ch *channel) parseModes(modes string, modeargs ...string) {
 			}
 		case 'q', 'a', 'o', 'h', 'v':
 			if len(modeargs) != 0 {
-				if nk, ok := ch.lookup[modeargs[0]]; ok {
+				if nk, ok := ch.lookup[ch.cm.Fold(modeargs[0])]; ok {
 					cp := ch.nicks[nk]
 					switch m {
 					case 'q':
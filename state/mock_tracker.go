@@ -88,6 +88,26 @@ func (_mr *_MockTrackerRecorder) NickModes(arg0, arg1 interface{}) *gomock.Call
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "NickModes", arg0, arg1)
 }
 
+func (_m *MockTracker) NickAccount(nick string, account string) *Nick {
+	ret := _m.ctrl.Call(_m, "NickAccount", nick, account)
+	ret0, _ := ret[0].(*Nick)
+	return ret0
+}
+
+func (_mr *_MockTrackerRecorder) NickAccount(arg0, arg1 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "NickAccount", arg0, arg1)
+}
+
+func (_m *MockTracker) NickAway(nick string, away bool, message string) *Nick {
+	ret := _m.ctrl.Call(_m, "NickAway", nick, away, message)
+	ret0, _ := ret[0].(*Nick)
+	return ret0
+}
+
+func (_mr *_MockTrackerRecorder) NickAway(arg0, arg1, arg2 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "NickAway", arg0, arg1, arg2)
+}
+
 func (_m *MockTracker) NewChannel(channel string) *Channel {
 	ret := _m.ctrl.Call(_m, "NewChannel", channel)
 	ret0, _ := ret[0].(*Channel)
@@ -153,6 +173,24 @@ func (_mr *_MockTrackerRecorder) Me() *gomock.Call {
 	return _mr.mock.ctrl.RecordCall(_mr.mock, "Me")
 }
 
+func (_m *MockTracker) CaseMapping() CaseMapping {
+	ret := _m.ctrl.Call(_m, "CaseMapping")
+	ret0, _ := ret[0].(CaseMapping)
+	return ret0
+}
+
+func (_mr *_MockTrackerRecorder) CaseMapping() *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "CaseMapping")
+}
+
+func (_m *MockTracker) SetCaseMapping(cm CaseMapping) {
+	_m.ctrl.Call(_m, "SetCaseMapping", cm)
+}
+
+func (_mr *_MockTrackerRecorder) SetCaseMapping(arg0 interface{}) *gomock.Call {
+	return _mr.mock.ctrl.RecordCall(_mr.mock, "SetCaseMapping", arg0)
+}
+
 func (_m *MockTracker) IsOn(channel string, nick string) (*ChanPrivs, bool) {
 	ret := _m.ctrl.Call(_m, "IsOn", channel, nick)
 	ret0, _ := ret[0].(*ChanPrivs)
@@ -10,16 +10,28 @@ import (
 // a copy of the nick state at a particular time.
 type Nick struct {
 	Nick, Ident, Host, Name string
-	Modes                   *NickMode
-	Channels                map[string]*ChanPrivs
+	// The services account the nick is logged in to, or "" if it is
+	// not logged in or this isn't known.
+	Account string
+	// Whether the nick is marked as away, and the away message, which
+	// may not be known even if the nick is away.
+	Away     bool
+	AwayMsg  string
+	Modes    *NickMode
+	Channels map[string]*ChanPrivs
 }
 
 // Internal bookkeeping struct for nicks.
 type nick struct {
 	nick, ident, host, name string
+	account                 string
+	away                    bool
+	awayMsg                 string
 	modes                   *NickMode
 	lookup                  map[string]*channel
 	chans                   map[*channel]*ChanPrivs
+	// Casemapping used to fold the keys of lookup.
+	cm CaseMapping
 }
 
 // A struct representing the modes of an IRC Nick (User Modes)
@@ -70,6 +82,9 @@ func (nk *nick) Nick() *Nick {
 		Ident:    nk.ident,
 		Host:     nk.host,
 		Name:     nk.name,
+		Account:  nk.account,
+		Away:     nk.away,
+		AwayMsg:  nk.awayMsg,
 		Modes:    nk.modes.Copy(),
 		Channels: make(map[string]*ChanPrivs, len(nk.chans)),
 	}
@@ -88,7 +103,7 @@ func (nk *nick) isOn(ch *channel) (*ChanPrivs, bool) {
 func (nk *nick) addChannel(ch *channel, cp *ChanPrivs) {
 	if _, ok := nk.chans[ch]; !ok {
 		nk.chans[ch] = cp
-		nk.lookup[ch.name] = ch
+		nk.lookup[nk.cm.Fold(ch.name)] = ch
 	} else {
 		logging.Warn("Nick.addChannel(): %s already on %s.", nk.nick, ch.name)
 	}
@@ -98,12 +113,21 @@ func (nk *nick) addChannel(ch *channel, cp *ChanPrivs) {
 func (nk *nick) delChannel(ch *channel) {
 	if _, ok := nk.chans[ch]; ok {
 		delete(nk.chans, ch)
-		delete(nk.lookup, ch.name)
+		delete(nk.lookup, nk.cm.Fold(ch.name))
 	} else {
 		logging.Warn("Nick.delChannel(): %s not on %s.", nk.nick, ch.name)
 	}
 }
 
+// Rebuilds the lookup map with a new casemapping.
+func (nk *nick) setCaseMapping(cm CaseMapping) {
+	nk.cm = cm
+	nk.lookup = make(map[string]*channel, len(nk.chans))
+	for ch := range nk.chans {
+		nk.lookup[cm.Fold(ch.name)] = ch
+	}
+}
+
 // Parse mode strings for a Nick.
 func (nk *nick) parseModes(modes string) {
 	var modeop bool // true => add mode, false => remove mode
@@ -161,6 +185,8 @@ func (nm *NickMode) Equals(other *NickMode) bool {
 //	Nick: <nick name> e.g. CowMaster
 //	Hostmask: <ident@host> e.g. moo@cows.org
 //	Real Name: <real name> e.g. Steve "CowMaster" Bush
+//	Account: <account> e.g. CowMaster
+//	Away: <away message> e.g. Gone milking
 //	Modes: <nick modes> e.g. +z
 //	Channels:
 //		<channel>: <privs> e.g. #moo: +o
@@ -169,6 +195,12 @@ func (nk *Nick) String() string {
 	str := "Nick: " + nk.Nick + "\n\t"
 	str += "Hostmask: " + nk.Ident + "@" + nk.Host + "\n\t"
 	str += "Real Name: " + nk.Name + "\n\t"
+	if nk.Account != "" {
+		str += "Account: " + nk.Account + "\n\t"
+	}
+	if nk.Away {
+		str += "Away: " + nk.AwayMsg + "\n\t"
+	}
 	str += "Modes: " + nk.Modes.String() + "\n\t"
 	str += "Channels: \n"
 	for ch, cp := range nk.Channels {
@@ -15,6 +15,8 @@ type Tracker interface {
 	DelNick(nick string) *Nick
 	NickInfo(nick, ident, host, name string) *Nick
 	NickModes(nick, modestr string) *Nick
+	NickAccount(nick, account string) *Nick
+	NickAway(nick string, away bool, message string) *Nick
 	// Channel methods
 	NewChannel(channel string) *Channel
 	GetChannel(channel string) *Channel
@@ -23,6 +25,9 @@ type Tracker interface {
 	ChannelModes(channel, modestr string, modeargs ...string) *Channel
 	// Information about ME!
 	Me() *Nick
+	// Casemapping used to compare nicks and channel names
+	CaseMapping() CaseMapping
+	SetCaseMapping(cm CaseMapping)
 	// And the tracking operations
 	IsOn(channel, nick string) (*ChanPrivs, bool)
 	Associate(channel, nick string) *ChanPrivs
@@ -34,11 +39,14 @@ type Tracker interface {
 
 // ... and a struct to implement it ...
 type stateTracker struct {
-	// Map of channels we're on
+	// Map of channels we're on, keyed by casefolded name
 	chans map[string]*channel
-	// Map of nicks we know about
+	// Map of nicks we know about, keyed by casefolded nick
 	nicks map[string]*nick
 
+	// Casemapping used to fold the keys of the above maps
+	cm CaseMapping
+
 	// We need to keep state on who we are :-)
 	me *nick
 
@@ -55,11 +63,11 @@ func NewTracker(mynick string) *stateTracker {
 		nicks: make(map[string]*nick),
 	}
 	st.me = newNick(mynick)
-	st.nicks[mynick] = st.me
+	st.nicks[st.cm.Fold(mynick)] = st.me
 	return st
 }
 
-// ... and a method to wipe the state clean.
+// ... and a method to wipe the state clean, ready for a new connection.
 func (st *stateTracker) Wipe() {
 	st.mu.Lock()
 	defer st.mu.Unlock()
@@ -67,6 +75,8 @@ func (st *stateTracker) Wipe() {
 	for _, ch := range st.chans {
 		st.delChannel(ch)
 	}
+	// The next server may not use the same casemapping.
+	st.setCaseMapping(RFC1459)
 }
 
 /******************************************************************************\
@@ -82,47 +92,52 @@ func (st *stateTracker) NewNick(n string) *Nick {
 	}
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	if _, ok := st.nicks[n]; ok {
+	key := st.cm.Fold(n)
+	if _, ok := st.nicks[key]; ok {
 		logging.Warn("Tracker.NewNick(): %s already tracked.", n)
 		return nil
 	}
-	st.nicks[n] = newNick(n)
-	return st.nicks[n].Nick()
+	nk := newNick(n)
+	nk.cm = st.cm
+	st.nicks[key] = nk
+	return nk.Nick()
 }
 
 // Returns a nick for the nick n, if we're tracking it.
 func (st *stateTracker) GetNick(n string) *Nick {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	if nk, ok := st.nicks[n]; ok {
+	if nk, ok := st.nicks[st.cm.Fold(n)]; ok {
 		return nk.Nick()
 	}
 	return nil
 }
 
 // Signals to the tracker that a nick should be tracked
-// under a "neu" nick rather than the old one.
+// under a "neu" nick rather than the old one. Changing
+// only the case of a nick is allowed.
 func (st *stateTracker) ReNick(old, neu string) *Nick {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	nk, ok := st.nicks[old]
+	okey, nkey := st.cm.Fold(old), st.cm.Fold(neu)
+	nk, ok := st.nicks[okey]
 	if !ok {
 		logging.Warn("Tracker.ReNick(): %s not tracked.", old)
 		return nil
 	}
-	if _, ok := st.nicks[neu]; ok {
+	if other, ok := st.nicks[nkey]; ok && other != nk {
 		logging.Warn("Tracker.ReNick(): %s already exists.", neu)
 		return nil
 	}
 
 	nk.nick = neu
-	delete(st.nicks, old)
-	st.nicks[neu] = nk
+	delete(st.nicks, okey)
+	st.nicks[nkey] = nk
 	for ch, _ := range nk.chans {
 		// We also need to update the lookup maps of all the channels
 		// the nick is on, to keep things in sync.
-		delete(ch.lookup, old)
-		ch.lookup[neu] = nk
+		delete(ch.lookup, okey)
+		ch.lookup[nkey] = nk
 	}
 	return nk.Nick()
 }
@@ -131,7 +146,7 @@ func (st *stateTracker) ReNick(old, neu string) *Nick {
 func (st *stateTracker) DelNick(n string) *Nick {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	if nk, ok := st.nicks[n]; ok {
+	if nk, ok := st.nicks[st.cm.Fold(n)]; ok {
 		if nk == st.me {
 			logging.Warn("Tracker.DelNick(): won't delete myself.")
 			return nil
@@ -150,7 +165,7 @@ func (st *stateTracker) delNick(nk *nick) {
 		logging.Error("Tracker.DelNick(): TRYING TO DELETE ME :-(")
 		return
 	}
-	delete(st.nicks, nk.nick)
+	delete(st.nicks, st.cm.Fold(nk.nick))
 	for ch, _ := range nk.chans {
 		nk.delChannel(ch)
 		ch.delNick(nk)
@@ -167,7 +182,7 @@ func (st *stateTracker) delNick(nk *nick) {
 func (st *stateTracker) NickInfo(n, ident, host, name string) *Nick {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	nk, ok := st.nicks[n]
+	nk, ok := st.nicks[st.cm.Fold(n)]
 	if !ok {
 		return nil
 	}
@@ -181,7 +196,7 @@ func (st *stateTracker) NickInfo(n, ident, host, name string) *Nick {
 func (st *stateTracker) NickModes(n, modes string) *Nick {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	nk, ok := st.nicks[n]
+	nk, ok := st.nicks[st.cm.Fold(n)]
 	if !ok {
 		return nil
 	}
@@ -189,6 +204,37 @@ func (st *stateTracker) NickModes(n, modes string) *Nick {
 	return nk.Nick()
 }
 
+// Sets the services account the nick is logged in to. An account of ""
+// or "*" means the nick is logged out.
+func (st *stateTracker) NickAccount(n, account string) *Nick {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	nk, ok := st.nicks[st.cm.Fold(n)]
+	if !ok {
+		return nil
+	}
+	if account == "*" {
+		account = ""
+	}
+	nk.account = account
+	return nk.Nick()
+}
+
+// Sets whether the nick is away, and its away message.
+func (st *stateTracker) NickAway(n string, away bool, message string) *Nick {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	nk, ok := st.nicks[st.cm.Fold(n)]
+	if !ok {
+		return nil
+	}
+	if !away {
+		message = ""
+	}
+	nk.away, nk.awayMsg = away, message
+	return nk.Nick()
+}
+
 // Creates a new Channel, initialises it, and stores it so it
 // can be properly tracked for state management purposes.
 func (st *stateTracker) NewChannel(c string) *Channel {
@@ -198,19 +244,22 @@ func (st *stateTracker) NewChannel(c string) *Channel {
 	}
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	if _, ok := st.chans[c]; ok {
+	key := st.cm.Fold(c)
+	if _, ok := st.chans[key]; ok {
 		logging.Warn("Tracker.NewChannel(): %s already tracked.", c)
 		return nil
 	}
-	st.chans[c] = newChannel(c)
-	return st.chans[c].Channel()
+	ch := newChannel(c)
+	ch.cm = st.cm
+	st.chans[key] = ch
+	return ch.Channel()
 }
 
 // Returns a Channel for the channel c, if we're tracking it.
 func (st *stateTracker) GetChannel(c string) *Channel {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	if ch, ok := st.chans[c]; ok {
+	if ch, ok := st.chans[st.cm.Fold(c)]; ok {
 		return ch.Channel()
 	}
 	return nil
@@ -220,7 +269,7 @@ func (st *stateTracker) GetChannel(c string) *Channel {
 func (st *stateTracker) DelChannel(c string) *Channel {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	if ch, ok := st.chans[c]; ok {
+	if ch, ok := st.chans[st.cm.Fold(c)]; ok {
 		st.delChannel(ch)
 		return ch.Channel()
 	}
@@ -230,7 +279,7 @@ func (st *stateTracker) DelChannel(c string) *Channel {
 
 func (st *stateTracker) delChannel(ch *channel) {
 	// st.mu lock held by DelChannel or Wipe
-	delete(st.chans, ch.name)
+	delete(st.chans, st.cm.Fold(ch.name))
 	for nk, _ := range ch.nicks {
 		ch.delNick(nk)
 		nk.delChannel(ch)
@@ -245,7 +294,7 @@ func (st *stateTracker) delChannel(ch *channel) {
 func (st *stateTracker) Topic(c, topic string) *Channel {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	ch, ok := st.chans[c]
+	ch, ok := st.chans[st.cm.Fold(c)]
 	if !ok {
 		return nil
 	}
@@ -257,7 +306,7 @@ func (st *stateTracker) Topic(c, topic string) *Channel {
 func (st *stateTracker) ChannelModes(c, modes string, args ...string) *Channel {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	ch, ok := st.chans[c]
+	ch, ok := st.chans[st.cm.Fold(c)]
 	if !ok {
 		return nil
 	}
@@ -273,13 +322,56 @@ func (st *stateTracker) Me() *Nick {
 	return st.me.Nick()
 }
 
+// Returns the casemapping used to compare nicks and channel names.
+func (st *stateTracker) CaseMapping() CaseMapping {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.cm
+}
+
+// Changes the casemapping used to compare nicks and channel names,
+// e.g. when the server advertises CASEMAPPING in RPL_ISUPPORT. Nicks
+// and channels that are already tracked keep their case.
+func (st *stateTracker) SetCaseMapping(cm CaseMapping) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.setCaseMapping(cm)
+}
+
+func (st *stateTracker) setCaseMapping(cm CaseMapping) {
+	// st.mu lock held by SetCaseMapping or Wipe
+	if cm == st.cm {
+		return
+	}
+	st.cm = cm
+	nicks := make(map[string]*nick, len(st.nicks))
+	for _, nk := range st.nicks {
+		nk.setCaseMapping(cm)
+		if _, ok := nicks[cm.Fold(nk.nick)]; ok {
+			logging.Warn("Tracker.SetCaseMapping(): nick %s is ambiguous "+
+				"under casemapping %s.", nk.nick, cm)
+		}
+		nicks[cm.Fold(nk.nick)] = nk
+	}
+	chans := make(map[string]*channel, len(st.chans))
+	for _, ch := range st.chans {
+		ch.setCaseMapping(cm)
+		if _, ok := chans[cm.Fold(ch.name)]; ok {
+			logging.Warn("Tracker.SetCaseMapping(): channel %s is ambiguous "+
+				"under casemapping %s.", ch.name, cm)
+		}
+		chans[cm.Fold(ch.name)] = ch
+	}
+	st.nicks, st.chans = nicks, chans
+}
+
 // Returns true if both the channel c and the nick n are tracked
 // and the nick is associated with the channel.
 func (st *stateTracker) IsOn(c, n string) (*ChanPrivs, bool) {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	nk, nok := st.nicks[n]
-	ch, cok := st.chans[c]
+	nk, nok := st.nicks[st.cm.Fold(n)]
+	ch, cok := st.chans[st.cm.Fold(c)]
 	if nok && cok {
 		return nk.isOn(ch)
 	}
@@ -290,8 +382,8 @@ func (st *stateTracker) IsOn(c, n string) (*ChanPrivs, bool) {
 func (st *stateTracker) Associate(c, n string) *ChanPrivs {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	nk, nok := st.nicks[n]
-	ch, cok := st.chans[c]
+	nk, nok := st.nicks[st.cm.Fold(n)]
+	ch, cok := st.chans[st.cm.Fold(c)]
 
 	if !cok {
 		// As we can implicitly delete both nicks and channels from being
@@ -321,8 +413,8 @@ func (st *stateTracker) Associate(c, n string) *ChanPrivs {
 func (st *stateTracker) Dissociate(c, n string) {
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	nk, nok := st.nicks[n]
-	ch, cok := st.chans[c]
+	nk, nok := st.nicks[st.cm.Fold(n)]
+	ch, cok := st.chans[st.cm.Fold(c)]
 
 	if !cok {
 		// As we can implicitly delete both nicks and channels from being
@@ -205,6 +205,54 @@ func TestSTNickInfo(t *testing.T) {
 	}
 }
 
+func TestSTNickAccount(t *testing.T) {
+	st := NewTracker("mynick")
+	test1 := st.NewNick("test1")
+	test2 := st.NickAccount("test1", "acct")
+	test3 := st.GetNick("test1")
+
+	if test1.Equals(test2) {
+		t.Errorf("NickAccount did not return modified nick.")
+	}
+	if !test3.Equals(test2) {
+		t.Errorf("Getting nick after NickAccount returned different nick.")
+	}
+	if test2.Account != "acct" {
+		t.Errorf("NickAccount did not set account correctly.")
+	}
+	if test4 := st.NickAccount("test1", "*"); test4.Account != "" {
+		t.Errorf("NickAccount did not log nick out.")
+	}
+
+	if fail := st.NickAccount("test2", "acct"); fail != nil {
+		t.Errorf("NickAccount for nonexistent nick did not return nil.")
+	}
+}
+
+func TestSTNickAway(t *testing.T) {
+	st := NewTracker("mynick")
+	test1 := st.NewNick("test1")
+	test2 := st.NickAway("test1", true, "gone")
+	test3 := st.GetNick("test1")
+
+	if test1.Equals(test2) {
+		t.Errorf("NickAway did not return modified nick.")
+	}
+	if !test3.Equals(test2) {
+		t.Errorf("Getting nick after NickAway returned different nick.")
+	}
+	if !test2.Away || test2.AwayMsg != "gone" {
+		t.Errorf("NickAway did not set away status correctly.")
+	}
+	if test4 := st.NickAway("test1", false, "ignored"); test4.Away || test4.AwayMsg != "" {
+		t.Errorf("NickAway did not clear away status correctly.")
+	}
+
+	if fail := st.NickAway("test2", true, "gone"); fail != nil {
+		t.Errorf("NickAway for nonexistent nick did not return nil.")
+	}
+}
+
 func TestSTNickModes(t *testing.T) {
 	st := NewTracker("mynick")
 	test1 := st.NewNick("test1")
@@ -562,3 +610,59 @@ func TestSTWipe(t *testing.T) {
 		t.Errorf("Nick chan lists wrong length after wipe.")
 	}
 }
+
+func TestSTCaseMapping(t *testing.T) {
+	st := NewTracker("MyNick")
+	if st.CaseMapping() != RFC1459 {
+		t.Errorf("Default casemapping is not rfc1459.")
+	}
+
+	st.NewNick("Foo[]")
+	st.NewChannel("#Go-Nuts")
+	st.Associate("#go-nuts", "foo{}")
+	st.Associate("#GO-NUTS", "mynick")
+
+	if st.NewNick("FOO{}") != nil || st.NewChannel("#go-nuts") != nil {
+		t.Errorf("Created duplicate nick or channel differing only in case.")
+	}
+	if n := st.GetNick("foo{}"); n == nil || n.Nick != "Foo[]" {
+		t.Errorf("GetNick not casemapping-aware or lost display case.")
+	}
+	if c := st.GetChannel("#go-nuts"); c == nil || c.Name != "#Go-Nuts" {
+		t.Errorf("GetChannel not casemapping-aware or lost display case.")
+	}
+	if _, ok := st.IsOn("#GO-nuts", "FOO[]"); !ok {
+		t.Errorf("IsOn not casemapping-aware.")
+	}
+	if c := st.ChannelModes("#go-nuts", "+o", "fOO{]"); c == nil || !c.Nicks["Foo[]"].Op {
+		t.Errorf("ChannelModes not casemapping-aware.")
+	}
+
+	// Changing only the case of a nick is allowed.
+	if n := st.ReNick("foo{}", "FOO[]"); n == nil || n.Nick != "FOO[]" {
+		t.Errorf("ReNick failed to change case of nick.")
+	}
+	if len(st.nicks) != 2 || len(st.chans["#go-nuts"].lookup) != 2 {
+		t.Errorf("ReNick changed size of nick or lookup lists.")
+	}
+	if n := st.ReNick("mynick", "mYnICK"); n == nil || st.Me().Nick != "mYnICK" {
+		t.Errorf("ReNick failed to change case of my nick.")
+	}
+
+	// Under ascii, [] and {} are different.
+	st.SetCaseMapping(ASCII)
+	if st.GetNick("foo{}") != nil || st.GetNick("foo[]") == nil {
+		t.Errorf("Lookups not rekeyed on casemapping change.")
+	}
+	if _, ok := st.IsOn("#go-nuts", "Foo[]"); !ok {
+		t.Errorf("Channel lookup map not rekeyed on casemapping change.")
+	}
+	if n := st.NewNick("foo{}"); n == nil {
+		t.Errorf("Couldn't create nick distinct under ascii casemapping.")
+	}
+
+	st.Wipe()
+	if st.CaseMapping() != RFC1459 || st.GetNick("MYNICK") == nil {
+		t.Errorf("Wipe did not reset casemapping.")
+	}
+}